@@ -15,6 +15,7 @@ type Config struct {
 	sync.RWMutex
 	gcfg.Config
 
+	ApiCors      *Cors `json:"apiCors,omitempty" note:"管理接口的跨域资源共享，空表示允许任意来源"`
 	ReverseProxy Proxy `json:"reverseProxy" note:"反向代理配置"`
 }
 
@@ -65,6 +66,11 @@ func NewConfig() *Config {
 				},
 			},
 		},
+		ApiCors: &Cors{
+			Enable:  true,
+			Origins: []string{"*"},
+			Headers: []string{"content-type", "token"},
+		},
 		ReverseProxy: Proxy{
 			Servers: []*ProxyServer{
 				{
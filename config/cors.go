@@ -0,0 +1,50 @@
+package config
+
+import (
+	"github.com/csby/grps/proxy"
+	"time"
+)
+
+type Cors struct {
+	Enable        bool     `json:"enable" note:"是否启用跨域资源共享(CORS)，预检请求由代理直接应答"`
+	Origins       []string `json:"origins" note:"允许的来源，支持通配符(如: *、https://*.example.com)，允许携带凭据时不能为*"`
+	Methods       []string `json:"methods" note:"允许的方法，空表示GET、HEAD、POST、PUT、PATCH及DELETE"`
+	Headers       []string `json:"headers" note:"允许的请求头，空表示允许预检请求所请求的全部请求头"`
+	ExposeHeaders []string `json:"exposeHeaders" note:"允许脚本读取的响应头"`
+	Credentials   bool     `json:"credentials" note:"是否允许携带凭据(Cookie等)，启用时响应头以请求的来源代替通配符，来源不能为*"`
+	MaxAge        int      `json:"maxAge" note:"预检结果的缓存时间(秒)，0表示不指定"`
+}
+
+func (s *Cors) CopyFrom(source *Cors) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.Credentials = source.Credentials
+	s.MaxAge = source.MaxAge
+	s.Origins = make([]string, 0)
+	s.Origins = append(s.Origins, source.Origins...)
+	s.Methods = make([]string, 0)
+	s.Methods = append(s.Methods, source.Methods...)
+	s.Headers = make([]string, 0)
+	s.Headers = append(s.Headers, source.Headers...)
+	s.ExposeHeaders = make([]string, 0)
+	s.ExposeHeaders = append(s.ExposeHeaders, source.ExposeHeaders...)
+}
+
+// Proxy returns the cors policy, or nil when it is disabled.
+func (s *Cors) Proxy() *proxy.Cors {
+	if s == nil || !s.Enable {
+		return nil
+	}
+
+	return &proxy.Cors{
+		Origins:       s.Origins,
+		Methods:       s.Methods,
+		Headers:       s.Headers,
+		ExposeHeaders: s.ExposeHeaders,
+		Credentials:   s.Credentials,
+		MaxAge:        time.Duration(s.MaxAge) * time.Second,
+	}
+}
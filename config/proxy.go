@@ -79,7 +79,7 @@ func (s *Proxy) ModifyServer(server *ProxyServerEdit) error {
 
 	var item *ProxyServer = nil
 	id := server.Id
-	uid := fmt.Sprintf("%s:%s", server.IP, server.Port)
+	uid := server.UniqueId()
 	count := len(s.Servers)
 	for i := 0; i < count; i++ {
 		srv := s.Servers[i]
@@ -0,0 +1,46 @@
+package config
+
+const (
+	ProxyAuthTypeBasic   = "basic"
+	ProxyAuthTypeForward = "forward"
+)
+
+type ProxyAuthUser struct {
+	Name     string `json:"name" note:"用户名"`
+	Password string `json:"password" note:"密码，保存时转换为bcrypt哈希值，也可直接指定bcrypt哈希值"`
+}
+
+type ProxyAuth struct {
+	Type            string           `json:"type" note:"认证方式: 空-不认证; basic-HTTP基本认证，通过后删除Authorization请求头并以X-Forwarded-User请求头转发用户名; forward-转发认证，向认证服务发送子请求，2xx表示通过，否则将认证服务的响应返回客户端"`
+	Realm           string           `json:"realm" note:"认证域，basic时有效，空表示grps"`
+	Users           []*ProxyAuthUser `json:"users" note:"用户，basic时有效"`
+	Url             string           `json:"url" note:"认证服务地址(如: http://127.0.0.1:9091/verify)，forward时有效，子请求附带X-Forwarded-Method、X-Forwarded-Proto、X-Forwarded-Host、X-Forwarded-Uri及X-Forwarded-For请求头"`
+	RequestHeaders  []string         `json:"requestHeaders" note:"复制到子请求的请求头(如: Authorization、Cookie)，forward时有效，空表示全部"`
+	ResponseHeaders []string         `json:"responseHeaders" note:"认证通过时从子请求的响应复制到请求的头部(如: X-User)，forward时有效，客户端发送的同名请求头总是被替换"`
+	Timeout         int              `json:"timeout" note:"子请求超时(秒)，forward时有效，0表示默认值(10)"`
+}
+
+func (s *ProxyAuth) CopyFrom(source *ProxyAuth) {
+	if source == nil {
+		return
+	}
+
+	s.Type = source.Type
+	s.Realm = source.Realm
+	s.Url = source.Url
+	s.Timeout = source.Timeout
+	s.Users = make([]*ProxyAuthUser, 0)
+	for i := 0; i < len(source.Users); i++ {
+		item := source.Users[i]
+		if item != nil {
+			s.Users = append(s.Users, &ProxyAuthUser{
+				Name:     item.Name,
+				Password: item.Password,
+			})
+		}
+	}
+	s.RequestHeaders = make([]string, 0)
+	s.RequestHeaders = append(s.RequestHeaders, source.RequestHeaders...)
+	s.ResponseHeaders = make([]string, 0)
+	s.ResponseHeaders = append(s.ResponseHeaders, source.ResponseHeaders...)
+}
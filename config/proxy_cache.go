@@ -0,0 +1,32 @@
+package config
+
+type ProxyCache struct {
+	Enable               bool     `json:"enable" note:"是否启用响应缓存，仅缓存GET请求的响应，遵循Cache-Control、Expires、ETag、Last-Modified及Vary，启用认证时仅缓存Cache-Control为public、s-maxage或must-revalidate的响应"`
+	Size                 int      `json:"size" note:"内存缓存容量(MB)，超出时将最久未使用的响应移到磁盘缓存，0表示默认值(64)"`
+	DiskSize             int      `json:"diskSize" note:"磁盘缓存容量(MB)，超出时删除最久未使用的响应，0表示默认值(1024)"`
+	EntrySize            int      `json:"entrySize" note:"可缓存的最大响应体(KB)，0表示默认值(10240)"`
+	Folder               string   `json:"folder" note:"磁盘缓存目录，空表示仅使用内存缓存，进程启动后首次使用时清空，缓存设置未修改时重新加载路由保留已缓存的响应"`
+	KeyHeaders           []string `json:"keyHeaders" note:"缓存键包含的请求头，缓存键默认为域名、路径及查询参数"`
+	IgnoreQuery          bool     `json:"ignoreQuery" note:"缓存键是否忽略查询参数"`
+	Ttl                  int      `json:"ttl" note:"响应未指定缓存时间时的缓存时间(秒)，0表示不缓存"`
+	StaleWhileRevalidate int      `json:"staleWhileRevalidate" note:"过期后在该时间(秒)内返回过期响应并在后台重新验证，响应的Cache-Control指定时优先"`
+	StaleIfError         int      `json:"staleIfError" note:"过期后在该时间(秒)内目标失败(5xx或连接失败)时返回过期响应，响应的Cache-Control指定时优先"`
+}
+
+func (s *ProxyCache) CopyFrom(source *ProxyCache) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.Size = source.Size
+	s.DiskSize = source.DiskSize
+	s.EntrySize = source.EntrySize
+	s.Folder = source.Folder
+	s.IgnoreQuery = source.IgnoreQuery
+	s.Ttl = source.Ttl
+	s.StaleWhileRevalidate = source.StaleWhileRevalidate
+	s.StaleIfError = source.StaleIfError
+	s.KeyHeaders = make([]string, 0)
+	s.KeyHeaders = append(s.KeyHeaders, source.KeyHeaders...)
+}
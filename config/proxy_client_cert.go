@@ -0,0 +1,23 @@
+package config
+
+type ProxyClientCert struct {
+	Require  bool     `json:"require" note:"是否要求已验证的客户端证书，需服务器终止TLS并指定客户端CA，失败时返回403"`
+	Subjects []string `json:"subjects" note:"允许的证书主题，通用名称(CN)或可分辨名称(如: CN=client,O=example)，与允许的备用名称满足其一即可，均为空表示允许全部"`
+	Sans     []string `json:"sans" note:"允许的证书主题备用名称，DNS名称(可为通配符，如: *.example.com)、邮箱、IP地址或URI"`
+	Headers  bool     `json:"headers" note:"是否以请求头转发客户端证书信息: X-Client-Verify、X-Client-Cert(URL编码的PEM)、X-Client-Cert-Subject、X-Client-Cert-Issuer、X-Client-Cert-Serial、X-Client-Cert-Fingerprint(SHA256)及X-Client-Cert-San，客户端发送的同名请求头在所有路由上总是被删除"`
+	Tlv      bool     `json:"tlv" note:"是否在代理头部(版本2)中以TLV转发TLS连接信息: PP2_TYPE_AUTHORITY(SNI)及PP2_TYPE_SSL(版本、加密套件、客户端证书CN)"`
+}
+
+func (s *ProxyClientCert) CopyFrom(source *ProxyClientCert) {
+	if source == nil {
+		return
+	}
+
+	s.Require = source.Require
+	s.Headers = source.Headers
+	s.Tlv = source.Tlv
+	s.Subjects = make([]string, 0)
+	s.Subjects = append(s.Subjects, source.Subjects...)
+	s.Sans = make([]string, 0)
+	s.Sans = append(s.Sans, source.Sans...)
+}
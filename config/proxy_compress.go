@@ -0,0 +1,29 @@
+package config
+
+const (
+	ProxyEncodingGzip   = "gzip"
+	ProxyEncodingBrotli = "br"
+	ProxyEncodingZstd   = "zstd"
+)
+
+type ProxyCompress struct {
+	Enable    bool     `json:"enable" note:"是否压缩响应，根据客户端的Accept-Encoding协商编码，已压缩的响应不再压缩"`
+	Disable   bool     `json:"disable" note:"是否不压缩响应，仅目标设置有效，不使用服务器的压缩设置"`
+	Encodings []string `json:"encodings" note:"编码，按优先顺序: br-Brotli; zstd-Zstandard; gzip-Gzip; 空表示br、zstd、gzip"`
+	MinSize   int      `json:"minSize" note:"压缩的最小响应体(字节)，长度未知的流式响应总是压缩，0表示默认值(1024)"`
+	Types     []string `json:"types" note:"压缩的内容类型，支持通配子类型(如: text/*)，空表示常见的文本类型(html、css、javascript、json、xml、svg等)"`
+}
+
+func (s *ProxyCompress) CopyFrom(source *ProxyCompress) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.Disable = source.Disable
+	s.MinSize = source.MinSize
+	s.Encodings = make([]string, 0)
+	s.Encodings = append(s.Encodings, source.Encodings...)
+	s.Types = make([]string, 0)
+	s.Types = append(s.Types, source.Types...)
+}
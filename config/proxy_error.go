@@ -0,0 +1,23 @@
+package config
+
+type ProxyErrorPage struct {
+	Code        int    `json:"code" note:"状态码: 502-无法连接目标; 503-目标均不可用; 504-连接目标超时"`
+	File        string `json:"file" note:"页面模板文件路径，支持占位符: {code}-状态码; {status}-状态描述; {requestId}-请求ID; {time}-时间"`
+	ContentType string `json:"contentType" note:"内容类型，空表示根据文件扩展名识别"`
+}
+
+func copyProxyErrorPages(source []*ProxyErrorPage) []*ProxyErrorPage {
+	pages := make([]*ProxyErrorPage, 0)
+	for i := 0; i < len(source); i++ {
+		item := source[i]
+		if item != nil {
+			pages = append(pages, &ProxyErrorPage{
+				Code:        item.Code,
+				File:        item.File,
+				ContentType: item.ContentType,
+			})
+		}
+	}
+
+	return pages
+}
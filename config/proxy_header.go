@@ -0,0 +1,49 @@
+package config
+
+const (
+	ProxyHeaderActionSet    = "set"
+	ProxyHeaderActionAppend = "append"
+	ProxyHeaderActionRemove = "remove"
+)
+
+type ProxyHeaderRule struct {
+	Action string `json:"action" note:"操作: set-设置; append-追加; remove-删除"`
+	Name   string `json:"name" note:"名称"`
+	Value  string `json:"value" note:"值，支持变量: {clientIp}-客户端IP; {clientPort}-客户端端口; {host}-请求主机; {scheme}-协议; {sni}-TLS服务器名称; {linkId}-连接ID; {requestId}-请求ID; {server}-服务器名称; {route}-匹配的路由(目标的域名及路径)"`
+}
+
+type ProxyHeaders struct {
+	XForwarded bool               `json:"xForwarded" note:"是否添加X-Forwarded-For、X-Forwarded-Proto及X-Forwarded-Host请求头"`
+	XRealIP    bool               `json:"xRealIp" note:"是否添加X-Real-IP请求头"`
+	Forwarded  bool               `json:"forwarded" note:"是否添加Forwarded(RFC 7239)请求头"`
+	Request    []*ProxyHeaderRule `json:"request" note:"请求头规则，转发到目标前执行"`
+	Response   []*ProxyHeaderRule `json:"response" note:"响应头规则，返回客户端前执行"`
+}
+
+func (s *ProxyHeaders) CopyFrom(source *ProxyHeaders) {
+	if source == nil {
+		return
+	}
+
+	s.XForwarded = source.XForwarded
+	s.XRealIP = source.XRealIP
+	s.Forwarded = source.Forwarded
+	s.Request = copyProxyHeaderRules(source.Request)
+	s.Response = copyProxyHeaderRules(source.Response)
+}
+
+func copyProxyHeaderRules(source []*ProxyHeaderRule) []*ProxyHeaderRule {
+	rules := make([]*ProxyHeaderRule, 0)
+	for i := 0; i < len(source); i++ {
+		item := source[i]
+		if item != nil {
+			rules = append(rules, &ProxyHeaderRule{
+				Action: item.Action,
+				Name:   item.Name,
+				Value:  item.Value,
+			})
+		}
+	}
+
+	return rules
+}
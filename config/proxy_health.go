@@ -0,0 +1,10 @@
+package config
+
+type ProxyHealth struct {
+	Enable       bool `json:"enable" note:"是否启用被动健康检测"`
+	MaxFails     int  `json:"maxFails" note:"连续连接失败次数阈值，达到后隔离后端，0表示默认值(3)"`
+	MaxTimeouts  int  `json:"maxTimeouts" note:"连续连接超时次数阈值，达到后隔离后端，0表示默认值(3)"`
+	MaxErrors    int  `json:"maxErrors" note:"连续5xx响应次数阈值，达到后隔离后端，仅http有效，0表示默认值(5)"`
+	EjectTime    int  `json:"ejectTime" note:"首次隔离时长(秒)，连续隔离时逐次加倍，0表示默认值(30)"`
+	MaxEjectTime int  `json:"maxEjectTime" note:"最大隔离时长(秒)，0表示默认值(300)"`
+}
@@ -0,0 +1,81 @@
+package config
+
+type ProxyJwtKey struct {
+	Id     string `json:"id" note:"密钥标识(kid)，空表示匹配任意令牌"`
+	Public string `json:"public" note:"公钥或证书(PEM格式)，用于RS*、PS*、ES*及EdDSA算法"`
+	Secret string `json:"secret" note:"共享密钥，用于HS*算法，与公钥二选一"`
+}
+
+type ProxyJwtClaim struct {
+	Name  string `json:"name" note:"声明名称(如: scope)"`
+	Value string `json:"value" note:"声明值，空表示只要求存在，数组声明包含该值即可"`
+}
+
+type ProxyJwtHeader struct {
+	Claim  string `json:"claim" note:"声明名称(如: sub)"`
+	Header string `json:"header" note:"请求头名称(如: X-User)，客户端发送的同名请求头总是被删除"`
+}
+
+type ProxyJwt struct {
+	Enable     bool              `json:"enable" note:"是否要求Bearer令牌(JWT)，仅http有效，在认证之后执行，失败时返回401及WWW-Authenticate头部"`
+	Keys       []*ProxyJwtKey    `json:"keys" note:"静态密钥"`
+	JwksUrl    string            `json:"jwksUrl" note:"JWKS文档地址(如: https://idp.example.com/.well-known/jwks.json)"`
+	Discovery  bool              `json:"discovery" note:"是否通过签发者的OIDC配置(/.well-known/openid-configuration)获取JWKS文档地址，JWKS文档地址为空时有效"`
+	Refresh    int               `json:"refresh" note:"JWKS文档刷新间隔(秒)，0表示默认值(3600)，遇到未知的密钥标识时提前刷新(间隔不少于30秒)"`
+	Issuer     string            `json:"issuer" note:"签发者(iss)，空表示不检查"`
+	Audience   []string          `json:"audience" note:"受众(aud)，包含其中之一即可，空表示不检查"`
+	Algorithms []string          `json:"algorithms" note:"允许的签名算法(如: RS256)，空表示全部非对称算法，存在共享密钥时包括HS*"`
+	Leeway     int               `json:"leeway" note:"过期时间(exp)等检查允许的时钟偏差(秒)"`
+	Claims     []*ProxyJwtClaim  `json:"claims" note:"必须的声明"`
+	Headers    []*ProxyJwtHeader `json:"headers" note:"转发到目标的声明，字符串原样转发，数组以逗号连接，对象为JSON"`
+	Realm      string            `json:"realm" note:"认证域，空表示grps"`
+}
+
+func (s *ProxyJwt) CopyFrom(source *ProxyJwt) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.JwksUrl = source.JwksUrl
+	s.Discovery = source.Discovery
+	s.Refresh = source.Refresh
+	s.Issuer = source.Issuer
+	s.Leeway = source.Leeway
+	s.Realm = source.Realm
+	s.Audience = make([]string, 0)
+	s.Audience = append(s.Audience, source.Audience...)
+	s.Algorithms = make([]string, 0)
+	s.Algorithms = append(s.Algorithms, source.Algorithms...)
+	s.Keys = make([]*ProxyJwtKey, 0)
+	for i := 0; i < len(source.Keys); i++ {
+		item := source.Keys[i]
+		if item != nil {
+			s.Keys = append(s.Keys, &ProxyJwtKey{
+				Id:     item.Id,
+				Public: item.Public,
+				Secret: item.Secret,
+			})
+		}
+	}
+	s.Claims = make([]*ProxyJwtClaim, 0)
+	for i := 0; i < len(source.Claims); i++ {
+		item := source.Claims[i]
+		if item != nil {
+			s.Claims = append(s.Claims, &ProxyJwtClaim{
+				Name:  item.Name,
+				Value: item.Value,
+			})
+		}
+	}
+	s.Headers = make([]*ProxyJwtHeader, 0)
+	for i := 0; i < len(source.Headers); i++ {
+		item := source.Headers[i]
+		if item != nil {
+			s.Headers = append(s.Headers, &ProxyJwtHeader{
+				Claim:  item.Claim,
+				Header: item.Header,
+			})
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package config
+
+type ProxyLimits struct {
+	MaxHeaderSize int `json:"maxHeaderSize" note:"请求头最大大小(KB)，超过时返回431，0表示不限制(默认1MB)"`
+	MaxBodySize   int `json:"maxBodySize" note:"请求体最大大小(KB)，超过时返回413，0表示不限制"`
+	MinUploadRate int `json:"minUploadRate" note:"请求体最低上传速率(字节/秒)，低于时返回408，0表示不限制"`
+	UploadTimeout int `json:"uploadTimeout" note:"请求体上传超时(秒)，每收到数据按最低上传速率延长，最低上传速率不为0时有效，0表示默认值(10)"`
+	MaxIncomplete int `json:"maxIncomplete" note:"每个客户端IP未完成请求头的最大连接数，超过时直接关闭新连接，仅服务器设置有效，0表示不限制，读取请求头超时见超时设置"`
+}
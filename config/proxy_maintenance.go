@@ -0,0 +1,32 @@
+package config
+
+import "github.com/csby/gwsf/gtype"
+
+type ProxyMaintenance struct {
+	Enable      bool            `json:"enable" note:"是否启用维护模式"`
+	Code        int             `json:"code" note:"状态码，0表示默认值(503)，仅http有效"`
+	ContentType string          `json:"contentType" note:"内容类型，如: text/html; charset=utf-8，空表示自动识别，仅http有效"`
+	Body        string          `json:"body" note:"响应内容，与文件二选一，均为空时使用内置页面，仅http有效"`
+	File        string          `json:"file" note:"响应内容文件路径，与响应内容二选一，仅http有效"`
+	RetryAfter  int             `json:"retryAfter" note:"建议重试间隔(秒)，用于Retry-After头部，0表示使用结束时间(如有)，仅http有效"`
+	Whitelist   []string        `json:"whitelist" note:"白名单，允许正常访问的IP地址或网段，如: 192.168.1.10或192.168.1.0/24"`
+	StartTime   *gtype.DateTime `json:"startTime" note:"开始时间，空表示立即开始"`
+	EndTime     *gtype.DateTime `json:"endTime" note:"结束时间，空表示持续到关闭维护模式"`
+}
+
+func (s *ProxyMaintenance) CopyFrom(source *ProxyMaintenance) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.Code = source.Code
+	s.ContentType = source.ContentType
+	s.Body = source.Body
+	s.File = source.File
+	s.RetryAfter = source.RetryAfter
+	s.StartTime = source.StartTime
+	s.EndTime = source.EndTime
+	s.Whitelist = make([]string, len(source.Whitelist))
+	copy(s.Whitelist, source.Whitelist)
+}
@@ -0,0 +1,15 @@
+package config
+
+type ProxyMirror struct {
+	Enable      bool    `json:"enable" note:"是否将流量镜像到影子目标，丢弃影子目标的响应，http在请求完成后发送副本，tcp镜像客户端发送的全部数据"`
+	IP          string  `json:"ip" note:"影子目标地址，unix:路径表示Unix套接字"`
+	Port        string  `json:"port" note:"影子目标端口，Unix套接字时为空"`
+	Percent     float64 `json:"percent" note:"镜像的请求(tcp为连接)百分比，0-100"`
+	Timeout     int     `json:"timeout" note:"影子目标的连接及响应超时(秒)，0表示默认值(10)"`
+	MaxBodySize int     `json:"maxBodySize" note:"镜像的最大请求体(KB)，超过时跳过该请求，仅http有效，0表示默认值(1024)"`
+	Concurrency int     `json:"concurrency" note:"镜像的最大并发数，超过时丢弃，0表示默认值(64)"`
+}
+
+func (s *ProxyMirror) Address() string {
+	return proxyAddress(s.IP, s.Port)
+}
@@ -0,0 +1,6 @@
+package config
+
+type ProxyRedirect struct {
+	Code     int    `json:"code" note:"状态码: 301、302、303、307或308，0表示默认值(302)"`
+	Location string `json:"location" note:"跳转地址模板，支持占位符: {scheme}-协议; {host}-主机(含端口); {hostname}-主机名; {port}-端口; {path}-路径; {query}-查询参数; {uri}-路径及查询参数，如: https://{hostname}{uri}"`
+}
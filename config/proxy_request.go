@@ -0,0 +1,7 @@
+package config
+
+type ProxyRequestId struct {
+	Enable bool   `json:"enable" note:"是否启用请求ID，启用后转发到目标并返回给客户端，仅http有效"`
+	Header string `json:"header" note:"请求ID头部名称，空表示默认值(X-Request-Id)"`
+	Trust  bool   `json:"trust" note:"是否沿用客户端传入的请求ID，否则总是重新生成"`
+}
@@ -0,0 +1,6 @@
+package config
+
+type ProxyResolve struct {
+	Refresh int  `json:"refresh" note:"域名重新解析间隔(秒)，0表示默认值(30)，在后台定时解析，请求使用最近一次的解析结果，解析失败时沿用上次的解析结果"`
+	Srv     bool `json:"srv" note:"是否使用SRV记录发现目标，目标地址为SRV记录名称(如: _http._tcp.example.com)且端口为空"`
+}
@@ -0,0 +1,37 @@
+package config
+
+const (
+	ProxyRetryBackendNext = "next"
+	ProxyRetryBackendSame = "same"
+
+	ProxyRetryOnConnect = "connect"
+	ProxyRetryOn502     = "502"
+	ProxyRetryOn503     = "503"
+	ProxyRetryOn504     = "504"
+	ProxyRetryOnReset   = "reset"
+)
+
+type ProxyRetry struct {
+	Enable     bool     `json:"enable" note:"是否启用重试策略，未启用时连接失败依次尝试目标及备用目标各一次"`
+	Attempts   int      `json:"attempts" note:"最大尝试次数(含首次)，0表示默认值(目标及备用目标数量，至少为2)"`
+	Backend    string   `json:"backend" note:"重试目标: next或空-下一个目标; same-同一目标"`
+	Backoff    int      `json:"backoff" note:"首次重试间隔(毫秒)，之后逐次加倍，0表示立即重试"`
+	MaxBackoff int      `json:"maxBackoff" note:"最大重试间隔(毫秒)，0表示不限制"`
+	RetryOn    []string `json:"retryOn" note:"重试条件: connect-连接失败; 502、503、504-目标响应的状态码(504含等待响应头超时)，仅http有效; reset-收到响应前连接断开，仅http有效; 空表示connect和reset。http仅重试不含请求体的幂等请求(GET、HEAD、OPTIONS、TRACE、PUT、DELETE)，gRPC调用仅在未连接到目标时重试"`
+	Budget     int      `json:"budget" note:"重试预算，10秒内重试次数占请求数的最大百分比(至少允许3次)，0表示默认值(20)"`
+}
+
+func (s *ProxyRetry) CopyFrom(source *ProxyRetry) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.Attempts = source.Attempts
+	s.Backend = source.Backend
+	s.Backoff = source.Backoff
+	s.MaxBackoff = source.MaxBackoff
+	s.Budget = source.Budget
+	s.RetryOn = make([]string, 0)
+	s.RetryOn = append(s.RetryOn, source.RetryOn...)
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+const (
+	proxyScheduleDays = 8 // the windows repeat weekly, one more day for the ones across midnight
+)
+
+type ProxyScheduleWindow struct {
+	Weekdays []int  `json:"weekdays" note:"星期，0-周日，1至6-周一至周六，空表示每天"`
+	Start    string `json:"start" note:"开始时间(HH:mm)"`
+	End      string `json:"end" note:"结束时间(HH:mm)，24:00表示当天结束，不晚于开始时间时表示跨越午夜至次日"`
+}
+
+type ProxySchedule struct {
+	Enable    bool                   `json:"enable" note:"是否按计划启用，启用时仅在计划时间内生效，其余时间视为已禁用"`
+	TimeZone  string                 `json:"timeZone" note:"时间窗口的时区，IANA名称(如: Asia/Shanghai)，空表示本地时区"`
+	StartTime *gtype.DateTime        `json:"startTime" note:"开始时间，空表示不限制，如计划的活动开始时间"`
+	EndTime   *gtype.DateTime        `json:"endTime" note:"结束时间，空表示不限制，如计划的活动结束时间"`
+	Windows   []*ProxyScheduleWindow `json:"windows" note:"时间窗口，在开始及结束时间之内且在任一窗口内时生效，空表示全天"`
+}
+
+func (s *ProxySchedule) CopyFrom(source *ProxySchedule) {
+	if source == nil {
+		return
+	}
+
+	s.Enable = source.Enable
+	s.TimeZone = source.TimeZone
+	s.StartTime = source.StartTime
+	s.EndTime = source.EndTime
+	s.Windows = make([]*ProxyScheduleWindow, 0)
+	for i := 0; i < len(source.Windows); i++ {
+		item := source.Windows[i]
+		if item == nil {
+			continue
+		}
+		window := &ProxyScheduleWindow{
+			Weekdays: make([]int, len(item.Weekdays)),
+			Start:    item.Start,
+			End:      item.End,
+		}
+		copy(window.Weekdays, item.Weekdays)
+		s.Windows = append(s.Windows, window)
+	}
+}
+
+func (s *ProxySchedule) Location() (*time.Location, error) {
+	if len(s.TimeZone) < 1 {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(s.TimeZone)
+}
+
+// Active reports whether the time is within the schedule, which is always
+// true when the schedule is not enabled.
+func (s *ProxySchedule) Active(t time.Time) bool {
+	if !s.Enable {
+		return true
+	}
+	if s.StartTime != nil && t.Before(time.Time(*s.StartTime)) {
+		return false
+	}
+	if s.EndTime != nil && !t.Before(time.Time(*s.EndTime)) {
+		return false
+	}
+	if len(s.Windows) < 1 {
+		return true
+	}
+
+	location, err := s.Location()
+	if err != nil {
+		location = time.Local
+	}
+	t = t.In(location)
+	minute := t.Hour()*60 + t.Minute()
+	weekday := int(t.Weekday())
+	yesterday := (weekday + 6) % 7
+	for i := 0; i < len(s.Windows); i++ {
+		window := s.Windows[i]
+		if window == nil {
+			continue
+		}
+		start, err := ParseScheduleClock(window.Start)
+		if err != nil {
+			continue
+		}
+		end, err := ParseScheduleClock(window.End)
+		if err != nil {
+			continue
+		}
+
+		if start < end {
+			if window.onDay(weekday) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		// the window goes on to the next day
+		if window.onDay(weekday) && minute >= start {
+			return true
+		}
+		if window.onDay(yesterday) && minute < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *ProxyScheduleWindow) onDay(weekday int) bool {
+	if len(s.Weekdays) < 1 {
+		return true
+	}
+	for i := 0; i < len(s.Weekdays); i++ {
+		if s.Weekdays[i] == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changes returns the times after the given one which the schedule may
+// become active or inactive at.
+func (s *ProxySchedule) changes(t time.Time) []time.Time {
+	times := make([]time.Time, 0)
+	if !s.Enable {
+		return times
+	}
+
+	from := t
+	if s.StartTime != nil {
+		start := time.Time(*s.StartTime)
+		times = append(times, start)
+		if start.After(from) {
+			from = start
+		}
+	}
+	if s.EndTime != nil {
+		times = append(times, time.Time(*s.EndTime))
+	}
+
+	location, err := s.Location()
+	if err != nil {
+		location = time.Local
+	}
+	// the windows are counted from the day before, its window may end today
+	days := []time.Time{t.In(location), from.In(location)}
+	for i := 0; i < len(days); i++ {
+		year, month, day := days[i].Date()
+		for d := -1; d <= proxyScheduleDays; d++ {
+			for j := 0; j < len(s.Windows); j++ {
+				window := s.Windows[j]
+				if window == nil {
+					continue
+				}
+				start, err := ParseScheduleClock(window.Start)
+				if err == nil {
+					times = append(times, time.Date(year, month, day+d, 0, start, 0, 0, location))
+				}
+				end, err := ParseScheduleClock(window.End)
+				if err == nil {
+					times = append(times, time.Date(year, month, day+d, 0, end, 0, 0, location))
+				}
+			}
+		}
+	}
+
+	return times
+}
+
+// ProxyScheduleNextChange returns the next time after the given one at which
+// the schedules all together become active or inactive, or nil when they
+// will not change.
+func ProxyScheduleNextChange(t time.Time, schedules ...*ProxySchedule) *time.Time {
+	active := func(v time.Time) bool {
+		for i := 0; i < len(schedules); i++ {
+			if !schedules[i].Active(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	times := make([]time.Time, 0)
+	for i := 0; i < len(schedules); i++ {
+		times = append(times, schedules[i].changes(t)...)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	current := active(t)
+	for i := 0; i < len(times); i++ {
+		if !times[i].After(t) {
+			continue
+		}
+		// the state keeps between the times, the first one different is the change
+		if active(times[i]) != current {
+			return &times[i]
+		}
+	}
+
+	return nil
+}
+
+// ParseScheduleClock returns the minutes of the day of the time in HH:mm,
+// which is up to 24:00.
+func ParseScheduleClock(value string) (int, error) {
+	hour, minute := 0, 0
+	n, err := fmt.Sscanf(value, "%d:%d", &hour, &minute)
+	if err != nil || n != 2 || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+
+	return hour*60 + minute, nil
+}
+
+// ProxyTargetInfo is the target with its effective state by the schedules of
+// the target and its server.
+type ProxyTargetInfo struct {
+	*ProxyTarget
+
+	Effective  bool            `json:"effective" note:"当前是否生效，服务器或目标已禁用或不在计划时间内时为false"`
+	NextChange *gtype.DateTime `json:"nextChange" note:"按计划下次切换生效状态的时间，空表示不会切换"`
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+// scheduleClock returns the time of the day in October 2026 in Shanghai, the
+// 19th is a Monday.
+func scheduleClock(t *testing.T, day, hour, minute int) time.Time {
+	t.Helper()
+	location, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	return time.Date(2026, time.October, day, hour, minute, 0, 0, location)
+}
+
+func scheduleTime(v time.Time) *gtype.DateTime {
+	value := gtype.DateTime(v)
+	return &value
+}
+
+func TestProxyScheduleActive(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return scheduleClock(t, day, hour, minute)
+	}
+	office := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}},
+	}
+	night := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Weekdays: []int{5}, Start: "22:00", End: "02:00"}},
+	}
+	late := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Start: "20:00", End: "24:00"}},
+	}
+	bounded := &ProxySchedule{
+		Enable:    true,
+		StartTime: scheduleTime(at(19, 12, 0)),
+		EndTime:   scheduleTime(at(20, 12, 0)),
+	}
+	newYork := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "America/New_York",
+		Windows:  office.Windows,
+	}
+	disabled := &ProxySchedule{Windows: office.Windows}
+
+	items := []struct {
+		name     string
+		schedule *ProxySchedule
+		time     time.Time
+		active   bool
+	}{
+		{"window start", office, at(19, 9, 0), true},
+		{"within window", office, at(19, 16, 59), true},
+		{"window end", office, at(19, 17, 0), false},
+		{"weekend", office, at(24, 10, 0), false},
+		{"other time zone of the instant", office, at(19, 9, 30).UTC(), true},
+		{"before midnight window", night, at(23, 21, 59), false},
+		{"midnight window start", night, at(23, 22, 0), true},
+		{"after midnight of the window day", night, at(24, 1, 59), true},
+		{"midnight window end", night, at(24, 2, 0), false},
+		{"after midnight of another day", night, at(23, 1, 0), false},
+		{"until the end of the day", late, at(19, 23, 59), true},
+		{"the next day", late, at(20, 0, 0), false},
+		{"before the start time", bounded, at(19, 11, 59), false},
+		{"start time", bounded, at(19, 12, 0), true},
+		{"before the end time", bounded, at(20, 11, 59), true},
+		{"end time", bounded, at(20, 12, 0), false},
+		{"window of the time zone", newYork, at(19, 10, 0), false},
+		{"not enabled", disabled, at(24, 10, 0), true},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if active := item.schedule.Active(item.time); active != item.active {
+			t.Errorf("%s: active %v at %v, want %v", item.name, active, item.time, item.active)
+		}
+	}
+}
+
+func TestProxyScheduleNextChange(t *testing.T) {
+	at := func(day, hour, minute int) *time.Time {
+		v := scheduleClock(t, day, hour, minute)
+		return &v
+	}
+	office := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}},
+	}
+	afternoon := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Start: "12:00", End: "20:00"}},
+	}
+	night := &ProxySchedule{
+		Enable:   true,
+		TimeZone: "Asia/Shanghai",
+		Windows:  []*ProxyScheduleWindow{{Weekdays: []int{5}, Start: "22:00", End: "02:00"}},
+	}
+	bounded := &ProxySchedule{
+		Enable:    true,
+		StartTime: scheduleTime(*at(19, 12, 0)),
+		EndTime:   scheduleTime(*at(20, 12, 0)),
+	}
+	campaign := &ProxySchedule{
+		Enable:    true,
+		TimeZone:  "Asia/Shanghai",
+		StartTime: scheduleTime(*at(21, 12, 0)),
+		Windows:   office.Windows,
+	}
+
+	items := []struct {
+		name      string
+		schedules []*ProxySchedule
+		time      *time.Time
+		next      *time.Time
+	}{
+		{"window end", []*ProxySchedule{office}, at(19, 10, 0), at(19, 17, 0)},
+		{"next window", []*ProxySchedule{office}, at(19, 18, 0), at(20, 9, 0)},
+		{"after the weekend", []*ProxySchedule{office}, at(23, 18, 0), at(26, 9, 0)},
+		{"across midnight", []*ProxySchedule{night}, at(23, 23, 0), at(24, 2, 0)},
+		{"a week on", []*ProxySchedule{night}, at(24, 3, 0), at(30, 22, 0)},
+		{"start time", []*ProxySchedule{bounded}, at(19, 8, 0), at(19, 12, 0)},
+		{"end time", []*ProxySchedule{bounded}, at(19, 13, 0), at(20, 12, 0)},
+		{"after the end time", []*ProxySchedule{bounded}, at(20, 13, 0), nil},
+		{"start time within a window", []*ProxySchedule{campaign}, at(20, 10, 0), at(21, 12, 0)},
+		{"both schedules active", []*ProxySchedule{office, afternoon}, at(19, 10, 0), at(19, 12, 0)},
+		{"either schedule ends", []*ProxySchedule{office, afternoon}, at(19, 13, 0), at(19, 17, 0)},
+		{"not enabled", []*ProxySchedule{{Windows: office.Windows}}, at(19, 10, 0), nil},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		next := ProxyScheduleNextChange(*item.time, item.schedules...)
+		if next == nil || item.next == nil {
+			if next != item.next {
+				t.Errorf("%s: next change %v, want %v", item.name, next, item.next)
+			}
+			continue
+		}
+		if !next.Equal(*item.next) {
+			t.Errorf("%s: next change %v, want %v", item.name, next.In(item.next.Location()), *item.next)
+		}
+	}
+}
@@ -3,6 +3,14 @@ package config
 import (
 	"fmt"
 	"github.com/csby/gwsf/gtype"
+	"time"
+)
+
+const (
+	ProxyProtocolTcp = "tcp"
+	ProxyProtocolUdp = "udp"
+
+	ProxyModeTcp = "tcp"
 )
 
 type ProxyServer struct {
@@ -11,8 +19,30 @@ type ProxyServer struct {
 	Disable bool   `json:"disable" note:"已禁用"`
 	TLS     bool   `json:"tls" note:"传入是否为TLS连接"`
 
-	IP   string `json:"ip" note:"监听地址，空表示所有IP地址"`
-	Port string `json:"port" note:"监听端口"`
+	Schedule ProxySchedule `json:"schedule" note:"启用计划，不在计划时间内时视为已禁用"`
+
+	Protocol string `json:"protocol" note:"协议: tcp或空-TCP; udp-UDP，按客户端地址保持会话并转发到目标及备用目标"`
+	Mode     string `json:"mode" note:"模式，仅tcp协议有效: 空-按域名(TLS为SNI，http为Host)及路径转发; tcp-不识别域名，所有连接转发到默认目标(第一个启用的目标)，忽略TLS设置"`
+	IP       string `json:"ip" note:"监听地址，空表示所有IP地址，支持IPv6地址(如: ::、::1、fe80::1%eth0)，unix:路径表示Unix套接字(如: unix:/run/grps/http.sock)"`
+	Port     string `json:"port" note:"监听端口，Unix套接字时为空"`
+	V6Only   bool   `json:"v6Only" note:"是否仅监听IPv6，监听地址为空或::时有效，否则同时接受IPv4连接"`
+
+	Socket ProxySocket `json:"socket" note:"Unix套接字文件设置，监听地址为Unix套接字时有效"`
+
+	Termination ProxyTermination `json:"termination" note:"TLS终止，传入为TLS连接时有效"`
+	Http2       bool             `json:"http2" note:"是否允许客户端使用HTTP/2，仅http服务器及终止TLS的服务器有效，TLS时通过ALPN协商h2，明文时为h2c(仅先验知识，不支持由HTTP/1.1升级，请求升级的客户端继续使用HTTP/1.1)"`
+
+	Maintenance ProxyMaintenance  `json:"maintenance" note:"维护模式"`
+	ErrorPages  []*ProxyErrorPage `json:"errorPages" note:"错误页面，仅http有效"`
+	Headers     ProxyHeaders      `json:"headers" note:"头部规则，仅http有效"`
+	Compress    ProxyCompress     `json:"compress" note:"响应压缩，仅http有效"`
+	RequestId   ProxyRequestId    `json:"requestId" note:"请求ID"`
+	AccessLog   bool              `json:"accessLog" note:"是否记录访问日志"`
+	Timeout     ProxyTimeout      `json:"timeout" note:"超时设置"`
+	Limits      ProxyLimits       `json:"limits" note:"请求限制，仅http有效"`
+
+	Throttle       ProxyThrottle `json:"throttle" note:"带宽限制，服务器全部客户端连接合计，UDP服务器不支持"`
+	ClientThrottle ProxyThrottle `json:"clientThrottle" note:"每个客户端IP的带宽限制，UDP服务器不支持"`
 
 	Targets []*ProxyTarget `json:"targets" note:"目标地址"`
 }
@@ -34,8 +64,67 @@ func (s *ProxyServer) initId() {
 	}
 }
 
+// IsEnabled reports whether the server is not disabled and the time is within its schedule.
+func (s *ProxyServer) IsEnabled(t time.Time) bool {
+	return !s.Disable && s.Schedule.Active(t)
+}
+
+// TargetInfo returns the target with its effective state at the time.
+func (s *ProxyServer) TargetInfo(target *ProxyTarget, t time.Time) *ProxyTargetInfo {
+	info := &ProxyTargetInfo{
+		ProxyTarget: target,
+		Effective:   s.IsEnabled(t) && target.IsEnabled(t),
+	}
+	if !s.Disable && !target.Disable {
+		next := ProxyScheduleNextChange(t, &s.Schedule, &target.Schedule)
+		if next != nil {
+			value := gtype.DateTime(*next)
+			info.NextChange = &value
+		}
+	}
+
+	return info
+}
+
 func (s *ProxyServer) UniqueId() string {
-	return fmt.Sprintf("%s:%s", s.IP, s.Port)
+	return proxyServerUniqueId(s.ServerProtocol(), s.IP, s.Port)
+}
+
+func (s *ProxyServer) Address() string {
+	return proxyAddress(s.IP, s.Port)
+}
+
+func (s *ProxyServer) ServerProtocol() string {
+	return proxyServerProtocol(s.Protocol)
+}
+
+func (s *ProxyServer) IsUdp() bool {
+	return s.ServerProtocol() == ProxyProtocolUdp
+}
+
+func (s *ProxyServer) IsTcpMode() bool {
+	return !s.IsUdp() && s.Mode == ProxyModeTcp
+}
+
+// IsHttp reports whether the requests are routed by host and path, which is
+// required by the targets answered by the proxy itself.
+func (s *ProxyServer) IsHttp() bool {
+	return (!s.TLS || s.Termination.Enable) && !s.IsUdp() && !s.IsTcpMode()
+}
+
+func (s *ProxyServer) HasHttpOnlyTarget() bool {
+	count := len(s.Targets)
+	for i := 0; i < count; i++ {
+		item := s.Targets[i]
+		if item == nil {
+			continue
+		}
+		if item.IsHttpOnly() {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (s *ProxyServer) AddTarget(target *ProxyTarget) error {
@@ -108,8 +197,47 @@ type ProxyServerAdd struct {
 	Name    string `json:"name" required:"true" note:"名称"`
 	Disable bool   `json:"disable" note:"已禁用"`
 	TLS     bool   `json:"tls" note:"传入是否为TLS连接"`
-	IP      string `json:"ip" note:"监听地址，空表示所有IP地址"`
-	Port    string `json:"port" required:"true" note:"监听端口"`
+
+	Schedule ProxySchedule `json:"schedule" note:"启用计划，不在计划时间内时视为已禁用"`
+
+	Protocol string `json:"protocol" note:"协议: tcp或空-TCP; udp-UDP，按客户端地址保持会话并转发到目标及备用目标"`
+	Mode     string `json:"mode" note:"模式，仅tcp协议有效: 空-按域名(TLS为SNI，http为Host)及路径转发; tcp-不识别域名，所有连接转发到默认目标(第一个启用的目标)，忽略TLS设置"`
+	IP       string `json:"ip" note:"监听地址，空表示所有IP地址，支持IPv6地址(如: ::、::1、fe80::1%eth0)，unix:路径表示Unix套接字(如: unix:/run/grps/http.sock)"`
+	Port     string `json:"port" note:"监听端口，Unix套接字时为空"`
+	V6Only   bool   `json:"v6Only" note:"是否仅监听IPv6，监听地址为空或::时有效，否则同时接受IPv4连接"`
+
+	Socket ProxySocket `json:"socket" note:"Unix套接字文件设置，监听地址为Unix套接字时有效"`
+
+	Termination ProxyTermination `json:"termination" note:"TLS终止，传入为TLS连接时有效"`
+	Http2       bool             `json:"http2" note:"是否允许客户端使用HTTP/2，仅http服务器及终止TLS的服务器有效，TLS时通过ALPN协商h2，明文时为h2c(仅先验知识，不支持由HTTP/1.1升级，请求升级的客户端继续使用HTTP/1.1)"`
+
+	Maintenance ProxyMaintenance  `json:"maintenance" note:"维护模式"`
+	ErrorPages  []*ProxyErrorPage `json:"errorPages" note:"错误页面，仅http有效"`
+	Headers     ProxyHeaders      `json:"headers" note:"头部规则，仅http有效"`
+	Compress    ProxyCompress     `json:"compress" note:"响应压缩，仅http有效"`
+	RequestId   ProxyRequestId    `json:"requestId" note:"请求ID"`
+	AccessLog   bool              `json:"accessLog" note:"是否记录访问日志"`
+	Timeout     ProxyTimeout      `json:"timeout" note:"超时设置"`
+	Limits      ProxyLimits       `json:"limits" note:"请求限制，仅http有效"`
+
+	Throttle       ProxyThrottle `json:"throttle" note:"带宽限制，服务器全部客户端连接合计，UDP服务器不支持"`
+	ClientThrottle ProxyThrottle `json:"clientThrottle" note:"每个客户端IP的带宽限制，UDP服务器不支持"`
+}
+
+func (s *ProxyServerAdd) UniqueId() string {
+	return proxyServerUniqueId(proxyServerProtocol(s.Protocol), s.IP, s.Port)
+}
+
+func (s *ProxyServerAdd) IsUdp() bool {
+	return proxyServerProtocol(s.Protocol) == ProxyProtocolUdp
+}
+
+func (s *ProxyServerAdd) IsTcpMode() bool {
+	return !s.IsUdp() && s.Mode == ProxyModeTcp
+}
+
+func (s *ProxyServerAdd) IsHttp() bool {
+	return (!s.TLS || s.Termination.Enable) && !s.IsUdp() && !s.IsTcpMode()
 }
 
 type ProxyServerDel struct {
@@ -128,9 +256,26 @@ func (s *ProxyServerEdit) CopyTo(target *ProxyServer) {
 
 	target.Name = s.Name
 	target.Disable = s.Disable
+	target.Schedule.CopyFrom(&s.Schedule)
 	target.TLS = s.TLS
+	target.Protocol = s.Protocol
+	target.Mode = s.Mode
 	target.IP = s.IP
 	target.Port = s.Port
+	target.V6Only = s.V6Only
+	target.Socket = s.Socket
+	target.Termination = s.Termination
+	target.Http2 = s.Http2
+	target.Maintenance.CopyFrom(&s.Maintenance)
+	target.ErrorPages = copyProxyErrorPages(s.ErrorPages)
+	target.Headers.CopyFrom(&s.Headers)
+	target.Compress.CopyFrom(&s.Compress)
+	target.RequestId = s.RequestId
+	target.AccessLog = s.AccessLog
+	target.Timeout = s.Timeout
+	target.Limits = s.Limits
+	target.Throttle = s.Throttle
+	target.ClientThrottle = s.ClientThrottle
 }
 
 func (s *ProxyServerEdit) CopyFrom(source *ProxyServer) {
@@ -141,7 +286,41 @@ func (s *ProxyServerEdit) CopyFrom(source *ProxyServer) {
 	s.Id = source.Id
 	s.Name = source.Name
 	s.Disable = source.Disable
+	s.Schedule.CopyFrom(&source.Schedule)
 	s.TLS = source.TLS
+	s.Protocol = source.Protocol
+	s.Mode = source.Mode
 	s.IP = source.IP
 	s.Port = source.Port
+	s.V6Only = source.V6Only
+	s.Socket = source.Socket
+	s.Termination = source.Termination
+	s.Http2 = source.Http2
+	s.Maintenance.CopyFrom(&source.Maintenance)
+	s.ErrorPages = copyProxyErrorPages(source.ErrorPages)
+	s.Headers.CopyFrom(&source.Headers)
+	s.Compress.CopyFrom(&source.Compress)
+	s.RequestId = source.RequestId
+	s.AccessLog = source.AccessLog
+	s.Timeout = source.Timeout
+	s.Limits = source.Limits
+	s.Throttle = source.Throttle
+	s.ClientThrottle = source.ClientThrottle
+}
+
+func proxyServerProtocol(protocol string) string {
+	if len(protocol) < 1 {
+		return ProxyProtocolTcp
+	}
+
+	return protocol
+}
+
+// proxyServerUniqueId returns the listen address, tcp and udp servers may listen on the same one.
+func proxyServerUniqueId(protocol, ip, port string) string {
+	if protocol == ProxyProtocolUdp {
+		return fmt.Sprintf("%s/%s", proxyAddress(ip, port), protocol)
+	}
+
+	return proxyAddress(ip, port)
 }
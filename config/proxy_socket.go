@@ -0,0 +1,35 @@
+package config
+
+import (
+	"net"
+	"strings"
+)
+
+const ProxyUnixPrefix = "unix:"
+
+type ProxySocket struct {
+	Mode  string `json:"mode" note:"文件权限(八进制)，如: 0660，空表示默认"`
+	User  string `json:"user" note:"文件所有者，用户名或UID，空表示不修改"`
+	Group string `json:"group" note:"文件所属组，组名或GID，空表示不修改"`
+}
+
+func (s *ProxySocket) IsEmpty() bool {
+	return len(s.Mode) < 1 && len(s.User) < 1 && len(s.Group) < 1
+}
+
+// IsUnixAddress reports whether the address is the path of a unix domain socket, e.g. unix:/run/grps/http.sock.
+func IsUnixAddress(ip string) bool {
+	return strings.HasPrefix(ip, ProxyUnixPrefix)
+}
+
+func proxyAddress(ip, port string) string {
+	if IsUnixAddress(ip) {
+		return ip
+	}
+	if len(port) < 1 {
+		// the name of a SRV record
+		return ip
+	}
+
+	return net.JoinHostPort(ip, port)
+}
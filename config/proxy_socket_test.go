@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestProxyAddress(t *testing.T) {
+	items := []struct {
+		ip      string
+		port    string
+		address string
+	}{
+		{"", "80", ":80"},
+		{"127.0.0.1", "80", "127.0.0.1:80"},
+		{"::", "80", "[::]:80"},
+		{"::1", "443", "[::1]:443"},
+		{"fe80::1%eth0", "8080", "[fe80::1%eth0]:8080"},
+		{"example.com", "80", "example.com:80"},
+		{"_http._tcp.example.com", "", "_http._tcp.example.com"},
+		{"unix:/run/grps/http.sock", "80", "unix:/run/grps/http.sock"},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		address := proxyAddress(item.ip, item.port)
+		if address != item.address {
+			t.Errorf("address of %q and %q is %q, want %q", item.ip, item.port, address, item.address)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+type ProxyHeader struct {
+	Name  string `json:"name" note:"名称"`
+	Value string `json:"value" note:"值"`
+}
+
+type ProxyStatic struct {
+	Code    int            `json:"code" note:"状态码，0表示默认值(200)"`
+	Headers []*ProxyHeader `json:"headers" note:"响应头"`
+	Body    string         `json:"body" note:"响应内容，与文件二选一"`
+	File    string         `json:"file" note:"响应内容文件路径，与响应内容二选一"`
+}
+
+func (s *ProxyStatic) CopyFrom(source *ProxyStatic) {
+	if source == nil {
+		return
+	}
+
+	s.Code = source.Code
+	s.Body = source.Body
+	s.File = source.File
+	s.Headers = make([]*ProxyHeader, 0)
+	for i := 0; i < len(source.Headers); i++ {
+		item := source.Headers[i]
+		if item != nil {
+			s.Headers = append(s.Headers, &ProxyHeader{
+				Name:  item.Name,
+				Value: item.Value,
+			})
+		}
+	}
+}
@@ -1,22 +1,58 @@
 package config
 
-import "fmt"
+import "time"
+
+const (
+	ProxyTargetTypeProxy    = "proxy"
+	ProxyTargetTypeRedirect = "redirect"
+	ProxyTargetTypeStatic   = "static"
+
+	ProxyBalanceFailover   = "failover"
+	ProxyBalanceRoundRobin = "roundRobin"
+)
 
 type ProxySpare struct {
-	IP   string `json:"ip" note:"目标地址"`
-	Port string `json:"port" note:"目标端口"`
+	IP   string `json:"ip" note:"目标地址，IP地址或域名，unix:路径表示Unix套接字(如: unix:/run/app.sock)"`
+	Port string `json:"port" note:"目标端口，Unix套接字时为空"`
 }
 
 type ProxyTarget struct {
 	Id     string `json:"id" note:"标识ID"`
 	Domain string `json:"domain" note:"域名"`
 	Path   string `json:"path" note:"路径，仅http有效"`
+	Type   string `json:"type" note:"类型: proxy或空-转发到目标地址; redirect-重定向，仅http有效; static-固定响应，仅http有效"`
 
-	IP      string        `json:"ip" note:"目标地址"`
-	Port    string        `json:"port" note:"目标端口"`
-	Version int           `json:"version" note:"版本号，0或1，0-不添加头部；1-添加代理头部（PROXY family srcIP srcPort targetIP targetPort）"`
+	IP      string        `json:"ip" note:"目标地址，IP地址或域名，unix:路径表示Unix套接字(如: unix:/run/app.sock)"`
+	Port    string        `json:"port" note:"目标端口，Unix套接字时为空"`
+	Version int           `json:"version" note:"版本号，0、1或2，0-不添加头部；1-添加代理头部（PROXY family srcIP srcPort targetIP targetPort）；2-添加二进制代理头部（PROXY协议版本2，含请求ID或连接ID）"`
 	Disable bool          `json:"disable" note:"已禁用"`
+	Http2   bool          `json:"http2" note:"是否使用HTTP/2(h2c)连接目标，仅http有效，gRPC目标需要启用"`
 	Spares  []*ProxySpare `json:"spares" note:"备用目标"`
+	Balance string        `json:"balance" note:"负载均衡: failover或空-优先使用目标，失败时依次使用备用目标; roundRobin-新连接在目标及备用目标间轮询。域名解析得到的多个地址总是轮询"`
+	Resolve ProxyResolve  `json:"resolve" note:"域名解析，对目标及备用目标有效"`
+	Health  ProxyHealth   `json:"health" note:"被动健康检测"`
+	Retry   ProxyRetry    `json:"retry" note:"重试策略"`
+	Mirror  ProxyMirror   `json:"mirror" note:"流量镜像，UDP服务器不支持"`
+
+	Schedule ProxySchedule `json:"schedule" note:"启用计划，不在计划时间内时视为已禁用"`
+
+	WebSocket  ProxyWebSocket  `json:"webSocket" note:"WebSocket设置，仅http有效"`
+	Cache      ProxyCache      `json:"cache" note:"响应缓存，仅http有效"`
+	Auth       ProxyAuth       `json:"auth" note:"认证，仅http有效，在维护模式之后执行"`
+	Jwt        ProxyJwt        `json:"jwt" note:"JWT令牌验证，仅http有效，在认证之后执行"`
+	ClientCert ProxyClientCert `json:"clientCert" note:"客户端证书(mTLS)，仅终止TLS的服务器有效，在认证之前执行"`
+	Cors       Cors            `json:"cors" note:"跨域资源共享，仅http有效，跨域请求时目标的CORS响应头总是被替换"`
+
+	Redirect ProxyRedirect `json:"redirect" note:"重定向，类型为redirect时有效"`
+	Static   ProxyStatic   `json:"static" note:"固定响应，类型为static时有效"`
+
+	Maintenance ProxyMaintenance  `json:"maintenance" note:"维护模式，优先于服务器的维护模式"`
+	ErrorPages  []*ProxyErrorPage `json:"errorPages" note:"错误页面，仅http有效，优先于服务器的错误页面"`
+	Headers     ProxyHeaders      `json:"headers" note:"头部规则，仅http有效，在服务器的头部规则之后执行"`
+	Compress    ProxyCompress     `json:"compress" note:"响应压缩，仅http有效，启用时优先于服务器的响应压缩"`
+	Timeout     ProxyTimeout      `json:"timeout" note:"超时设置，非0项覆盖服务器的超时设置"`
+	Limits      ProxyLimits       `json:"limits" note:"请求限制，仅http有效，非0项覆盖服务器的请求限制"`
+	Throttle    ProxyThrottle     `json:"throttle" note:"带宽限制，目标(含备用目标)全部连接合计，在服务器的带宽限制之外执行，UDP服务器不支持"`
 }
 
 func (s *ProxyTarget) CopyFrom(source *ProxyTarget) {
@@ -26,10 +62,33 @@ func (s *ProxyTarget) CopyFrom(source *ProxyTarget) {
 
 	s.Domain = source.Domain
 	s.Path = source.Path
+	s.Type = source.Type
 	s.IP = source.IP
 	s.Port = source.Port
 	s.Version = source.Version
 	s.Disable = source.Disable
+	s.Schedule.CopyFrom(&source.Schedule)
+	s.Http2 = source.Http2
+	s.Balance = source.Balance
+	s.Resolve = source.Resolve
+	s.Health = source.Health
+	s.Retry.CopyFrom(&source.Retry)
+	s.Mirror = source.Mirror
+	s.WebSocket = source.WebSocket
+	s.Cache.CopyFrom(&source.Cache)
+	s.Auth.CopyFrom(&source.Auth)
+	s.Jwt.CopyFrom(&source.Jwt)
+	s.ClientCert.CopyFrom(&source.ClientCert)
+	s.Cors.CopyFrom(&source.Cors)
+	s.Redirect = source.Redirect
+	s.Static.CopyFrom(&source.Static)
+	s.Maintenance.CopyFrom(&source.Maintenance)
+	s.ErrorPages = copyProxyErrorPages(source.ErrorPages)
+	s.Headers.CopyFrom(&source.Headers)
+	s.Compress.CopyFrom(&source.Compress)
+	s.Timeout = source.Timeout
+	s.Limits = source.Limits
+	s.Throttle = source.Throttle
 	s.Spares = make([]*ProxySpare, 0)
 	for i := 0; i < len(source.Spares); i++ {
 		item := source.Spares[i]
@@ -42,6 +101,29 @@ func (s *ProxyTarget) CopyFrom(source *ProxyTarget) {
 	}
 }
 
+// IsEnabled reports whether the target is not disabled and the time is within its schedule.
+func (s *ProxyTarget) IsEnabled(t time.Time) bool {
+	return !s.Disable && s.Schedule.Active(t)
+}
+
+func (s *ProxyTarget) TargetType() string {
+	if len(s.Type) < 1 {
+		return ProxyTargetTypeProxy
+	}
+
+	return s.Type
+}
+
+// IsHttpOnly reports whether the target is answered by the proxy itself,
+// which is available for http servers only.
+func (s *ProxyTarget) IsHttpOnly() bool {
+	return s.TargetType() != ProxyTargetTypeProxy
+}
+
+func (s *ProxyTarget) Address() string {
+	return proxyAddress(s.IP, s.Port)
+}
+
 func (s *ProxyTarget) SpareTargets() []string {
 	targets := make([]string, 0)
 
@@ -52,7 +134,7 @@ func (s *ProxyTarget) SpareTargets() []string {
 			continue
 		}
 
-		targets = append(targets, fmt.Sprintf("%s:%s", spare.IP, spare.Port))
+		targets = append(targets, proxyAddress(spare.IP, spare.Port))
 	}
 
 	return targets
@@ -0,0 +1,12 @@
+package config
+
+// ProxyTermination terminates the incoming tls connections of the server, which
+// http/2 over tls needs to negotiate h2 by ALPN.
+type ProxyTermination struct {
+	Enable            bool   `json:"enable" note:"是否终止TLS连接，启用后按域名及路径转发http请求，否则按SNI透传TLS连接"`
+	CertFile          string `json:"certFile" note:"证书文件路径(PEM)"`
+	KeyFile           string `json:"keyFile" note:"私钥文件路径(PEM)"`
+	ClientCaFile      string `json:"clientCaFile" note:"客户端证书的受信任CA证书文件路径(PEM，可含多个证书)，空表示不请求客户端证书"`
+	CrlFile           string `json:"crlFile" note:"证书吊销列表文件路径(PEM或DER)，须由客户端CA签发，文件修改后自动重新加载"`
+	RequireClientCert bool   `json:"requireClientCert" note:"是否所有连接都要求客户端证书，否则仅验证客户端提供的证书，由目标决定是否要求"`
+}
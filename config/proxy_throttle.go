@@ -0,0 +1,18 @@
+package config
+
+type ProxyThrottle struct {
+	Upload   int64 `json:"upload" note:"上传速率上限(字节/秒)，0表示不限制"`
+	Download int64 `json:"download" note:"下载速率上限(字节/秒)，0表示不限制"`
+	Burst    int64 `json:"burst" note:"突发流量(字节)，空闲后允许超出速率传输的字节数，0表示1秒的速率"`
+}
+
+func (s *ProxyThrottle) IsLimited() bool {
+	return s.Upload > 0 || s.Download > 0
+}
+
+type ProxyThrottleEdit struct {
+	ServerId       string        `json:"serverId" required:"true" note:"服务器标识ID"`
+	TargetId       string        `json:"targetId" note:"目标地址标识ID，空表示修改服务器的带宽限制"`
+	Throttle       ProxyThrottle `json:"throttle" note:"带宽限制"`
+	ClientThrottle ProxyThrottle `json:"clientThrottle" note:"每个客户端IP的带宽限制，目标地址标识ID为空时有效"`
+}
@@ -0,0 +1,9 @@
+package config
+
+type ProxyTimeout struct {
+	Connect        int `json:"connect" note:"连接目标超时(秒)，超时后尝试备用目标，0表示默认值(10)"`
+	Idle           int `json:"idle" note:"空闲超时(秒)，在该时间内无数据传输时关闭连接，http为连接保持的空闲时间且仅服务器设置有效，0表示不限制"`
+	Lifetime       int `json:"lifetime" note:"最大会话时长(秒)，tcp为连接时长，http为请求时长，0表示不限制"`
+	ReadHeader     int `json:"readHeader" note:"读取请求头超时(秒)，仅http有效且仅服务器设置有效，0表示不限制"`
+	ResponseHeader int `json:"responseHeader" note:"等待目标响应头超时(秒)，仅http有效，0表示不限制"`
+}
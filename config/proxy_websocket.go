@@ -0,0 +1,6 @@
+package config
+
+type ProxyWebSocket struct {
+	Deny bool `json:"deny" note:"是否拒绝升级为WebSocket，拒绝时响应403"`
+	Idle int  `json:"idle" note:"WebSocket连接空闲超时(秒)，在该时间内双向均无数据传输时关闭连接，0表示不限制"`
+}
@@ -3,18 +3,23 @@ package controller
 import (
 	"fmt"
 	"github.com/csby/grps/config"
-	"github.com/csby/gwsf/gproxy"
+	"github.com/csby/grps/proxy"
 	"github.com/csby/gwsf/gtype"
-	"net"
-	"strconv"
+	"sync"
 	"time"
 )
 
 type Proxy struct {
 	controller
 
-	proxyServer *gproxy.Server
-	proxyLinks  gproxy.LinkCollection
+	proxyServer *proxy.Server
+	proxyLinks  proxy.LinkCollection
+
+	// the handlers and the schedules change the config and the routes under it
+	mutex        sync.Mutex
+	scheduled    map[string]bool // the schedule states of the servers and targets
+	scheduleIdle bool            // no route to start the service with
+	scheduleStop chan struct{}
 }
 
 func NewProxy(log gtype.Log, cfg *config.Config, chs gtype.SocketChannelCollection) *Proxy {
@@ -23,23 +28,46 @@ func NewProxy(log gtype.Log, cfg *config.Config, chs gtype.SocketChannelCollecti
 	instance.cfg = cfg
 	instance.wsChannels = chs
 
-	instance.proxyLinks = gproxy.NewLinkCollection()
-	instance.proxyServer = &gproxy.Server{
-		StatusChanged:  instance.onProxyServerStatusChanged,
-		OnConnected:    instance.onProxyConnected,
-		OnDisconnected: instance.onProxyDisconnected,
+	instance.proxyLinks = proxy.NewLinkCollection()
+	instance.proxyServer = &proxy.Server{
+		StatusChanged:    instance.onProxyServerStatusChanged,
+		OnConnected:      instance.onProxyConnected,
+		OnDisconnected:   instance.onProxyDisconnected,
+		OnBackendChanged: instance.onProxyBackendChanged,
 	}
 	instance.proxyServer.SetLog(log)
 
+	err := instance.hashProxyPasswords()
+	if err != nil {
+		instance.LogError("proxy auth passwords hash fail: ", err)
+	}
 	instance.initRoutes()
 	if len(instance.proxyServer.Routes) > 0 && cfg.ReverseProxy.Disable == false {
 		instance.proxyServer.Start()
 	}
+	instance.scheduleIdle = len(instance.proxyServer.Routes) < 1
+	instance.scheduleStop = make(chan struct{})
+	go instance.runSchedules(instance.scheduleStop)
 
 	return instance
 }
 
+// Close stops the schedules and the proxy service.
+func (s *Proxy) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.scheduleStop != nil {
+		close(s.scheduleStop)
+		s.scheduleStop = nil
+	}
+	s.proxyServer.Stop()
+}
+
 func (s *Proxy) GetProxyServers(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	data := make([]*config.ProxyServerEdit, 0)
 	count := len(s.cfg.ReverseProxy.Servers)
 	for index := 0; index < count; index++ {
@@ -84,30 +112,18 @@ func (s *Proxy) GetProxyServersDoc(doc gtype.Doc, method string, uri gtype.Uri)
 }
 
 func (s *Proxy) AddProxyServer(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyServerEdit{}
 	err := ctx.GetJson(argument)
 	if err != nil {
 		ctx.Error(gtype.ErrInput, err)
 		return
 	}
-	if len(argument.Name) < 1 {
-		ctx.Error(gtype.ErrInput, "名称为空")
-		return
-	}
-	if len(argument.IP) > 0 {
-		addr := net.ParseIP(argument.IP)
-		if addr == nil {
-			ctx.Error(gtype.ErrInput, fmt.Sprintf("IP地址(%s)无效", argument.IP))
-			return
-		}
-	}
-	if len(argument.Port) < 1 {
-		ctx.Error(gtype.ErrInput, "监听端口为空")
-		return
-	}
-	port, err := strconv.ParseUint(argument.Port, 10, 16)
-	if err != nil || port < 1 {
-		ctx.Error(gtype.ErrInput, fmt.Sprintf("监听端口(%s)无效", argument.Port))
+	err = s.checkProxyServer(&argument.ProxyServerAdd)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
 		return
 	}
 
@@ -148,6 +164,9 @@ func (s *Proxy) AddProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 }
 
 func (s *Proxy) DelProxyServer(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyServer{}
 	err := ctx.GetJson(argument)
 	if err != nil {
@@ -170,7 +189,11 @@ func (s *Proxy) DelProxyServer(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	s.initRoutes()
+	err = s.reloadRoutes()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
 	ctx.Success(nil)
 
 	go s.writeWebSocketMessage(WSReviseProxyServerDel, &config.ProxyServerDel{Id: argument.Id})
@@ -179,7 +202,7 @@ func (s *Proxy) DelProxyServer(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) DelProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "删除服务器")
-	function.SetNote("删除反向代理服务器")
+	function.SetNote("删除反向代理服务器，立即对运行中的服务生效")
 	function.SetInputJsonExample(&config.ProxyServerDel{
 		Id: gtype.NewGuid(),
 	})
@@ -190,6 +213,9 @@ func (s *Proxy) DelProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 }
 
 func (s *Proxy) ModifyProxyServer(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyServerEdit{}
 	err := ctx.GetJson(argument)
 	if err != nil {
@@ -200,25 +226,29 @@ func (s *Proxy) ModifyProxyServer(ctx gtype.Context, ps gtype.Params) {
 		ctx.Error(gtype.ErrInput, "ID为空")
 		return
 	}
-	if len(argument.Name) < 1 {
-		ctx.Error(gtype.ErrInput, "名称为空")
+	err = s.checkProxyServer(&argument.ProxyServerAdd)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
 		return
 	}
-	if len(argument.IP) > 0 {
-		addr := net.ParseIP(argument.IP)
-		if addr == nil {
-			ctx.Error(gtype.ErrInput, fmt.Sprintf("IP地址(%s)无效", argument.IP))
+	if !argument.IsHttp() {
+		server := s.cfg.ReverseProxy.GetServer(argument.Id)
+		if server != nil && server.HasHttpOnlyTarget() {
+			ctx.Error(gtype.ErrInput, "服务器存在仅支持http的目标地址，不能设置为未终止的TLS连接、UDP或TCP模式")
 			return
 		}
 	}
-	if len(argument.Port) < 1 {
-		ctx.Error(gtype.ErrInput, "监听端口为空")
-		return
-	}
-	port, err := strconv.ParseUint(argument.Port, 10, 16)
-	if err != nil || port < 1 {
-		ctx.Error(gtype.ErrInput, fmt.Sprintf("监听端口(%s)无效", argument.Port))
-		return
+	if argument.IsUdp() {
+		server := s.cfg.ReverseProxy.GetServer(argument.Id)
+		if server != nil {
+			for i := 0; i < len(server.Targets); i++ {
+				err = s.checkProxyUdpTarget(server.Targets[i])
+				if err != nil {
+					ctx.Error(gtype.ErrInput, err)
+					return
+				}
+			}
+		}
 	}
 
 	err = s.cfg.ReverseProxy.ModifyServer(argument)
@@ -233,7 +263,11 @@ func (s *Proxy) ModifyProxyServer(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	s.initRoutes()
+	err = s.reloadRoutes()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
 	ctx.Success(nil)
 
 	go s.writeWebSocketMessage(WSReviseProxyServerMod, argument)
@@ -242,7 +276,7 @@ func (s *Proxy) ModifyProxyServer(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) ModifyProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "修改服务器")
-	function.SetNote("修改反向代理服务器")
+	function.SetNote("修改反向代理服务器，立即对运行中的服务生效")
 	function.SetInputJsonExample(&config.ProxyServerEdit{
 		ProxyServerDel: config.ProxyServerDel{
 			Id: gtype.NewGuid(),
@@ -252,6 +286,11 @@ func (s *Proxy) ModifyProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri
 			Disable: false,
 			IP:      "",
 			Port:    "80",
+			Maintenance: config.ProxyMaintenance{
+				Enable:     false,
+				RetryAfter: 3600,
+				Whitelist:  []string{"192.168.1.0/24"},
+			},
 		},
 	})
 	function.SetOutputDataExample(nil)
@@ -261,6 +300,9 @@ func (s *Proxy) ModifyProxyServerDoc(doc gtype.Doc, method string, uri gtype.Uri
 }
 
 func (s *Proxy) GetProxyTargets(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyServerDel{}
 	err := ctx.GetJson(argument)
 	if err != nil {
@@ -278,44 +320,72 @@ func (s *Proxy) GetProxyTargets(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	ctx.Success(server.Targets)
+	now := time.Now()
+	data := make([]*config.ProxyTargetInfo, 0)
+	for i := 0; i < len(server.Targets); i++ {
+		target := server.Targets[i]
+		if target != nil {
+			data = append(data, server.TargetInfo(target, now))
+		}
+	}
+
+	ctx.Success(data)
 }
 
 func (s *Proxy) GetProxyTargetsDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	now := gtype.DateTime(time.Now().Truncate(time.Hour).Add(time.Hour))
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "获取目标地址列表")
-	function.SetNote("获取指定反向代理所有服务器的目标地址信息")
+	function.SetNote("获取指定反向代理所有服务器的目标地址信息，包括按启用计划当前是否生效及下次切换的时间")
 	function.SetInputJsonExample(&config.ProxyServerDel{
 		Id: gtype.NewGuid(),
 	})
-	function.SetOutputDataExample([]*config.ProxyTarget{
+	function.SetOutputDataExample([]*config.ProxyTargetInfo{
 		{
-			Id:      gtype.NewGuid(),
-			Domain:  "test.com",
-			IP:      "192.168.210.8",
-			Port:    "8080",
-			Version: 0,
-			Disable: false,
-			Spares: []*config.ProxySpare{
-				{
-					IP:   "192.168.210.18",
-					Port: "8080",
+			ProxyTarget: &config.ProxyTarget{
+				Id:      gtype.NewGuid(),
+				Domain:  "test.com",
+				IP:      "192.168.210.8",
+				Port:    "8080",
+				Version: 0,
+				Disable: false,
+				Spares: []*config.ProxySpare{
+					{
+						IP:   "192.168.210.18",
+						Port: "8080",
+					},
+				},
+				Schedule: config.ProxySchedule{
+					Enable:   true,
+					TimeZone: "Asia/Shanghai",
+					Windows: []*config.ProxyScheduleWindow{
+						{
+							Weekdays: []int{1, 2, 3, 4, 5},
+							Start:    "09:00",
+							End:      "18:00",
+						},
+					},
 				},
 			},
+			Effective:  true,
+			NextChange: &now,
 		},
 		{
-			Id:      gtype.NewGuid(),
-			Domain:  "test.com",
-			IP:      "192.168.210.17",
-			Port:    "8443",
-			Version: 1,
-			Disable: true,
-			Spares: []*config.ProxySpare{
-				{
-					IP:   "192.168.210.27",
-					Port: "8443",
+			ProxyTarget: &config.ProxyTarget{
+				Id:      gtype.NewGuid(),
+				Domain:  "test.com",
+				IP:      "192.168.210.17",
+				Port:    "8443",
+				Version: 1,
+				Disable: true,
+				Spares: []*config.ProxySpare{
+					{
+						IP:   "192.168.210.27",
+						Port: "8443",
+					},
 				},
 			},
+			Effective: false,
 		},
 	})
 	function.AddOutputError(gtype.ErrInput)
@@ -324,43 +394,25 @@ func (s *Proxy) GetProxyTargetsDoc(doc gtype.Doc, method string, uri gtype.Uri)
 }
 
 func (s *Proxy) AddProxyTarget(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyTargetEdit{}
 	err := ctx.GetJson(argument)
 	if err != nil {
 		ctx.Error(gtype.ErrInput, err)
 		return
 	}
-	if len(argument.Target.IP) < 1 {
-		ctx.Error(gtype.ErrInput, "目标地址为空")
-		return
-	}
-	if len(argument.Target.Port) < 1 {
-		ctx.Error(gtype.ErrInput, "目标端口为空")
+	err = s.checkProxyTarget(&argument.Target)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
 		return
 	}
-	port, err := strconv.ParseUint(argument.Target.Port, 10, 16)
-	if err != nil || port < 1 {
-		ctx.Error(gtype.ErrInput, fmt.Sprintf("目标端口(%s)无效", argument.Target.Port))
+	err = s.hashProxyAuthPasswords(&argument.Target.Auth)
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
 		return
 	}
-	c := len(argument.Target.Spares)
-	if c > 0 {
-		for i := 0; i < c; i++ {
-			spare := argument.Target.Spares[i]
-			if spare == nil {
-				ctx.Error(gtype.ErrInput, "备用目标项目为空")
-				return
-			}
-			if len(spare.IP) < 1 {
-				ctx.Error(gtype.ErrInput, "备用目标地址为空")
-				return
-			}
-			if len(spare.Port) < 1 {
-				ctx.Error(gtype.ErrInput, "备用目标端口为空")
-				return
-			}
-		}
-	}
 
 	if len(argument.ServerId) < 1 {
 		ctx.Error(gtype.ErrInput, "服务器标识ID为空")
@@ -371,6 +423,21 @@ func (s *Proxy) AddProxyTarget(ctx gtype.Context, ps gtype.Params) {
 		ctx.Error(gtype.ErrInput, fmt.Sprintf("server id '%s' not exist", argument.ServerId))
 		return
 	}
+	if !server.IsHttp() && argument.Target.IsHttpOnly() {
+		ctx.Error(gtype.ErrInput, fmt.Sprintf("未终止TLS、UDP或TCP模式的服务器不支持目标类型(%s)", argument.Target.Type))
+		return
+	}
+	if argument.Target.ClientCert.Require && (!server.TLS || !server.Termination.Enable || len(server.Termination.ClientCaFile) < 1) {
+		ctx.Error(gtype.ErrInput, "要求客户端证书的目标须属于终止TLS且指定客户端CA的服务器")
+		return
+	}
+	if server.IsUdp() {
+		err = s.checkProxyUdpTarget(&argument.Target)
+		if err != nil {
+			ctx.Error(gtype.ErrInput, err)
+			return
+		}
+	}
 
 	argument.Target.Id = gtype.NewGuid()
 	err = server.AddTarget(&argument.Target)
@@ -385,7 +452,11 @@ func (s *Proxy) AddProxyTarget(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	s.initRoutes()
+	err = s.reloadRoutes()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
 	ctx.Success(nil)
 
 	go s.writeWebSocketMessage(WSReviseProxyTargetAdd, argument)
@@ -394,8 +465,8 @@ func (s *Proxy) AddProxyTarget(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) AddProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "添加目标地址")
-	function.SetNote("添加反向代理服务器的目标地址")
-	function.SetRemark("标识ID(target.id)不需要指定")
+	function.SetNote("添加反向代理服务器的目标地址，立即对运行中的服务生效")
+	function.SetRemark("标识ID(target.id)不需要指定；类型(target.type)为redirect或static时不需要指定目标地址及端口")
 	function.SetInputJsonExample(&config.ProxyTargetEdit{
 		ServerId: gtype.NewGuid(),
 		Target: config.ProxyTarget{
@@ -419,6 +490,9 @@ func (s *Proxy) AddProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 }
 
 func (s *Proxy) DelProxyTarget(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyTargetDel{}
 	err := ctx.GetJson(argument)
 	if err != nil {
@@ -451,7 +525,11 @@ func (s *Proxy) DelProxyTarget(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	s.initRoutes()
+	err = s.reloadRoutes()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
 	ctx.Success(nil)
 
 	go s.writeWebSocketMessage(WSReviseProxyTargetDel, argument)
@@ -460,7 +538,7 @@ func (s *Proxy) DelProxyTarget(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) DelProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "删除目标地址")
-	function.SetNote("删除反向代理服务器的目标地址")
+	function.SetNote("删除反向代理服务器的目标地址，立即对运行中的服务生效")
 	function.SetInputJsonExample(&config.ProxyTargetDel{
 		ServerId: gtype.NewGuid(),
 		TargetId: gtype.NewGuid(),
@@ -472,6 +550,9 @@ func (s *Proxy) DelProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 }
 
 func (s *Proxy) ModifyProxyTarget(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &config.ProxyTargetEdit{}
 	err := ctx.GetJson(argument)
 	if err != nil {
@@ -486,43 +567,37 @@ func (s *Proxy) ModifyProxyTarget(ctx gtype.Context, ps gtype.Params) {
 		ctx.Error(gtype.ErrInput, "目标地址标识ID为空")
 		return
 	}
-	if len(argument.Target.IP) < 1 {
-		ctx.Error(gtype.ErrInput, "目标地址为空")
+	err = s.checkProxyTarget(&argument.Target)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
 		return
 	}
-	if len(argument.Target.Port) < 1 {
-		ctx.Error(gtype.ErrInput, "目标端口为空")
+	err = s.hashProxyAuthPasswords(&argument.Target.Auth)
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
 		return
 	}
-	c := len(argument.Target.Spares)
-	if c > 0 {
-		for i := 0; i < c; i++ {
-			spare := argument.Target.Spares[i]
-			if spare == nil {
-				ctx.Error(gtype.ErrInput, "备用目标项目为空")
-				return
-			}
-			if len(spare.IP) < 1 {
-				ctx.Error(gtype.ErrInput, "备用目标地址为空")
-				return
-			}
-			if len(spare.Port) < 1 {
-				ctx.Error(gtype.ErrInput, "备用目标端口为空")
-				return
-			}
-		}
-	}
 
-	port, err := strconv.ParseUint(argument.Target.Port, 10, 16)
-	if err != nil || port < 1 {
-		ctx.Error(gtype.ErrInput, fmt.Sprintf("目标端口(%s)无效", argument.Target.Port))
-		return
-	}
 	server := s.cfg.ReverseProxy.GetServer(argument.ServerId)
 	if server == nil {
 		ctx.Error(gtype.ErrInput, fmt.Sprintf("server id '%s' not exist", argument.ServerId))
 		return
 	}
+	if !server.IsHttp() && argument.Target.IsHttpOnly() {
+		ctx.Error(gtype.ErrInput, fmt.Sprintf("未终止TLS、UDP或TCP模式的服务器不支持目标类型(%s)", argument.Target.Type))
+		return
+	}
+	if argument.Target.ClientCert.Require && (!server.TLS || !server.Termination.Enable || len(server.Termination.ClientCaFile) < 1) {
+		ctx.Error(gtype.ErrInput, "要求客户端证书的目标须属于终止TLS且指定客户端CA的服务器")
+		return
+	}
+	if server.IsUdp() {
+		err = s.checkProxyUdpTarget(&argument.Target)
+		if err != nil {
+			ctx.Error(gtype.ErrInput, err)
+			return
+		}
+	}
 
 	err = server.ModifyTarget(&argument.Target)
 	if err != nil {
@@ -536,7 +611,11 @@ func (s *Proxy) ModifyProxyTarget(ctx gtype.Context, ps gtype.Params) {
 		return
 	}
 
-	s.initRoutes()
+	err = s.reloadRoutes()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
 	ctx.Success(nil)
 
 	go s.writeWebSocketMessage(WSReviseProxyTargetMod, argument)
@@ -545,7 +624,7 @@ func (s *Proxy) ModifyProxyTarget(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) ModifyProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "修改目标地址")
-	function.SetNote("修改反向代理服务器的目标地址")
+	function.SetNote("修改反向代理服务器的目标地址，立即对运行中的服务生效")
 	function.SetInputJsonExample(&config.ProxyTargetEdit{
 		ServerId: gtype.NewGuid(),
 		Target: config.ProxyTarget{
@@ -570,6 +649,9 @@ func (s *Proxy) ModifyProxyTargetDoc(doc gtype.Doc, method string, uri gtype.Uri
 }
 
 func (s *Proxy) GetProxyServiceSetting(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	data := &ProxyServiceSetting{
 		Disable: s.cfg.ReverseProxy.Disable,
 	}
@@ -589,6 +671,9 @@ func (s *Proxy) GetProxyServiceSettingDoc(doc gtype.Doc, method string, uri gtyp
 }
 
 func (s *Proxy) SetProxyServiceSetting(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	argument := &ProxyServiceSetting{
 		Disable: s.cfg.ReverseProxy.Disable,
 	}
@@ -632,6 +717,9 @@ func (s *Proxy) SetProxyServiceSettingDoc(doc gtype.Doc, method string, uri gtyp
 }
 
 func (s *Proxy) StartProxyService(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.cfg.ReverseProxy.Disable {
 		ctx.Error(gtype.ErrInternal, "服务已禁用")
 		return
@@ -656,6 +744,9 @@ func (s *Proxy) StartProxyServiceDoc(doc gtype.Doc, method string, uri gtype.Uri
 }
 
 func (s *Proxy) StopProxyService(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.cfg.ReverseProxy.Disable {
 		ctx.Error(gtype.ErrInternal, "服务已禁用")
 		return
@@ -680,6 +771,9 @@ func (s *Proxy) StopProxyServiceDoc(doc gtype.Doc, method string, uri gtype.Uri)
 }
 
 func (s *Proxy) RestartProxyService(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.cfg.ReverseProxy.Disable {
 		ctx.Error(gtype.ErrInternal, "服务已禁用")
 		return
@@ -712,16 +806,48 @@ func (s *Proxy) GetProxyServiceStatusDoc(doc gtype.Doc, method string, uri gtype
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "获取服务状态")
 	function.SetNote("获取反向代理服务状态")
-	function.SetOutputDataExample(&gproxy.Result{
-		Status:    gproxy.StatusRunning,
+	function.SetOutputDataExample(&proxy.Result{
+		Status:    proxy.StatusRunning,
 		StartTime: &now,
+		Backends: []*proxy.Backend{
+			{
+				Address:     "192.168.210.8:8080",
+				Ejected:     true,
+				Reason:      "fail",
+				Ejections:   2,
+				EjectTime:   &now,
+				RestoreTime: &now,
+			},
+		},
+		Resolutions: []*proxy.Resolution{
+			{
+				Target:      "app.example.com:8080",
+				Addresses:   []string{"192.168.210.8:8080", "192.168.210.9:8080"},
+				ResolveTime: &now,
+				ExpireTime:  &now,
+			},
+		},
+		WebSocket: &proxy.WebSocketStat{
+			Upgrades:     12,
+			Active:       3,
+			ClientFrames: 860,
+			ClientBytes:  52480,
+			TargetFrames: 1204,
+			TargetBytes:  389120,
+		},
+		Limits: &proxy.LimitStat{
+			HeaderTooLarge: 2,
+			BodyTooLarge:   5,
+			SlowUpload:     1,
+			Incomplete:     37,
+		},
 	})
 	function.AddOutputError(gtype.ErrInternal)
 	function.AddOutputError(gtype.ErrTokenInvalid)
 }
 
 func (s *Proxy) GetProxyLinks(ctx gtype.Context, ps gtype.Params) {
-	argument := &gproxy.LinkFilter{}
+	argument := &proxy.LinkFilter{}
 	ctx.GetJson(argument)
 	data := s.proxyLinks.Lst(argument)
 
@@ -731,16 +857,36 @@ func (s *Proxy) GetProxyLinks(ctx gtype.Context, ps gtype.Params) {
 func (s *Proxy) GetProxyLinksDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	catalog := s.proxyCatalog(doc)
 	function := catalog.AddFunction(method, uri, "获取连接列表")
-	function.SetNote("获取当前反向代理转发连接信息")
-	function.SetInputJsonExample(&gproxy.LinkFilter{})
-	function.SetOutputDataExample([]*gproxy.Link{
+	function.SetNote("获取当前反向代理转发连接信息，udp服务器按客户端会话列出")
+	function.SetInputJsonExample(&proxy.LinkFilter{})
+	function.SetOutputDataExample([]*proxy.Link{
 		{
 			Id:         gtype.NewGuid(),
 			Time:       gtype.DateTime(time.Now()),
+			Protocol:   proxy.ProtocolTcp,
 			ListenAddr: ":80",
 			Domain:     "test.com",
 			SourceAddr: "10.3.2.18:25312",
 			TargetAddr: "192.168.1.6:8080",
+			RequestId:  gtype.NewGuid(),
+		},
+		{
+			Id:         gtype.NewGuid(),
+			Time:       gtype.DateTime(time.Now()),
+			Protocol:   proxy.ProtocolTcp,
+			ListenAddr: ":80",
+			Domain:     "test.com",
+			SourceAddr: "10.3.2.18:25316",
+			TargetAddr: "192.168.1.6:8080",
+			Upgrade:    proxy.UpgradeWebSocket,
+		},
+		{
+			Id:         gtype.NewGuid(),
+			Time:       gtype.DateTime(time.Now()),
+			Protocol:   proxy.ProtocolUdp,
+			ListenAddr: ":53",
+			SourceAddr: "10.3.2.18:53120",
+			TargetAddr: "192.168.1.6:53",
 		},
 		{
 			Id:         gtype.NewGuid(),
@@ -755,6 +901,265 @@ func (s *Proxy) GetProxyLinksDoc(doc gtype.Doc, method string, uri gtype.Uri) {
 	function.AddOutputError(gtype.ErrTokenInvalid)
 }
 
+func (s *Proxy) GetProxyCacheStats(ctx gtype.Context, ps gtype.Params) {
+	ctx.Success(s.proxyServer.CacheStats())
+}
+
+func (s *Proxy) GetProxyCacheStatsDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	catalog := s.proxyCatalog(doc)
+	function := catalog.AddFunction(method, uri, "获取缓存统计")
+	function.SetNote("获取启用响应缓存的目标地址的缓存统计，服务重启或缓存设置修改后重新统计")
+	function.SetOutputDataExample([]*proxy.CacheStat{
+		{
+			Id:            gtype.NewGuid(),
+			ListenAddr:    ":80",
+			Domain:        "test.com",
+			Path:          "/static/",
+			Entries:       128,
+			MemorySize:    6291456,
+			DiskSize:      20971520,
+			Hits:          5120,
+			Misses:        360,
+			Stales:        12,
+			Revalidations: 85,
+		},
+	})
+	function.AddOutputError(gtype.ErrInternal)
+	function.AddOutputError(gtype.ErrTokenInvalid)
+}
+
+func (s *Proxy) GetProxyMirrorStats(ctx gtype.Context, ps gtype.Params) {
+	ctx.Success(s.proxyServer.MirrorStats())
+}
+
+func (s *Proxy) GetProxyMirrorStatsDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	catalog := s.proxyCatalog(doc)
+	function := catalog.AddFunction(method, uri, "获取镜像统计")
+	function.SetNote("获取启用流量镜像的目标地址的影子目标统计，与目标的响应分开统计，服务重启后重新统计")
+	function.SetOutputDataExample([]*proxy.MirrorStat{
+		{
+			Id:         gtype.NewGuid(),
+			ListenAddr: ":80",
+			Domain:     "test.com",
+			Path:       "/api/",
+			Target:     "192.168.210.20:8080",
+			Requests:   1024,
+			Skipped:    3,
+			Dropped:    12,
+			Failures:   2,
+			Codes: map[string]uint64{
+				"200": 998,
+				"404": 16,
+				"500": 8,
+			},
+			Latency:    23.5,
+			MaxLatency: 812.4,
+		},
+	})
+	function.AddOutputError(gtype.ErrInternal)
+	function.AddOutputError(gtype.ErrTokenInvalid)
+}
+
+func (s *Proxy) GetProxyThrottles(ctx gtype.Context, ps gtype.Params) {
+	ctx.Success(s.proxyServer.Throttles())
+}
+
+func (s *Proxy) GetProxyThrottlesDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	catalog := s.proxyCatalog(doc)
+	function := catalog.AddFunction(method, uri, "获取带宽限制")
+	function.SetNote("获取服务器、客户端IP及目标地址当前的带宽限制及流量统计，服务启动后有效，重启后保留已修改的限制及统计")
+	function.SetOutputDataExample([]*proxy.ThrottleStat{
+		{
+			Scope:         proxy.ThrottleServer,
+			Id:            gtype.NewGuid(),
+			Upload:        10485760,
+			Download:      52428800,
+			Burst:         0,
+			Active:        36,
+			UploadBytes:   73400320,
+			DownloadBytes: 2147483648,
+			UploadDelay:   0,
+			DownloadDelay: 182500,
+		},
+		{
+			Scope:         proxy.ThrottleClient,
+			Id:            gtype.NewGuid(),
+			Upload:        1048576,
+			Download:      5242880,
+			Burst:         10485760,
+			Active:        36,
+			UploadBytes:   73400320,
+			DownloadBytes: 2147483648,
+			UploadDelay:   3200,
+			DownloadDelay: 95400,
+			Clients: []*proxy.ThrottleClientStat{
+				{
+					IP:            "10.7.32.26",
+					Active:        6,
+					UploadBytes:   1048576,
+					DownloadBytes: 268435456,
+				},
+			},
+		},
+		{
+			Scope:         proxy.ThrottleTarget,
+			Id:            gtype.NewGuid(),
+			Upload:        0,
+			Download:      20971520,
+			Burst:         0,
+			Active:        8,
+			UploadBytes:   20971520,
+			DownloadBytes: 1073741824,
+			UploadDelay:   0,
+			DownloadDelay: 41200,
+		},
+	})
+	function.AddOutputError(gtype.ErrInternal)
+	function.AddOutputError(gtype.ErrTokenInvalid)
+}
+
+func (s *Proxy) ModifyProxyThrottle(ctx gtype.Context, ps gtype.Params) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	argument := &config.ProxyThrottleEdit{}
+	err := ctx.GetJson(argument)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
+		return
+	}
+	if len(argument.ServerId) < 1 {
+		ctx.Error(gtype.ErrInput, "服务器标识ID为空")
+		return
+	}
+	err = s.checkProxyThrottle(&argument.Throttle)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
+		return
+	}
+	err = s.checkProxyThrottle(&argument.ClientThrottle)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
+		return
+	}
+
+	server := s.cfg.ReverseProxy.GetServer(argument.ServerId)
+	if server == nil {
+		ctx.Error(gtype.ErrInput, fmt.Sprintf("server id '%s' not exist", argument.ServerId))
+		return
+	}
+	if server.IsUdp() {
+		ctx.Error(gtype.ErrInput, "UDP服务器不支持带宽限制")
+		return
+	}
+	var target *config.ProxyTarget = nil
+	if len(argument.TargetId) > 0 {
+		for i := 0; i < len(server.Targets); i++ {
+			if server.Targets[i] != nil && server.Targets[i].Id == argument.TargetId {
+				target = server.Targets[i]
+				break
+			}
+		}
+		if target == nil {
+			ctx.Error(gtype.ErrInput, fmt.Sprintf("target id '%s' not exist", argument.TargetId))
+			return
+		}
+		target.Throttle = argument.Throttle
+	} else {
+		server.Throttle = argument.Throttle
+		server.ClientThrottle = argument.ClientThrottle
+	}
+
+	err = s.saveConfig()
+	if err != nil {
+		ctx.Error(gtype.ErrInternal, err)
+		return
+	}
+
+	// the running throttles change at once, the routes are reloaded only when
+	// a throttle is added or removed
+	applied := true
+	if target != nil {
+		applied = s.setProxyThrottle(proxy.ThrottleTarget, target.Id, &target.Throttle)
+	} else {
+		serverApplied := s.setProxyThrottle(proxy.ThrottleServer, server.Id, &server.Throttle)
+		clientApplied := s.setProxyThrottle(proxy.ThrottleClient, server.Id, &server.ClientThrottle)
+		applied = serverApplied && clientApplied
+	}
+	if applied {
+		s.initRoutes()
+	} else {
+		err = s.reloadRoutes()
+		if err != nil {
+			ctx.Error(gtype.ErrInternal, err)
+			return
+		}
+	}
+	ctx.Success(nil)
+
+	if target != nil {
+		go s.writeWebSocketMessage(WSReviseProxyTargetMod, &config.ProxyTargetEdit{
+			ServerId: server.Id,
+			Target:   *target,
+		})
+	} else {
+		edit := &config.ProxyServerEdit{}
+		edit.CopyFrom(server)
+		go s.writeWebSocketMessage(WSReviseProxyServerMod, edit)
+	}
+}
+
+func (s *Proxy) ModifyProxyThrottleDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	catalog := s.proxyCatalog(doc)
+	function := catalog.AddFunction(method, uri, "修改带宽限制")
+	function.SetNote("修改服务器(目标地址标识ID为空时)或目标地址的带宽限制，保存配置并立即对运行中的服务生效，无需重启")
+	function.SetInputJsonExample(&config.ProxyThrottleEdit{
+		ServerId: gtype.NewGuid(),
+		TargetId: "",
+		Throttle: config.ProxyThrottle{
+			Upload:   10485760,
+			Download: 52428800,
+		},
+		ClientThrottle: config.ProxyThrottle{
+			Upload:   1048576,
+			Download: 5242880,
+			Burst:    10485760,
+		},
+	})
+	function.SetOutputDataExample(nil)
+	function.AddOutputError(gtype.ErrInput)
+	function.AddOutputError(gtype.ErrInternal)
+	function.AddOutputError(gtype.ErrTokenInvalid)
+}
+
+func (s *Proxy) PurgeProxyCache(ctx gtype.Context, ps gtype.Params) {
+	argument := &proxy.CacheFilter{}
+	err := ctx.GetJson(argument)
+	if err != nil {
+		ctx.Error(gtype.ErrInput, err)
+		return
+	}
+
+	count := s.proxyServer.PurgeCache(argument)
+	s.LogInfo("proxy cache purged: ", count)
+
+	ctx.Success(&proxy.CachePurge{Count: count})
+}
+
+func (s *Proxy) PurgeProxyCacheDoc(doc gtype.Doc, method string, uri gtype.Uri) {
+	catalog := s.proxyCatalog(doc)
+	function := catalog.AddFunction(method, uri, "清除缓存")
+	function.SetNote("清除与条件匹配的缓存响应，条件均为空时清除全部")
+	function.SetInputJsonExample(&proxy.CacheFilter{
+		Domain: "test.com",
+		Prefix: "/static/",
+	})
+	function.SetOutputDataExample(&proxy.CachePurge{Count: 16})
+	function.AddOutputError(gtype.ErrInput)
+	function.AddOutputError(gtype.ErrInternal)
+	function.AddOutputError(gtype.ErrTokenInvalid)
+}
+
 func (s *Proxy) proxyCatalog(doc gtype.Doc) gtype.Catalog {
 	return s.createCatalog(doc, "反向代理")
 }
@@ -769,19 +1174,31 @@ func (s *Proxy) saveConfig() error {
 	return cfg.SaveToFile(s.cfg.Path)
 }
 
+// reloadRoutes rebuilds the routes of the config and applies them to the
+// running service at once, the routes not changed keep their state.
+func (s *Proxy) reloadRoutes() error {
+	s.initRoutes()
+	if s.cfg.ReverseProxy.Disable {
+		return nil
+	}
+
+	return s.applyRoutes()
+}
+
 func (s *Proxy) initRoutes() {
-	s.proxyServer.Routes = make([]gproxy.Route, 0)
+	s.proxyServer.Routes = make([]proxy.Route, 0)
 
 	if s.cfg == nil {
 		return
 	}
+	now := time.Now()
 	serverCount := len(s.cfg.ReverseProxy.Servers)
 	for serverIndex := 0; serverIndex < serverCount; serverIndex++ {
 		server := s.cfg.ReverseProxy.Servers[serverIndex]
 		if server == nil {
 			continue
 		}
-		if server.Disable {
+		if !server.IsEnabled(now) {
 			continue
 		}
 
@@ -791,34 +1208,93 @@ func (s *Proxy) initRoutes() {
 			if target == nil {
 				continue
 			}
-			if target.Disable {
+			if !target.IsEnabled(now) {
+				continue
+			}
+
+			if !server.IsHttp() && target.IsHttpOnly() {
 				continue
 			}
 
-			s.proxyServer.Routes = append(s.proxyServer.Routes, gproxy.Route{
-				IsTls:        server.TLS,
-				Address:      fmt.Sprintf("%s:%s", server.IP, server.Port),
-				Domain:       target.Domain,
-				Path:         target.Path,
-				Target:       fmt.Sprintf("%s:%s", target.IP, target.Port),
-				Version:      target.Version,
-				SpareTargets: target.SpareTargets(),
-			})
+			route := proxy.Route{
+				Name:     server.Name,
+				Protocol: server.ServerProtocol(),
+				Mode:     server.Mode,
+				IsTls:    server.TLS,
+				Http2:    server.Http2,
+				Address:  server.Address(),
+				V6Only:   server.V6Only,
+				Socket:   s.newProxySocket(server),
+				Domain:   target.Domain,
+				Path:     target.Path,
+			}
+			route.Termination = s.newProxyTermination(server)
+			route.Maintenance = s.newProxyMaintenance(server, target)
+			route.Headers = s.newProxyHeaders(server, target)
+			route.Compress = s.newProxyCompress(server, target)
+			route.Auth = s.newProxyAuth(&target.Auth)
+			route.Jwt = s.newProxyJwt(&target.Jwt)
+			route.ClientCert = s.newProxyClientCert(server, target)
+			route.Cors = target.Cors.Proxy()
+			route.RequestId = proxy.RequestId{
+				Enable: server.RequestId.Enable,
+				Header: server.RequestId.Header,
+				Trust:  server.RequestId.Trust,
+			}
+			route.AccessLog = server.AccessLog
+			route.Timeout = s.newProxyTimeout(server, target)
+			route.Limits = s.newProxyLimits(server, target)
+			if !server.IsUdp() {
+				route.ServerThrottle = s.newProxyThrottle(server.Id, &server.Throttle)
+				route.ClientThrottle = s.newProxyThrottle(server.Id, &server.ClientThrottle)
+			}
+			switch target.TargetType() {
+			case config.ProxyTargetTypeRedirect:
+				route.Redirect = s.newProxyRedirect(&target.Redirect)
+			case config.ProxyTargetTypeStatic:
+				route.Static = s.newProxyStatic(&target.Static)
+			default:
+				route.Target = target.Address()
+				route.Version = target.Version
+				route.TargetHttp2 = target.Http2
+				route.SpareTargets = target.SpareTargets()
+				route.Balance = target.Balance
+				route.Resolve = s.newProxyResolve(&target.Resolve)
+				route.Health = s.newProxyHealth(&target.Health)
+				route.Retry = s.newProxyRetry(&target.Retry)
+				route.WebSocket = s.newProxyWebSocket(&target.WebSocket)
+				route.Cache = s.newProxyCache(target)
+				route.Mirror = s.newProxyMirror(target)
+				if !server.IsUdp() {
+					route.Throttle = s.newProxyThrottle(target.Id, &target.Throttle)
+				}
+				route.ErrorPages = s.newProxyErrorPages(server, target)
+			}
+
+			s.proxyServer.Routes = append(s.proxyServer.Routes, route)
 		}
 	}
 }
 
-func (s *Proxy) onProxyServerStatusChanged(status gproxy.Status) {
+func (s *Proxy) onProxyServerStatusChanged(status proxy.Status) {
 	s.LogInfo("proxy service status changed: ", status)
 	s.writeWebSocketMessage(WSReviseProxyServiceStatus, s.proxyServer.Result())
 }
 
-func (s *Proxy) onProxyConnected(link gproxy.Link) {
+func (s *Proxy) onProxyConnected(link proxy.Link) {
 	s.proxyLinks.Add(&link)
 	s.writeWebSocketMessage(WSReviseProxyConnectionOpen, link)
 }
 
-func (s *Proxy) onProxyDisconnected(link gproxy.Link) {
+func (s *Proxy) onProxyDisconnected(link proxy.Link) {
 	s.proxyLinks.Del(link.Id)
 	s.writeWebSocketMessage(WSReviseProxyConnectionShut, link)
 }
+
+func (s *Proxy) onProxyBackendChanged(backend proxy.Backend) {
+	if backend.Ejected {
+		s.writeWebSocketMessage(WSReviseProxyBackendEject, backend)
+	} else {
+		s.writeWebSocketMessage(WSReviseProxyBackendRestore, backend)
+	}
+}
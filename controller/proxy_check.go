@@ -0,0 +1,1018 @@
+package controller
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/csby/grps/config"
+	"github.com/csby/grps/proxy"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *Proxy) checkProxyTarget(target *config.ProxyTarget) error {
+	switch target.TargetType() {
+	case config.ProxyTargetTypeProxy:
+		if target.Resolve.Refresh < 0 {
+			return fmt.Errorf("域名重新解析间隔(%d)无效", target.Resolve.Refresh)
+		}
+		err := s.checkProxyAddress(target.IP, target.Port, "目标", target.Resolve.Srv)
+		if err != nil {
+			return err
+		}
+		if target.Version < 0 || target.Version > 2 {
+			return fmt.Errorf("版本号(%d)无效", target.Version)
+		}
+		c := len(target.Spares)
+		for i := 0; i < c; i++ {
+			spare := target.Spares[i]
+			if spare == nil {
+				return fmt.Errorf("备用目标项目为空")
+			}
+			err = s.checkProxyAddress(spare.IP, spare.Port, "备用目标", target.Resolve.Srv)
+			if err != nil {
+				return err
+			}
+		}
+		switch target.Balance {
+		case "", config.ProxyBalanceFailover, config.ProxyBalanceRoundRobin:
+		default:
+			return fmt.Errorf("负载均衡方式(%s)无效", target.Balance)
+		}
+		if target.WebSocket.Idle < 0 {
+			return fmt.Errorf("WebSocket空闲超时(%d)无效", target.WebSocket.Idle)
+		}
+		err = s.checkProxyCache(&target.Cache)
+		if err != nil {
+			return err
+		}
+	case config.ProxyTargetTypeRedirect:
+		err := s.checkProxyRedirect(&target.Redirect)
+		if err != nil {
+			return err
+		}
+	case config.ProxyTargetTypeStatic:
+		err := s.checkProxyStatic(&target.Static)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("目标类型(%s)无效", target.Type)
+	}
+
+	err := s.checkProxyHealth(&target.Health)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyRetry(&target.Retry)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyMaintenance(&target.Maintenance)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyErrorPages(target.ErrorPages)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyHeaders(&target.Headers)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyCompress(&target.Compress)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyAuth(&target.Auth)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyJwt(&target.Jwt)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyClientCert(target)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyCors(&target.Cors)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyLimits(&target.Limits)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyMirror(&target.Mirror)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxyThrottle(&target.Throttle)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProxySchedule(&target.Schedule)
+	if err != nil {
+		return err
+	}
+
+	return s.checkProxyTimeout(&target.Timeout)
+}
+
+// checkProxyServer checks the settings of the server shared by adding and
+// modifying it.
+func (s *Proxy) checkProxyServer(server *config.ProxyServerAdd) error {
+	if len(server.Name) < 1 {
+		return fmt.Errorf("名称为空")
+	}
+	err := s.checkProxyProtocol(server)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyListen(server)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyTermination(server)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyMaintenance(&server.Maintenance)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyErrorPages(server.ErrorPages)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyHeaders(&server.Headers)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyCompress(&server.Compress)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyRequestId(&server.RequestId)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyLimits(&server.Limits)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyServerThrottle(server)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxySchedule(&server.Schedule)
+	if err != nil {
+		return err
+	}
+
+	return s.checkProxyTimeout(&server.Timeout)
+}
+
+// checkProxyListen checks the listen address of the server, which is either an
+// ip address (empty for all) and port or the path of a unix domain socket.
+func (s *Proxy) checkProxyListen(server *config.ProxyServerAdd) error {
+	if config.IsUnixAddress(server.IP) {
+		if server.IsUdp() {
+			return fmt.Errorf("UDP服务器不支持Unix套接字")
+		}
+		path := strings.TrimPrefix(server.IP, config.ProxyUnixPrefix)
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("Unix套接字路径(%s)无效，必须为绝对路径", path)
+		}
+		if len(server.Port) > 0 {
+			return fmt.Errorf("Unix套接字不需要监听端口")
+		}
+
+		return s.checkProxySocket(&server.Socket)
+	}
+
+	if len(server.IP) > 0 {
+		addr := parseScopedIP(server.IP)
+		if addr == nil {
+			return fmt.Errorf("IP地址(%s)无效", server.IP)
+		}
+		if server.V6Only && addr.To4() != nil {
+			return fmt.Errorf("仅监听IPv6时IP地址(%s)不能为IPv4地址", server.IP)
+		}
+	}
+	if len(server.Port) < 1 {
+		return fmt.Errorf("监听端口为空")
+	}
+	port, err := strconv.ParseUint(server.Port, 10, 16)
+	if err != nil || port < 1 {
+		return fmt.Errorf("监听端口(%s)无效", server.Port)
+	}
+
+	return nil
+}
+
+// parseScopedIP parses the ip address which may be a scoped ipv6 address such as fe80::1%eth0.
+func parseScopedIP(value string) net.IP {
+	ip, zone, found := strings.Cut(value, "%")
+	if found && (len(zone) < 1 || !strings.Contains(ip, ":")) {
+		return nil
+	}
+
+	return net.ParseIP(ip)
+}
+
+func (s *Proxy) checkProxySocket(socket *config.ProxySocket) error {
+	if len(socket.Mode) > 0 {
+		mode, err := strconv.ParseUint(socket.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("Unix套接字文件权限(%s)无效", socket.Mode)
+		}
+	}
+	if len(socket.User) > 0 {
+		_, err := lookupUid(socket.User)
+		if err != nil {
+			return fmt.Errorf("Unix套接字文件所有者(%s)无效: %v", socket.User, err)
+		}
+	}
+	if len(socket.Group) > 0 {
+		_, err := lookupGid(socket.Group)
+		if err != nil {
+			return fmt.Errorf("Unix套接字文件所属组(%s)无效: %v", socket.Group, err)
+		}
+	}
+
+	return nil
+}
+
+// checkProxyAddress checks the address of a target, which is either a host and
+// port, a SRV record name when srv is true, or the path of a unix domain socket;
+// name is the kind of the target.
+func (s *Proxy) checkProxyAddress(ip, port, name string, srv bool) error {
+	if len(ip) < 1 {
+		return fmt.Errorf("%s地址为空", name)
+	}
+	if config.IsUnixAddress(ip) {
+		if srv {
+			return fmt.Errorf("%s使用SRV记录时不支持Unix套接字", name)
+		}
+		path := strings.TrimPrefix(ip, config.ProxyUnixPrefix)
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("%sUnix套接字路径(%s)无效，必须为绝对路径", name, path)
+		}
+		if len(port) > 0 {
+			return fmt.Errorf("%sUnix套接字不需要端口", name)
+		}
+		return nil
+	}
+
+	if strings.ContainsAny(ip, "[]") {
+		return fmt.Errorf("%s地址(%s)无效，IPv6地址不需要方括号", name, ip)
+	}
+	if parseScopedIP(ip) == nil && !isHostName(ip) {
+		return fmt.Errorf("%s地址(%s)无效", name, ip)
+	}
+	if srv {
+		if len(port) > 0 {
+			return fmt.Errorf("%s使用SRV记录时不需要端口", name)
+		}
+		return nil
+	}
+	if len(port) < 1 {
+		return fmt.Errorf("%s端口为空", name)
+	}
+	value, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || value < 1 {
+		return fmt.Errorf("%s端口(%s)无效", name, port)
+	}
+
+	return nil
+}
+
+// isHostName reports whether the value is a valid dns name, underscores are
+// allowed for the names of SRV records.
+func isHostName(value string) bool {
+	name := strings.TrimSuffix(value, ".")
+	if len(name) < 1 || len(name) > 253 {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for i := 0; i < len(labels); i++ {
+		label := labels[i]
+		if len(label) < 1 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for j := 0; j < len(label); j++ {
+			c := label[j]
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+				continue
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Proxy) checkProxyProtocol(server *config.ProxyServerAdd) error {
+	switch server.Protocol {
+	case "", config.ProxyProtocolTcp, config.ProxyProtocolUdp:
+	default:
+		return fmt.Errorf("协议(%s)无效", server.Protocol)
+	}
+	if server.IsUdp() && server.TLS {
+		return fmt.Errorf("UDP服务器不支持TLS连接")
+	}
+	switch server.Mode {
+	case "", config.ProxyModeTcp:
+	default:
+		return fmt.Errorf("模式(%s)无效", server.Mode)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyTermination(server *config.ProxyServerAdd) error {
+	termination := &server.Termination
+	if !termination.Enable {
+		return nil
+	}
+	if !server.TLS || server.IsUdp() || server.IsTcpMode() {
+		return fmt.Errorf("仅TLS连接的服务器支持TLS终止")
+	}
+	if len(termination.CertFile) < 1 {
+		return fmt.Errorf("证书文件为空")
+	}
+	if len(termination.KeyFile) < 1 {
+		return fmt.Errorf("私钥文件为空")
+	}
+	_, err := tls.LoadX509KeyPair(termination.CertFile, termination.KeyFile)
+	if err != nil {
+		return fmt.Errorf("证书或私钥无效: %v", err)
+	}
+	if len(termination.ClientCaFile) < 1 {
+		if termination.RequireClientCert {
+			return fmt.Errorf("要求客户端证书时客户端CA证书文件不能为空")
+		}
+		if len(termination.CrlFile) > 0 {
+			return fmt.Errorf("指定证书吊销列表时客户端CA证书文件不能为空")
+		}
+		return nil
+	}
+	check := &proxy.Termination{
+		CertFile:     termination.CertFile,
+		KeyFile:      termination.KeyFile,
+		ClientCaFile: termination.ClientCaFile,
+		CrlFile:      termination.CrlFile,
+	}
+	err = check.Check()
+	if err != nil {
+		return fmt.Errorf("客户端CA证书或证书吊销列表无效: %v", err)
+	}
+
+	return nil
+}
+
+// checkProxyUdpTarget checks whether the target is able to receive the datagrams of udp servers.
+func (s *Proxy) checkProxyUdpTarget(target *config.ProxyTarget) error {
+	if target == nil {
+		return nil
+	}
+	if target.IsHttpOnly() {
+		return fmt.Errorf("UDP服务器不支持目标类型(%s)", target.Type)
+	}
+	if target.Mirror.Enable {
+		return fmt.Errorf("UDP服务器不支持流量镜像")
+	}
+	if target.Throttle.IsLimited() {
+		return fmt.Errorf("UDP服务器不支持带宽限制")
+	}
+	if target.Version != 0 {
+		return fmt.Errorf("UDP服务器不支持添加代理头部")
+	}
+	if config.IsUnixAddress(target.IP) {
+		return fmt.Errorf("UDP服务器不支持Unix套接字目标")
+	}
+	c := len(target.Spares)
+	for i := 0; i < c; i++ {
+		spare := target.Spares[i]
+		if spare != nil && config.IsUnixAddress(spare.IP) {
+			return fmt.Errorf("UDP服务器不支持Unix套接字备用目标")
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyHealth(health *config.ProxyHealth) error {
+	if health.MaxFails < 0 {
+		return fmt.Errorf("连接失败次数阈值(%d)无效", health.MaxFails)
+	}
+	if health.MaxTimeouts < 0 {
+		return fmt.Errorf("连接超时次数阈值(%d)无效", health.MaxTimeouts)
+	}
+	if health.MaxErrors < 0 {
+		return fmt.Errorf("5xx响应次数阈值(%d)无效", health.MaxErrors)
+	}
+	if health.EjectTime < 0 {
+		return fmt.Errorf("隔离时长(%d)无效", health.EjectTime)
+	}
+	if health.MaxEjectTime < 0 {
+		return fmt.Errorf("最大隔离时长(%d)无效", health.MaxEjectTime)
+	}
+	if health.MaxEjectTime > 0 && health.MaxEjectTime < health.EjectTime {
+		return fmt.Errorf("最大隔离时长(%d)小于隔离时长(%d)", health.MaxEjectTime, health.EjectTime)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyCache(cache *config.ProxyCache) error {
+	if !cache.Enable {
+		return nil
+	}
+	if cache.Size < 0 {
+		return fmt.Errorf("内存缓存容量(%d)无效", cache.Size)
+	}
+	if cache.DiskSize < 0 {
+		return fmt.Errorf("磁盘缓存容量(%d)无效", cache.DiskSize)
+	}
+	if cache.EntrySize < 0 {
+		return fmt.Errorf("可缓存的最大响应体(%d)无效", cache.EntrySize)
+	}
+	if len(cache.Folder) > 0 && !filepath.IsAbs(cache.Folder) {
+		return fmt.Errorf("磁盘缓存目录(%s)无效，必须为绝对路径", cache.Folder)
+	}
+	c := len(cache.KeyHeaders)
+	for i := 0; i < c; i++ {
+		if len(strings.TrimSpace(cache.KeyHeaders[i])) < 1 {
+			return fmt.Errorf("缓存键的请求头名称为空")
+		}
+	}
+	if cache.Ttl < 0 {
+		return fmt.Errorf("缓存时间(%d)无效", cache.Ttl)
+	}
+	if cache.StaleWhileRevalidate < 0 || cache.StaleIfError < 0 {
+		return fmt.Errorf("过期响应的使用时间无效")
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyCompress(compress *config.ProxyCompress) error {
+	if !compress.Enable {
+		return nil
+	}
+	c := len(compress.Encodings)
+	for i := 0; i < c; i++ {
+		switch compress.Encodings[i] {
+		case config.ProxyEncodingGzip, config.ProxyEncodingBrotli, config.ProxyEncodingZstd:
+		default:
+			return fmt.Errorf("压缩编码(%s)无效", compress.Encodings[i])
+		}
+	}
+	if compress.MinSize < 0 {
+		return fmt.Errorf("压缩的最小响应体(%d)无效", compress.MinSize)
+	}
+	c = len(compress.Types)
+	for i := 0; i < c; i++ {
+		item := compress.Types[i]
+		index := strings.Index(item, "/")
+		if index < 1 || index == len(item)-1 {
+			return fmt.Errorf("内容类型(%s)无效", item)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyAuth(auth *config.ProxyAuth) error {
+	switch auth.Type {
+	case "":
+		return nil
+	case config.ProxyAuthTypeBasic:
+		c := len(auth.Users)
+		if c < 1 {
+			return fmt.Errorf("用户为空")
+		}
+		names := make(map[string]bool)
+		for i := 0; i < c; i++ {
+			user := auth.Users[i]
+			if user == nil {
+				return fmt.Errorf("用户项目为空")
+			}
+			if len(user.Name) < 1 || strings.Contains(user.Name, ":") {
+				return fmt.Errorf("用户名(%s)无效", user.Name)
+			}
+			if names[user.Name] {
+				return fmt.Errorf("用户名(%s)重复", user.Name)
+			}
+			names[user.Name] = true
+			if len(user.Password) < 1 {
+				return fmt.Errorf("用户(%s)的密码为空", user.Name)
+			}
+		}
+	case config.ProxyAuthTypeForward:
+		u, err := url.Parse(auth.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) < 1 {
+			return fmt.Errorf("认证服务地址(%s)无效", auth.Url)
+		}
+		if auth.Timeout < 0 {
+			return fmt.Errorf("子请求超时(%d)无效", auth.Timeout)
+		}
+		c := len(auth.RequestHeaders)
+		for i := 0; i < c; i++ {
+			if len(strings.TrimSpace(auth.RequestHeaders[i])) < 1 {
+				return fmt.Errorf("复制到子请求的请求头名称为空")
+			}
+		}
+		c = len(auth.ResponseHeaders)
+		for i := 0; i < c; i++ {
+			if len(strings.TrimSpace(auth.ResponseHeaders[i])) < 1 {
+				return fmt.Errorf("复制到请求的头部名称为空")
+			}
+		}
+	default:
+		return fmt.Errorf("认证方式(%s)无效", auth.Type)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyJwt(jwt *config.ProxyJwt) error {
+	if !jwt.Enable {
+		return nil
+	}
+
+	c := len(jwt.Keys)
+	for i := 0; i < c; i++ {
+		key := jwt.Keys[i]
+		if key == nil {
+			return fmt.Errorf("密钥项目为空")
+		}
+		if len(key.Secret) > 0 {
+			if len(key.Public) > 0 {
+				return fmt.Errorf("密钥(%s)的公钥与共享密钥不能同时指定", key.Id)
+			}
+			continue
+		}
+		_, err := parseProxyJwtKey(key.Public)
+		if err != nil {
+			return fmt.Errorf("密钥(%s)的公钥无效: %v", key.Id, err)
+		}
+	}
+	if len(jwt.JwksUrl) > 0 {
+		u, err := url.Parse(jwt.JwksUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) < 1 {
+			return fmt.Errorf("JWKS文档地址(%s)无效", jwt.JwksUrl)
+		}
+	} else if jwt.Discovery {
+		u, err := url.Parse(jwt.Issuer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) < 1 {
+			return fmt.Errorf("通过OIDC配置获取JWKS文档时签发者(%s)须为http或https地址", jwt.Issuer)
+		}
+	} else if c < 1 {
+		return fmt.Errorf("静态密钥与JWKS文档地址不能同时为空")
+	}
+	if jwt.Refresh < 0 {
+		return fmt.Errorf("JWKS文档刷新间隔(%d)无效", jwt.Refresh)
+	}
+	if jwt.Leeway < 0 {
+		return fmt.Errorf("允许的时钟偏差(%d)无效", jwt.Leeway)
+	}
+	c = len(jwt.Algorithms)
+	for i := 0; i < c; i++ {
+		switch jwt.Algorithms[i] {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA",
+			"HS256", "HS384", "HS512":
+		default:
+			return fmt.Errorf("签名算法(%s)无效", jwt.Algorithms[i])
+		}
+	}
+	c = len(jwt.Claims)
+	for i := 0; i < c; i++ {
+		claim := jwt.Claims[i]
+		if claim == nil || len(claim.Name) < 1 {
+			return fmt.Errorf("必须的声明名称为空")
+		}
+	}
+	c = len(jwt.Headers)
+	for i := 0; i < c; i++ {
+		header := jwt.Headers[i]
+		if header == nil || len(header.Claim) < 1 {
+			return fmt.Errorf("转发的声明名称为空")
+		}
+		if len(strings.TrimSpace(header.Header)) < 1 {
+			return fmt.Errorf("声明(%s)的请求头名称为空", header.Claim)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyClientCert(target *config.ProxyTarget) error {
+	cert := &target.ClientCert
+	c := len(cert.Subjects)
+	for i := 0; i < c; i++ {
+		if len(strings.TrimSpace(cert.Subjects[i])) < 1 {
+			return fmt.Errorf("允许的证书主题为空")
+		}
+	}
+	c = len(cert.Sans)
+	for i := 0; i < c; i++ {
+		san := strings.TrimSpace(cert.Sans[i])
+		if len(san) < 1 {
+			return fmt.Errorf("允许的证书主题备用名称为空")
+		}
+		if strings.Contains(san, "*") && (!strings.HasPrefix(san, "*.") || strings.Count(san, "*") > 1) {
+			return fmt.Errorf("允许的证书主题备用名称(%s)无效，通配符仅可用于最左侧标签", san)
+		}
+	}
+	if cert.Tlv && target.Version != 2 {
+		return fmt.Errorf("以TLV转发TLS连接信息需添加代理头部(版本2)")
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyCors(cors *config.Cors) error {
+	if !cors.Enable {
+		return nil
+	}
+
+	c := len(cors.Origins)
+	if c < 1 {
+		return fmt.Errorf("允许的来源为空")
+	}
+	for i := 0; i < c; i++ {
+		origin := cors.Origins[i]
+		if origin == "*" && cors.Credentials {
+			return fmt.Errorf("允许携带凭据时允许的来源不能为*")
+		}
+		if origin == "*" || origin == "null" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+		if err != nil || len(u.Scheme) < 1 || len(u.Host) < 1 || (len(u.Path) > 0 && u.Path != "/") || strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("允许的来源(%s)无效", origin)
+		}
+	}
+	c = len(cors.Methods)
+	for i := 0; i < c; i++ {
+		if len(strings.TrimSpace(cors.Methods[i])) < 1 {
+			return fmt.Errorf("允许的方法为空")
+		}
+	}
+	c = len(cors.Headers)
+	for i := 0; i < c; i++ {
+		if len(strings.TrimSpace(cors.Headers[i])) < 1 {
+			return fmt.Errorf("允许的请求头为空")
+		}
+	}
+	c = len(cors.ExposeHeaders)
+	for i := 0; i < c; i++ {
+		if len(strings.TrimSpace(cors.ExposeHeaders[i])) < 1 {
+			return fmt.Errorf("允许脚本读取的响应头为空")
+		}
+	}
+	if cors.MaxAge < 0 {
+		return fmt.Errorf("预检结果的缓存时间(%d)无效", cors.MaxAge)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyRetry(retry *config.ProxyRetry) error {
+	if retry.Attempts < 0 || retry.Attempts > 10 {
+		return fmt.Errorf("最大尝试次数(%d)无效，有效范围0-10", retry.Attempts)
+	}
+	switch retry.Backend {
+	case "", config.ProxyRetryBackendNext, config.ProxyRetryBackendSame:
+	default:
+		return fmt.Errorf("重试目标(%s)无效", retry.Backend)
+	}
+	if retry.Backoff < 0 {
+		return fmt.Errorf("重试间隔(%d)无效", retry.Backoff)
+	}
+	if retry.MaxBackoff < 0 {
+		return fmt.Errorf("最大重试间隔(%d)无效", retry.MaxBackoff)
+	}
+	if retry.MaxBackoff > 0 && retry.MaxBackoff < retry.Backoff {
+		return fmt.Errorf("最大重试间隔(%d)小于重试间隔(%d)", retry.MaxBackoff, retry.Backoff)
+	}
+	c := len(retry.RetryOn)
+	for i := 0; i < c; i++ {
+		switch retry.RetryOn[i] {
+		case config.ProxyRetryOnConnect, config.ProxyRetryOn502, config.ProxyRetryOn503,
+			config.ProxyRetryOn504, config.ProxyRetryOnReset:
+		default:
+			return fmt.Errorf("重试条件(%s)无效", retry.RetryOn[i])
+		}
+	}
+	if retry.Budget < 0 || retry.Budget > 100 {
+		return fmt.Errorf("重试预算(%d)无效，有效范围0-100", retry.Budget)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyRedirect(redirect *config.ProxyRedirect) error {
+	switch redirect.Code {
+	case 0, 301, 302, 303, 307, 308:
+	default:
+		return fmt.Errorf("重定向状态码(%d)无效", redirect.Code)
+	}
+	if len(redirect.Location) < 1 {
+		return fmt.Errorf("跳转地址为空")
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyStatic(static *config.ProxyStatic) error {
+	if static.Code != 0 && (static.Code < 200 || static.Code > 599) {
+		return fmt.Errorf("状态码(%d)无效", static.Code)
+	}
+	c := len(static.Headers)
+	for i := 0; i < c; i++ {
+		header := static.Headers[i]
+		if header == nil {
+			return fmt.Errorf("响应头项目为空")
+		}
+		if len(header.Name) < 1 {
+			return fmt.Errorf("响应头名称为空")
+		}
+	}
+	if len(static.File) > 0 {
+		if len(static.Body) > 0 {
+			return fmt.Errorf("响应内容与文件不能同时指定")
+		}
+		_, err := os.Stat(static.File)
+		if err != nil {
+			return fmt.Errorf("响应内容文件(%s)无效: %v", static.File, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyMaintenance(maintenance *config.ProxyMaintenance) error {
+	if maintenance.Code != 0 && (maintenance.Code < 200 || maintenance.Code > 599) {
+		return fmt.Errorf("维护模式状态码(%d)无效", maintenance.Code)
+	}
+	if maintenance.RetryAfter < 0 {
+		return fmt.Errorf("维护模式重试间隔(%d)无效", maintenance.RetryAfter)
+	}
+	if len(maintenance.File) > 0 {
+		if len(maintenance.Body) > 0 {
+			return fmt.Errorf("维护模式响应内容与文件不能同时指定")
+		}
+		_, err := os.Stat(maintenance.File)
+		if err != nil {
+			return fmt.Errorf("维护模式响应内容文件(%s)无效: %v", maintenance.File, err)
+		}
+	}
+	c := len(maintenance.Whitelist)
+	for i := 0; i < c; i++ {
+		_, err := proxy.ParseIPNet(maintenance.Whitelist[i])
+		if err != nil {
+			return fmt.Errorf("维护模式白名单(%s)无效", maintenance.Whitelist[i])
+		}
+	}
+	if maintenance.StartTime != nil && maintenance.EndTime != nil {
+		if !time.Time(*maintenance.StartTime).Before(time.Time(*maintenance.EndTime)) {
+			return fmt.Errorf("维护模式结束时间必须晚于开始时间")
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyErrorPages(pages []*config.ProxyErrorPage) error {
+	codes := make(map[int]bool)
+	c := len(pages)
+	for i := 0; i < c; i++ {
+		page := pages[i]
+		if page == nil {
+			return fmt.Errorf("错误页面项目为空")
+		}
+		switch page.Code {
+		case 502, 503, 504:
+		default:
+			return fmt.Errorf("错误页面状态码(%d)无效", page.Code)
+		}
+		if codes[page.Code] {
+			return fmt.Errorf("错误页面状态码(%d)重复", page.Code)
+		}
+		codes[page.Code] = true
+
+		if len(page.File) < 1 {
+			return fmt.Errorf("错误页面文件为空")
+		}
+		_, err := os.Stat(page.File)
+		if err != nil {
+			return fmt.Errorf("错误页面文件(%s)无效: %v", page.File, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyHeaders(headers *config.ProxyHeaders) error {
+	err := s.checkProxyHeaderRules(headers.Request)
+	if err != nil {
+		return fmt.Errorf("请求头规则无效: %v", err)
+	}
+	err = s.checkProxyHeaderRules(headers.Response)
+	if err != nil {
+		return fmt.Errorf("响应头规则无效: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyHeaderRules(rules []*config.ProxyHeaderRule) error {
+	c := len(rules)
+	for i := 0; i < c; i++ {
+		rule := rules[i]
+		if rule == nil {
+			return fmt.Errorf("项目为空")
+		}
+		switch rule.Action {
+		case config.ProxyHeaderActionSet, config.ProxyHeaderActionAppend, config.ProxyHeaderActionRemove:
+		default:
+			return fmt.Errorf("操作(%s)无效", rule.Action)
+		}
+		if len(rule.Name) < 1 {
+			return fmt.Errorf("名称为空")
+		}
+		if strings.ContainsAny(rule.Name, " \t\r\n:") {
+			return fmt.Errorf("名称(%s)无效", rule.Name)
+		}
+		if strings.ContainsAny(rule.Value, "\r\n") {
+			return fmt.Errorf("值(%s)无效", rule.Value)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyRequestId(requestId *config.ProxyRequestId) error {
+	if strings.ContainsAny(requestId.Header, " \t\r\n:") {
+		return fmt.Errorf("请求ID头部名称(%s)无效", requestId.Header)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyTimeout(timeout *config.ProxyTimeout) error {
+	if timeout.Connect < 0 {
+		return fmt.Errorf("连接超时(%d)无效", timeout.Connect)
+	}
+	if timeout.Idle < 0 {
+		return fmt.Errorf("空闲超时(%d)无效", timeout.Idle)
+	}
+	if timeout.Lifetime < 0 {
+		return fmt.Errorf("最大会话时长(%d)无效", timeout.Lifetime)
+	}
+	if timeout.ReadHeader < 0 {
+		return fmt.Errorf("读取请求头超时(%d)无效", timeout.ReadHeader)
+	}
+	if timeout.ResponseHeader < 0 {
+		return fmt.Errorf("响应头超时(%d)无效", timeout.ResponseHeader)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyLimits(limits *config.ProxyLimits) error {
+	if limits.MaxHeaderSize < 0 {
+		return fmt.Errorf("请求头最大大小(%d)无效", limits.MaxHeaderSize)
+	}
+	if limits.MaxBodySize < 0 {
+		return fmt.Errorf("请求体最大大小(%d)无效", limits.MaxBodySize)
+	}
+	if limits.MinUploadRate < 0 {
+		return fmt.Errorf("请求体最低上传速率(%d)无效", limits.MinUploadRate)
+	}
+	if limits.UploadTimeout < 0 {
+		return fmt.Errorf("请求体上传超时(%d)无效", limits.UploadTimeout)
+	}
+	if limits.MaxIncomplete < 0 {
+		return fmt.Errorf("未完成请求头的最大连接数(%d)无效", limits.MaxIncomplete)
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxySchedule(schedule *config.ProxySchedule) error {
+	if !schedule.Enable {
+		return nil
+	}
+
+	_, err := schedule.Location()
+	if err != nil {
+		return fmt.Errorf("时区(%s)无效: %v", schedule.TimeZone, err)
+	}
+	if schedule.StartTime != nil && schedule.EndTime != nil {
+		if !time.Time(*schedule.EndTime).After(time.Time(*schedule.StartTime)) {
+			return fmt.Errorf("计划的结束时间须晚于开始时间")
+		}
+	}
+	for i := 0; i < len(schedule.Windows); i++ {
+		window := schedule.Windows[i]
+		if window == nil {
+			continue
+		}
+		for j := 0; j < len(window.Weekdays); j++ {
+			if window.Weekdays[j] < 0 || window.Weekdays[j] > 6 {
+				return fmt.Errorf("时间窗口的星期(%d)无效", window.Weekdays[j])
+			}
+		}
+		start, err := config.ParseScheduleClock(window.Start)
+		if err != nil || start >= 24*60 {
+			return fmt.Errorf("时间窗口的开始时间(%s)无效", window.Start)
+		}
+		_, err = config.ParseScheduleClock(window.End)
+		if err != nil {
+			return fmt.Errorf("时间窗口的结束时间(%s)无效", window.End)
+		}
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyThrottle(throttle *config.ProxyThrottle) error {
+	if throttle.Upload < 0 {
+		return fmt.Errorf("上传速率上限(%d)无效", throttle.Upload)
+	}
+	if throttle.Download < 0 {
+		return fmt.Errorf("下载速率上限(%d)无效", throttle.Download)
+	}
+	if throttle.Burst < 0 {
+		return fmt.Errorf("突发流量(%d)无效", throttle.Burst)
+	}
+
+	return nil
+}
+
+// checkProxyServerThrottle checks the throttles of the server and its clients,
+// which are not supported by udp servers.
+func (s *Proxy) checkProxyServerThrottle(server *config.ProxyServerAdd) error {
+	err := s.checkProxyThrottle(&server.Throttle)
+	if err != nil {
+		return err
+	}
+	err = s.checkProxyThrottle(&server.ClientThrottle)
+	if err != nil {
+		return err
+	}
+	if server.IsUdp() && (server.Throttle.IsLimited() || server.ClientThrottle.IsLimited()) {
+		return fmt.Errorf("UDP服务器不支持带宽限制")
+	}
+
+	return nil
+}
+
+func (s *Proxy) checkProxyMirror(mirror *config.ProxyMirror) error {
+	if !mirror.Enable {
+		return nil
+	}
+
+	err := s.checkProxyAddress(mirror.IP, mirror.Port, "影子目标", false)
+	if err != nil {
+		return err
+	}
+	if mirror.Percent < 0 || mirror.Percent > 100 {
+		return fmt.Errorf("镜像百分比(%v)无效，有效范围0-100", mirror.Percent)
+	}
+	if mirror.Timeout < 0 {
+		return fmt.Errorf("影子目标超时(%d)无效", mirror.Timeout)
+	}
+	if mirror.MaxBodySize < 0 {
+		return fmt.Errorf("镜像的最大请求体(%d)无效", mirror.MaxBodySize)
+	}
+	if mirror.Concurrency < 0 {
+		return fmt.Errorf("镜像的最大并发数(%d)无效", mirror.Concurrency)
+	}
+
+	return nil
+}
@@ -0,0 +1,568 @@
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/csby/grps/config"
+	"github.com/csby/grps/proxy"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+func (s *Proxy) newProxyHealth(health *config.ProxyHealth) proxy.Health {
+	return proxy.Health{
+		Enable:       health.Enable,
+		MaxFails:     health.MaxFails,
+		MaxTimeouts:  health.MaxTimeouts,
+		MaxErrors:    health.MaxErrors,
+		EjectTime:    time.Duration(health.EjectTime) * time.Second,
+		MaxEjectTime: time.Duration(health.MaxEjectTime) * time.Second,
+	}
+}
+
+func (s *Proxy) newProxyRetry(retry *config.ProxyRetry) proxy.Retry {
+	return proxy.Retry{
+		Enable:     retry.Enable,
+		Attempts:   retry.Attempts,
+		Backend:    retry.Backend,
+		Backoff:    time.Duration(retry.Backoff) * time.Millisecond,
+		MaxBackoff: time.Duration(retry.MaxBackoff) * time.Millisecond,
+		RetryOn:    retry.RetryOn,
+		Budget:     retry.Budget,
+	}
+}
+
+func (s *Proxy) newProxyResolve(resolve *config.ProxyResolve) proxy.Resolve {
+	return proxy.Resolve{
+		Refresh: time.Duration(resolve.Refresh) * time.Second,
+		Srv:     resolve.Srv,
+	}
+}
+
+func (s *Proxy) newProxyWebSocket(webSocket *config.ProxyWebSocket) proxy.WebSocket {
+	return proxy.WebSocket{
+		Deny: webSocket.Deny,
+		Idle: time.Duration(webSocket.Idle) * time.Second,
+	}
+}
+
+func (s *Proxy) newProxyCache(target *config.ProxyTarget) *proxy.Cache {
+	cache := &target.Cache
+	if !cache.Enable {
+		return nil
+	}
+
+	return &proxy.Cache{
+		Id:                   target.Id,
+		Size:                 int64(cache.Size) << 20,
+		DiskSize:             int64(cache.DiskSize) << 20,
+		EntrySize:            int64(cache.EntrySize) << 10,
+		Folder:               cache.Folder,
+		KeyHeaders:           cache.KeyHeaders,
+		IgnoreQuery:          cache.IgnoreQuery,
+		Ttl:                  time.Duration(cache.Ttl) * time.Second,
+		StaleWhileRevalidate: time.Duration(cache.StaleWhileRevalidate) * time.Second,
+		StaleIfError:         time.Duration(cache.StaleIfError) * time.Second,
+	}
+}
+
+func (s *Proxy) newProxyMirror(target *config.ProxyTarget) *proxy.Mirror {
+	mirror := &target.Mirror
+	if !mirror.Enable || mirror.Percent <= 0 {
+		return nil
+	}
+
+	return &proxy.Mirror{
+		Id:          target.Id,
+		Target:      mirror.Address(),
+		Percent:     mirror.Percent,
+		Timeout:     time.Duration(mirror.Timeout) * time.Second,
+		MaxBodySize: int64(mirror.MaxBodySize) << 10,
+		Concurrency: mirror.Concurrency,
+	}
+}
+
+// newProxyCompress returns the compression of the target, or of the server
+// when the one of the target is neither enabled nor disabled.
+func (s *Proxy) newProxyCompress(server *config.ProxyServer, target *config.ProxyTarget) *proxy.Compress {
+	compress := &target.Compress
+	if compress.Disable {
+		return nil
+	}
+	if !compress.Enable {
+		compress = &server.Compress
+	}
+	if !compress.Enable {
+		return nil
+	}
+
+	return &proxy.Compress{
+		Encodings: compress.Encodings,
+		MinSize:   int64(compress.MinSize),
+		Types:     compress.Types,
+	}
+}
+
+func (s *Proxy) newProxyAuth(auth *config.ProxyAuth) *proxy.Auth {
+	if len(auth.Type) < 1 {
+		return nil
+	}
+
+	instance := &proxy.Auth{
+		Type:            auth.Type,
+		Realm:           auth.Realm,
+		Users:           make(map[string]string),
+		Url:             auth.Url,
+		RequestHeaders:  auth.RequestHeaders,
+		ResponseHeaders: auth.ResponseHeaders,
+		Timeout:         time.Duration(auth.Timeout) * time.Second,
+	}
+	count := len(auth.Users)
+	for i := 0; i < count; i++ {
+		user := auth.Users[i]
+		if user == nil {
+			continue
+		}
+		// the passwords are hashed on save, a plain one never matches
+		instance.Users[user.Name] = user.Password
+	}
+
+	return instance
+}
+
+// hashProxyPasswords saves the bcrypt hashes of the plain passwords written
+// into the config file by hand, so that they are hashed once rather than on
+// each rebuild of the routes.
+func (s *Proxy) hashProxyPasswords() error {
+	hashed := false
+	servers := s.cfg.ReverseProxy.Servers
+	for i := 0; i < len(servers); i++ {
+		if servers[i] == nil {
+			continue
+		}
+		targets := servers[i].Targets
+		for j := 0; j < len(targets); j++ {
+			if targets[j] == nil || !hasProxyAuthPlainPassword(&targets[j].Auth) {
+				continue
+			}
+			err := s.hashProxyAuthPasswords(&targets[j].Auth)
+			if err != nil {
+				return err
+			}
+			hashed = true
+		}
+	}
+	if !hashed {
+		return nil
+	}
+
+	return s.saveConfig()
+}
+
+func hasProxyAuthPlainPassword(auth *config.ProxyAuth) bool {
+	count := len(auth.Users)
+	for i := 0; i < count; i++ {
+		user := auth.Users[i]
+		if user == nil {
+			continue
+		}
+		_, err := bcrypt.Cost([]byte(user.Password))
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashProxyAuthPasswords replaces the plain passwords of the users with the bcrypt hashes.
+func (s *Proxy) hashProxyAuthPasswords(auth *config.ProxyAuth) error {
+	count := len(auth.Users)
+	for i := 0; i < count; i++ {
+		user := auth.Users[i]
+		if user == nil {
+			continue
+		}
+		_, err := bcrypt.Cost([]byte(user.Password))
+		if err == nil {
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.Password = string(hash)
+	}
+
+	return nil
+}
+
+func (s *Proxy) newProxyJwt(jwt *config.ProxyJwt) *proxy.Jwt {
+	if !jwt.Enable {
+		return nil
+	}
+
+	instance := &proxy.Jwt{
+		Keys:       make([]proxy.JwtKey, 0),
+		JwksUrl:    jwt.JwksUrl,
+		Discovery:  jwt.Discovery,
+		Refresh:    time.Duration(jwt.Refresh) * time.Second,
+		Issuer:     jwt.Issuer,
+		Audience:   jwt.Audience,
+		Algorithms: jwt.Algorithms,
+		Leeway:     time.Duration(jwt.Leeway) * time.Second,
+		Claims:     make(map[string]string),
+		Headers:    make(map[string]string),
+		Realm:      jwt.Realm,
+	}
+	count := len(jwt.Keys)
+	for i := 0; i < count; i++ {
+		item := jwt.Keys[i]
+		if item == nil {
+			continue
+		}
+		if len(item.Secret) > 0 {
+			instance.Keys = append(instance.Keys, proxy.JwtKey{Id: item.Id, Key: []byte(item.Secret)})
+			continue
+		}
+		key, err := parseProxyJwtKey(item.Public)
+		if err != nil {
+			s.LogError("proxy jwt key '", item.Id, "' fail: ", err)
+			continue
+		}
+		instance.Keys = append(instance.Keys, proxy.JwtKey{Id: item.Id, Key: key})
+	}
+	count = len(jwt.Claims)
+	for i := 0; i < count; i++ {
+		item := jwt.Claims[i]
+		if item != nil {
+			instance.Claims[item.Name] = item.Value
+		}
+	}
+	count = len(jwt.Headers)
+	for i := 0; i < count; i++ {
+		item := jwt.Headers[i]
+		if item != nil {
+			instance.Headers[item.Claim] = item.Header
+		}
+	}
+
+	return instance
+}
+
+// parseProxyJwtKey returns the public key of the PEM block, which is either a
+// public key or a certificate.
+func parseProxyJwtKey(data string) (any, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("no pem block")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func (s *Proxy) newProxyRedirect(redirect *config.ProxyRedirect) *proxy.Redirect {
+	return &proxy.Redirect{
+		Code:     redirect.Code,
+		Location: redirect.Location,
+	}
+}
+
+func (s *Proxy) newProxyStatic(static *config.ProxyStatic) *proxy.Static {
+	return &proxy.Static{
+		Code:    static.Code,
+		Headers: s.newHttpHeader(static.Headers),
+		Body:    static.Body,
+		File:    static.File,
+	}
+}
+
+func (s *Proxy) newHttpHeader(headers []*config.ProxyHeader) http.Header {
+	header := make(http.Header)
+	count := len(headers)
+	for i := 0; i < count; i++ {
+		item := headers[i]
+		if item == nil {
+			continue
+		}
+		header.Add(item.Name, item.Value)
+	}
+
+	return header
+}
+
+// newProxyMaintenance returns the maintenance of the target, or of the server
+// when the one of the target is not enabled.
+func (s *Proxy) newProxyMaintenance(server *config.ProxyServer, target *config.ProxyTarget) *proxy.Maintenance {
+	maintenance := &target.Maintenance
+	if !maintenance.Enable {
+		maintenance = &server.Maintenance
+	}
+	if !maintenance.Enable {
+		return nil
+	}
+
+	instance := &proxy.Maintenance{
+		Static: proxy.Static{
+			Code:    maintenance.Code,
+			Headers: make(http.Header),
+			Body:    maintenance.Body,
+			File:    maintenance.File,
+		},
+		RetryAfter: time.Duration(maintenance.RetryAfter) * time.Second,
+		Whitelist:  make([]*net.IPNet, 0),
+	}
+	if len(maintenance.ContentType) > 0 {
+		instance.Headers.Set("Content-Type", maintenance.ContentType)
+	}
+	if maintenance.StartTime != nil {
+		instance.StartTime = time.Time(*maintenance.StartTime)
+	}
+	if maintenance.EndTime != nil {
+		instance.EndTime = time.Time(*maintenance.EndTime)
+	}
+	count := len(maintenance.Whitelist)
+	for i := 0; i < count; i++ {
+		network, err := proxy.ParseIPNet(maintenance.Whitelist[i])
+		if err != nil {
+			s.LogError("invalid maintenance whitelist '", maintenance.Whitelist[i], "': ", err)
+			continue
+		}
+		instance.Whitelist = append(instance.Whitelist, network)
+	}
+
+	return instance
+}
+
+// newProxyErrorPages returns the error pages of the server overridden by the ones of the target.
+func (s *Proxy) newProxyErrorPages(server *config.ProxyServer, target *config.ProxyTarget) map[int]*proxy.ErrorPage {
+	pages := make(map[int]*proxy.ErrorPage)
+	items := append(append([]*config.ProxyErrorPage{}, server.ErrorPages...), target.ErrorPages...)
+	count := len(items)
+	for i := 0; i < count; i++ {
+		item := items[i]
+		if item == nil {
+			continue
+		}
+		pages[item.Code] = &proxy.ErrorPage{
+			File:        item.File,
+			ContentType: item.ContentType,
+		}
+	}
+
+	return pages
+}
+
+// newProxyHeaders returns the header rules of the server followed by the ones of the target.
+func (s *Proxy) newProxyHeaders(server *config.ProxyServer, target *config.ProxyTarget) proxy.Headers {
+	headers := proxy.Headers{
+		XForwarded: server.Headers.XForwarded || target.Headers.XForwarded,
+		XRealIP:    server.Headers.XRealIP || target.Headers.XRealIP,
+		Forwarded:  server.Headers.Forwarded || target.Headers.Forwarded,
+		Request:    make([]proxy.HeaderRule, 0),
+		Response:   make([]proxy.HeaderRule, 0),
+	}
+	headers.Request = s.appendProxyHeaderRules(headers.Request, server.Headers.Request)
+	headers.Request = s.appendProxyHeaderRules(headers.Request, target.Headers.Request)
+	headers.Response = s.appendProxyHeaderRules(headers.Response, server.Headers.Response)
+	headers.Response = s.appendProxyHeaderRules(headers.Response, target.Headers.Response)
+
+	return headers
+}
+
+func (s *Proxy) appendProxyHeaderRules(rules []proxy.HeaderRule, items []*config.ProxyHeaderRule) []proxy.HeaderRule {
+	count := len(items)
+	for i := 0; i < count; i++ {
+		item := items[i]
+		if item == nil {
+			continue
+		}
+		rules = append(rules, proxy.HeaderRule{
+			Action: item.Action,
+			Name:   item.Name,
+			Value:  item.Value,
+		})
+	}
+
+	return rules
+}
+
+// newProxyTimeout returns the timeouts of the server overridden by the non-zero ones of the target.
+func (s *Proxy) newProxyTimeout(server *config.ProxyServer, target *config.ProxyTarget) proxy.Timeout {
+	value := func(serverValue, targetValue int) time.Duration {
+		if targetValue > 0 {
+			return time.Duration(targetValue) * time.Second
+		}
+		return time.Duration(serverValue) * time.Second
+	}
+
+	return proxy.Timeout{
+		Connect:        value(server.Timeout.Connect, target.Timeout.Connect),
+		Idle:           value(server.Timeout.Idle, target.Timeout.Idle),
+		Lifetime:       value(server.Timeout.Lifetime, target.Timeout.Lifetime),
+		ReadHeader:     time.Duration(server.Timeout.ReadHeader) * time.Second,
+		KeepAlive:      time.Duration(server.Timeout.Idle) * time.Second,
+		ResponseHeader: value(server.Timeout.ResponseHeader, target.Timeout.ResponseHeader),
+	}
+}
+
+// newProxyThrottle returns nil when there is no limit, which leaves the
+// connections unwrapped.
+func (s *Proxy) newProxyThrottle(id string, throttle *config.ProxyThrottle) *proxy.Throttle {
+	if !throttle.IsLimited() {
+		return nil
+	}
+
+	return &proxy.Throttle{
+		Id:       id,
+		Upload:   throttle.Upload,
+		Download: throttle.Download,
+		Burst:    throttle.Burst,
+	}
+}
+
+// setProxyThrottle changes the limits of the running throttle at once, and
+// returns false when the routes are to be reloaded as the throttle is added or
+// removed.
+func (s *Proxy) setProxyThrottle(scope, id string, throttle *config.ProxyThrottle) bool {
+	value := s.newProxyThrottle(id, throttle)
+	if value == nil {
+		// the running one is unlimited at once until the reload removes it
+		return !s.proxyServer.SetThrottle(scope, proxy.Throttle{Id: id})
+	}
+
+	return s.proxyServer.SetThrottle(scope, *value)
+}
+
+// newProxyLimits returns the request limits of the server overridden by the non-zero ones of the target.
+func (s *Proxy) newProxyLimits(server *config.ProxyServer, target *config.ProxyTarget) proxy.Limits {
+	value := func(a, b int) int64 {
+		if b > 0 {
+			return int64(b)
+		}
+		return int64(a)
+	}
+
+	return proxy.Limits{
+		MaxHeaderSize: value(server.Limits.MaxHeaderSize, target.Limits.MaxHeaderSize) << 10,
+		MaxBodySize:   value(server.Limits.MaxBodySize, target.Limits.MaxBodySize) << 10,
+		MinUploadRate: value(server.Limits.MinUploadRate, target.Limits.MinUploadRate),
+		UploadTimeout: time.Duration(value(server.Limits.UploadTimeout, target.Limits.UploadTimeout)) * time.Second,
+		MaxIncomplete: server.Limits.MaxIncomplete,
+	}
+}
+
+// newProxyTermination returns the certificate of the server when it terminates the TLS connections.
+func (s *Proxy) newProxyTermination(server *config.ProxyServer) *proxy.Termination {
+	if !server.TLS || !server.Termination.Enable {
+		return nil
+	}
+
+	return &proxy.Termination{
+		CertFile:          server.Termination.CertFile,
+		KeyFile:           server.Termination.KeyFile,
+		ClientCaFile:      server.Termination.ClientCaFile,
+		CrlFile:           server.Termination.CrlFile,
+		RequireClientCert: server.Termination.RequireClientCert,
+	}
+}
+
+// newProxyClientCert returns the client certificate requirement of the target
+// when the server terminates the TLS connections.
+func (s *Proxy) newProxyClientCert(server *config.ProxyServer, target *config.ProxyTarget) *proxy.ClientCert {
+	if !server.TLS || !server.Termination.Enable {
+		return nil
+	}
+	cert := &target.ClientCert
+	if !cert.Require && !cert.Headers && !cert.Tlv && len(cert.Subjects) < 1 && len(cert.Sans) < 1 {
+		return nil
+	}
+
+	return &proxy.ClientCert{
+		Require:  cert.Require,
+		Subjects: cert.Subjects,
+		Sans:     cert.Sans,
+		Headers:  cert.Headers,
+		Tlv:      cert.Tlv && target.Version == 2,
+	}
+}
+
+// newProxySocket returns the file settings of the unix domain socket the server listens on.
+func (s *Proxy) newProxySocket(server *config.ProxyServer) *proxy.Socket {
+	if !config.IsUnixAddress(server.IP) || server.Socket.IsEmpty() {
+		return nil
+	}
+
+	socket := &proxy.Socket{Uid: -1, Gid: -1}
+	if len(server.Socket.Mode) > 0 {
+		mode, err := strconv.ParseUint(server.Socket.Mode, 8, 32)
+		if err == nil {
+			socket.Mode = os.FileMode(mode)
+		}
+	}
+	if len(server.Socket.User) > 0 {
+		uid, err := lookupUid(server.Socket.User)
+		if err != nil {
+			s.LogError("proxy socket user '", server.Socket.User, "' invalid: ", err)
+		} else {
+			socket.Uid = uid
+		}
+	}
+	if len(server.Socket.Group) > 0 {
+		gid, err := lookupGid(server.Socket.Group)
+		if err != nil {
+			s.LogError("proxy socket group '", server.Socket.Group, "' invalid: ", err)
+		} else {
+			socket.Gid = gid
+		}
+	}
+
+	return socket
+}
+
+// lookupUid returns the id of the user given by name or id.
+func lookupUid(name string) (int, error) {
+	id, err := strconv.Atoi(name)
+	if err == nil {
+		return id, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGid returns the id of the group given by name or id.
+func lookupGid(name string) (int, error) {
+	id, err := strconv.Atoi(name)
+	if err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(g.Gid)
+}
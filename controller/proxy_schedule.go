@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"github.com/csby/grps/config"
+	"github.com/csby/grps/proxy"
+	"time"
+)
+
+// runSchedules checks the schedules of the servers and targets at the start of
+// every minute, which the times of the schedules are in, until it is stopped.
+func (s *Proxy) runSchedules(stop <-chan struct{}) {
+	s.mutex.Lock()
+	s.scheduled = s.scheduleStates(time.Now())
+	s.mutex.Unlock()
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now) + 10*time.Millisecond)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.checkSchedules(time.Now())
+	}
+}
+
+// scheduleStates returns whether the time is within the schedules by the id
+// of the servers and targets.
+func (s *Proxy) scheduleStates(now time.Time) map[string]bool {
+	states := make(map[string]bool)
+	if s.cfg == nil {
+		return states
+	}
+
+	servers := s.cfg.ReverseProxy.Servers
+	for i := 0; i < len(servers); i++ {
+		server := servers[i]
+		if server == nil {
+			continue
+		}
+		states[server.Id] = server.Schedule.Active(now)
+		for j := 0; j < len(server.Targets); j++ {
+			target := server.Targets[j]
+			if target != nil {
+				states[target.Id] = target.Schedule.Active(now)
+			}
+		}
+	}
+
+	return states
+}
+
+// checkSchedules rebuilds the routes and reloads them into the running service
+// when the effective state of a server or target is changed by its schedule.
+func (s *Proxy) checkSchedules(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	states := s.scheduleStates(now)
+	changed := func(id string) bool {
+		active, ok := s.scheduled[id]
+		return ok && active != states[id]
+	}
+
+	servers := make([]*config.ProxyServerEdit, 0)
+	targets := make([]*config.ProxyTargetEdit, 0)
+	count := len(s.cfg.ReverseProxy.Servers)
+	for i := 0; i < count; i++ {
+		server := s.cfg.ReverseProxy.Servers[i]
+		if server == nil || server.Disable {
+			continue
+		}
+		if changed(server.Id) {
+			item := &config.ProxyServerEdit{}
+			item.CopyFrom(server)
+			servers = append(servers, item)
+		}
+		if !server.IsEnabled(now) {
+			continue
+		}
+		for j := 0; j < len(server.Targets); j++ {
+			target := server.Targets[j]
+			if target == nil || target.Disable || !changed(target.Id) {
+				continue
+			}
+			item := &config.ProxyTargetEdit{ServerId: server.Id}
+			item.Target = *target
+			targets = append(targets, item)
+		}
+	}
+	s.scheduled = states
+	if len(servers) < 1 && len(targets) < 1 {
+		return
+	}
+
+	s.LogInfo("proxy schedule changed: ", len(servers), " server(s), ", len(targets), " target(s)")
+	err := s.reloadRoutes()
+	if err != nil {
+		s.LogError("proxy service reload for schedule fail: ", err)
+	}
+
+	for i := 0; i < len(servers); i++ {
+		s.writeWebSocketMessage(WSReviseProxyServerMod, servers[i])
+	}
+	for i := 0; i < len(targets); i++ {
+		s.writeWebSocketMessage(WSReviseProxyTargetMod, targets[i])
+	}
+}
+
+// applyRoutes reloads the routes into the running service, which is stopped
+// when there is no route left and started again once there are routes.
+func (s *Proxy) applyRoutes() error {
+	count := len(s.proxyServer.Routes)
+	if s.proxyServer.Result().Status == proxy.StatusRunning {
+		if count > 0 {
+			return s.proxyServer.Reload()
+		}
+		s.scheduleIdle = true
+		return s.proxyServer.Stop()
+	}
+	if s.scheduleIdle && count > 0 {
+		s.scheduleIdle = false
+		return s.proxyServer.Start()
+	}
+
+	return nil
+}
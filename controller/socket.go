@@ -4,6 +4,8 @@ const (
 	WSReviseProxyServiceStatus  = 1001 // 反向代理服务状态信息
 	WSReviseProxyConnectionOpen = 1002 // 反向代理连接已打开
 	WSReviseProxyConnectionShut = 1003 // 反向代理连接已关闭
+	WSReviseProxyBackendEject   = 1004 // 反向代理后端已隔离
+	WSReviseProxyBackendRestore = 1005 // 反向代理后端已恢复
 
 	WSReviseProxyServerAdd = 1011 // 反向代理添加服务器
 	WSReviseProxyServerDel = 1012 // 反向代理删除服务器
@@ -0,0 +1,10 @@
+module github.com/csby/grps
+
+go 1.24.0
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/golang-jwt/jwt/v5 v5.3.1
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.45.0
+)
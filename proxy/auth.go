@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AuthBasic   = "basic"
+	AuthForward = "forward"
+
+	authUserHeader     = "X-Forwarded-User"
+	defaultAuthTimeout = 10 * time.Second
+	maxAuthBodySize    = 64 << 10
+)
+
+// Auth holds the authentication of a route: the basic one checks the users
+// with the bcrypt hashes of their passwords, and the forward one asks the auth
+// service by a subrequest carrying the headers of the request (all when
+// empty), the response headers of an allowed subrequest are copied to the request.
+type Auth struct {
+	Type            string
+	Realm           string
+	Users           map[string]string
+	Url             string
+	RequestHeaders  []string
+	ResponseHeaders []string
+	Timeout         time.Duration
+}
+
+type authenticator struct {
+	*Auth
+
+	server   *Server
+	client   *http.Client
+	mutex    sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+func newAuthenticator(server *Server, auth *Auth) *authenticator {
+	instance := &authenticator{
+		Auth:     auth,
+		server:   server,
+		verified: make(map[string][sha256.Size]byte),
+	}
+	if auth.Type == AuthForward {
+		timeout := auth.Timeout
+		if timeout <= 0 {
+			timeout = defaultAuthTimeout
+		}
+		instance.client = &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// the redirection to a login page is for the client
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	return instance
+}
+
+// serve authenticates the request, which returns false when the response has
+// been written since the request is not allowed.
+func (s *authenticator) serve(w http.ResponseWriter, r *http.Request, sn *session, pages map[int]*ErrorPage) bool {
+	switch s.Type {
+	case AuthBasic:
+		name, ok := s.basic(r)
+		if !ok {
+			realm := s.Realm
+			if len(realm) < 1 {
+				realm = "grps"
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+			s.fail(w, r, sn, pages, http.StatusUnauthorized)
+			return false
+		}
+		// the password is not for the target
+		r.Header.Del("Authorization")
+		r.Header.Set(authUserHeader, name)
+	case AuthForward:
+		return s.forward(w, r, sn, pages)
+	}
+
+	return true
+}
+
+// basic returns the name of the user when the password matches, the digest of
+// the verified password is kept so that bcrypt is not run on each request.
+func (s *authenticator) basic(r *http.Request) (string, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return name, false
+	}
+	hash, ok := s.Users[name]
+	if !ok {
+		return name, false
+	}
+
+	digest := sha256.Sum256([]byte(name + ":" + password))
+	s.mutex.Lock()
+	verified, ok := s.verified[name]
+	s.mutex.Unlock()
+	if ok && subtle.ConstantTimeCompare(verified[:], digest[:]) == 1 {
+		return name, true
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return name, false
+	}
+	s.mutex.Lock()
+	s.verified[name] = digest
+	s.mutex.Unlock()
+
+	return name, true
+}
+
+func (s *authenticator) forward(w http.ResponseWriter, r *http.Request, sn *session, pages map[int]*ErrorPage) bool {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, s.Url, nil)
+	if err != nil {
+		s.server.LogError("proxy auth request ", sn.id, " '", s.Url, "' fail: ", err)
+		s.fail(w, r, sn, pages, http.StatusInternalServerError)
+		return false
+	}
+
+	if len(s.RequestHeaders) > 0 {
+		for i := 0; i < len(s.RequestHeaders); i++ {
+			name := s.RequestHeaders[i]
+			values := r.Header.Values(name)
+			for j := 0; j < len(values); j++ {
+				req.Header.Add(name, values[j])
+			}
+		}
+	} else {
+		for name, values := range r.Header {
+			if isHopHeader(name) || name == "Content-Length" {
+				continue
+			}
+			req.Header[name] = append([]string(nil), values...)
+		}
+	}
+	clientIp, _, e := net.SplitHostPort(r.RemoteAddr)
+	if e != nil {
+		clientIp = r.RemoteAddr
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", requestScheme(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-For", clientIp)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.server.LogError("proxy auth request ", sn.id, " '", s.Url, "' fail: ", err)
+		s.fail(w, r, sn, pages, errorCode(err))
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		// the identity headers are always taken from the auth service
+		for i := 0; i < len(s.ResponseHeaders); i++ {
+			name := s.ResponseHeaders[i]
+			r.Header.Del(name)
+			values := resp.Header.Values(name)
+			for j := 0; j < len(values); j++ {
+				r.Header.Add(name, values[j])
+			}
+		}
+		return true
+	}
+
+	// the denial of the auth service is returned as it is, which may ask the
+	// client to log in or redirect it to the login page
+	header := w.Header()
+	for name, values := range resp.Header {
+		if isHopHeader(name) {
+			continue
+		}
+		header[name] = values
+	}
+	sn.decorate(header)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.LimitReader(resp.Body, maxAuthBodySize))
+
+	return false
+}
+
+func (s *authenticator) fail(w http.ResponseWriter, r *http.Request, sn *session, pages map[int]*ErrorPage, code int) {
+	sn.decorate(w.Header())
+	err := serveErrorPage(w, pages, code, sn.id)
+	if err != nil {
+		s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+	}
+}
+
+func isHopHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "connection", "proxy-connection", "keep-alive", "proxy-authenticate", "proxy-authorization",
+		"te", "trailer", "transfer-encoding", "upgrade":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestForwardAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-User")+";"+r.Header.Get("X-Other"))
+	}))
+	defer backend.Close()
+
+	// the auth service answers by the cookie, and keeps the subrequest headers
+	var mutex sync.Mutex
+	subrequests := make(map[string]http.Header)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		subrequests[r.Header.Get("X-Forwarded-Uri")] = r.Header.Clone()
+		mutex.Unlock()
+		switch r.Header.Get("Cookie") {
+		case "session=alice":
+			w.Header().Set("X-User", "alice")
+			w.Header().Set("X-Unlisted", "1")
+		case "":
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+		default:
+			w.Header().Set("X-Auth-Reason", "expired")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "denied")
+		}
+	}))
+	defer auth.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	downUrl := down.URL
+	down.Close()
+
+	addr := freeAddr(t)
+	target := backend.Listener.Addr().String()
+	server := &Server{Routes: []Route{
+		{
+			Address: addr,
+			Path:    "/all/",
+			Target:  target,
+			Auth:    &Auth{Type: AuthForward, Url: auth.URL + "/verify", ResponseHeaders: []string{"X-User"}},
+		},
+		{
+			Address: addr,
+			Path:    "/cookie/",
+			Target:  target,
+			Auth: &Auth{Type: AuthForward, Url: auth.URL + "/verify",
+				RequestHeaders: []string{"Cookie"}, ResponseHeaders: []string{"X-User"}},
+		},
+		{
+			Address: addr,
+			Path:    "/down/",
+			Target:  target,
+			Auth:    &Auth{Type: AuthForward, Url: downUrl + "/verify"},
+		},
+	}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	items := []struct {
+		name   string
+		path   string
+		cookie string
+		code   int
+		body   string
+		header string
+		value  string
+	}{
+		// the identity header of the client is replaced with the one of the auth service
+		{"allow", "/all/page?a=1", "session=alice", http.StatusOK, "alice;other", "", ""},
+		{"deny", "/all/page", "session=bob", http.StatusForbidden, "denied", "X-Auth-Reason", "expired"},
+		{"login", "/all/page", "", http.StatusFound, "", "Location", "https://login.example.com/"},
+		{"listed headers", "/cookie/page", "session=alice", http.StatusOK, "alice;other", "", ""},
+		{"unreachable", "/down/page", "session=alice", http.StatusBadGateway, "", "", ""},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+item.path, nil)
+		req.Header.Set("X-User", "mallory")
+		req.Header.Set("X-Other", "other")
+		if len(item.cookie) > 0 {
+			req.Header.Set("Cookie", item.cookie)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(item.name, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.code {
+			t.Fatalf("%s: status %d, want %d", item.name, resp.StatusCode, item.code)
+		}
+		if len(item.body) > 0 && string(data) != item.body {
+			t.Fatalf("%s: body %q, want %q", item.name, data, item.body)
+		}
+		if len(item.header) > 0 && resp.Header.Get(item.header) != item.value {
+			t.Fatalf("%s: header %s %q, want %q", item.name, item.header, resp.Header.Get(item.header), item.value)
+		}
+		if resp.Header.Get("X-Unlisted") != "" {
+			t.Fatalf("%s: the unlisted auth header reaches the client", item.name)
+		}
+	}
+
+	// the subrequest carries the request headers, all of them or the listed ones
+	mutex.Lock()
+	defer mutex.Unlock()
+	subrequest := subrequests["/all/page?a=1"]
+	if subrequest.Get("Cookie") != "session=alice" || subrequest.Get("X-Other") != "other" {
+		t.Fatalf("the headers of the request are not in the subrequest: %v", subrequest)
+	}
+	subrequest = subrequests["/cookie/page"]
+	if subrequest.Get("Cookie") != "session=alice" || subrequest.Get("X-Other") != "" {
+		t.Fatalf("the listed headers are not the only ones of the subrequest: %v", subrequest)
+	}
+	if subrequest.Get("X-Forwarded-Method") != http.MethodGet || subrequest.Get("X-Forwarded-Uri") != "/cookie/page" ||
+		subrequest.Get("X-Forwarded-Host") != addr {
+		t.Fatalf("the original request is not described to the auth service: %v", subrequest)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(authUserHeader)+";"+r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]string{"alice": string(hash)}
+
+	addr := freeAddr(t)
+	target := backend.Listener.Addr().String()
+	server := &Server{Routes: []Route{
+		{
+			Address: addr,
+			Path:    "/team/",
+			Target:  target,
+			Auth:    &Auth{Type: AuthBasic, Realm: "team", Users: users},
+		},
+		{
+			Address: addr,
+			Path:    "/default/",
+			Target:  target,
+			Auth:    &Auth{Type: AuthBasic, Users: users},
+		},
+	}}
+	err = server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	items := []struct {
+		name      string
+		path      string
+		user      string
+		password  string
+		code      int
+		body      string
+		challenge string
+	}{
+		// the password is not passed to the target, only the name of the user
+		{"accept", "/team/page", "alice", "secret", http.StatusOK, "alice;", ""},
+		{"accept again", "/team/page", "alice", "secret", http.StatusOK, "alice;", ""},
+		{"wrong password", "/team/page", "alice", "guess", http.StatusUnauthorized, "", `Basic realm="team", charset="UTF-8"`},
+		{"unknown user", "/team/page", "bob", "secret", http.StatusUnauthorized, "", `Basic realm="team", charset="UTF-8"`},
+		{"no credentials", "/team/page", "", "", http.StatusUnauthorized, "", `Basic realm="team", charset="UTF-8"`},
+		{"default realm", "/default/page", "", "", http.StatusUnauthorized, "", `Basic realm="grps", charset="UTF-8"`},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+item.path, nil)
+		req.Header.Set(authUserHeader, "mallory")
+		if len(item.user) > 0 {
+			req.SetBasicAuth(item.user, item.password)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(item.name, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.code {
+			t.Fatalf("%s: status %d, want %d", item.name, resp.StatusCode, item.code)
+		}
+		if len(item.body) > 0 && string(data) != item.body {
+			t.Fatalf("%s: body %q, want %q", item.name, data, item.body)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); got != item.challenge {
+			t.Fatalf("%s: WWW-Authenticate %q, want %q", item.name, got, item.challenge)
+		}
+	}
+}
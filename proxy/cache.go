@@ -0,0 +1,608 @@
+package proxy
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize      = 64 << 20
+	defaultCacheDiskSize  = 1 << 30
+	defaultCacheEntrySize = 10 << 20
+
+	cacheHeader = "X-Cache"
+
+	CacheHit         = "HIT"
+	CacheMiss        = "MISS"
+	CacheStale       = "STALE"
+	CacheRevalidated = "REVALIDATED"
+)
+
+// Cache holds the response cache of a route, the bodies are kept in memory and
+// moved to the folder when the memory is full; zero sizes mean the defaults.
+// The responses without freshness information are kept for the ttl, and the
+// stale ones are served within the stale times unless the target tells otherwise.
+type Cache struct {
+	Id                   string
+	Size                 int64
+	DiskSize             int64
+	EntrySize            int64
+	Folder               string
+	KeyHeaders           []string
+	IgnoreQuery          bool
+	Ttl                  time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+func (s *Cache) size() int64 {
+	if s.Size > 0 {
+		return s.Size
+	}
+
+	return defaultCacheSize
+}
+
+func (s *Cache) diskSize() int64 {
+	if s.DiskSize > 0 {
+		return s.DiskSize
+	}
+
+	return defaultCacheDiskSize
+}
+
+func (s *Cache) entrySize() int64 {
+	if s.EntrySize > 0 {
+		return s.EntrySize
+	}
+
+	return defaultCacheEntrySize
+}
+
+type CacheStat struct {
+	Id            string `json:"id" note:"目标标识ID"`
+	ListenAddr    string `json:"listenAddr" note:"监听地址"`
+	Domain        string `json:"domain" note:"域名"`
+	Path          string `json:"path" note:"路径"`
+	Entries       int    `json:"entries" note:"缓存的响应数"`
+	MemorySize    int64  `json:"memorySize" note:"内存占用(字节)"`
+	DiskSize      int64  `json:"diskSize" note:"磁盘占用(字节)"`
+	Hits          uint64 `json:"hits" note:"命中次数"`
+	Misses        uint64 `json:"misses" note:"未命中次数"`
+	Stales        uint64 `json:"stales" note:"使用过期响应的次数"`
+	Revalidations uint64 `json:"revalidations" note:"经目标确认未修改的次数"`
+}
+
+type CacheFilter struct {
+	Id     string `json:"id" note:"目标标识ID，空表示全部"`
+	Domain string `json:"domain" note:"域名，空表示全部"`
+	Prefix string `json:"prefix" note:"URL路径前缀(如: /static/)，空表示全部"`
+}
+
+type CachePurge struct {
+	Count int `json:"count" note:"清除的响应数"`
+}
+
+type cacheEntry struct {
+	key    string
+	base   string
+	host   string
+	uri    string
+	status int
+	header http.Header
+	body   []byte
+	file   string
+	size   int64
+
+	stored          time.Time
+	initialAge      time.Duration
+	fresh           time.Duration
+	staleRevalidate time.Duration
+	staleError      time.Duration
+	etag            string
+	lastModified    string
+
+	element *list.Element
+}
+
+func (s *cacheEntry) age(now time.Time) time.Duration {
+	return s.initialAge + now.Sub(s.stored)
+}
+
+func (s *cacheEntry) validators() bool {
+	return len(s.etag) > 0 || len(s.lastModified) > 0
+}
+
+// cacheStore keeps the responses of a route in the order of use, the least
+// recently used ones are moved to the disk and then removed when it is full.
+type cacheStore struct {
+	sync.Mutex
+
+	cache   *Cache
+	route   *Route
+	folder  string
+	entries map[string]*cacheEntry
+	vary    map[string][]string
+	lru     *list.List
+	memory  int64
+	disk    int64
+	files   uint64
+	pending map[string]bool
+
+	hits          uint64
+	misses        uint64
+	stales        uint64
+	revalidations uint64
+}
+
+// cacheFolders holds the folders cleared by the process, the files left by the
+// last run are removed once since the entries are not kept between runs.
+var cacheFolders = struct {
+	sync.Mutex
+
+	cleared map[string]bool
+	next    uint64
+}{cleared: make(map[string]bool)}
+
+// newCacheStore creates the store of the route, which keeps its files in a
+// folder of its own so that a store replaced by a reload never shares them.
+func newCacheStore(route *Route) (*cacheStore, error) {
+	instance := &cacheStore{
+		cache:   route.Cache,
+		route:   route,
+		entries: make(map[string]*cacheEntry),
+		vary:    make(map[string][]string),
+		lru:     list.New(),
+		pending: make(map[string]bool),
+	}
+
+	if len(route.Cache.Folder) > 0 {
+		name := route.Cache.Id
+		if len(name) < 1 {
+			name = "default"
+		}
+		parent := filepath.Join(route.Cache.Folder, filepath.Base(name))
+
+		cacheFolders.Lock()
+		var err error = nil
+		if !cacheFolders.cleared[parent] {
+			err = os.RemoveAll(parent)
+			cacheFolders.cleared[parent] = err == nil
+		}
+		cacheFolders.next++
+		folder := filepath.Join(parent, strconv.FormatUint(cacheFolders.next, 10))
+		cacheFolders.Unlock()
+		if err != nil {
+			return instance, err
+		}
+
+		err = os.MkdirAll(folder, 0700)
+		if err != nil {
+			return instance, err
+		}
+		instance.folder = folder
+	}
+
+	return instance, nil
+}
+
+// reuse hands the store over to the route of the same cache settings.
+func (s *cacheStore) reuse(route *Route) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.route = route
+}
+
+// close removes the files of the store, the entries of them are not served
+// any more and the ones added later are kept in memory only.
+func (s *cacheStore) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.folder) < 1 {
+		return
+	}
+	os.RemoveAll(s.folder)
+	s.folder = ""
+}
+
+func (s *cacheStore) baseKey(r *http.Request) string {
+	key := &strings.Builder{}
+	key.WriteString(strings.ToLower(hostName(r.Host)))
+	key.WriteString(r.URL.Path)
+	if !s.cache.IgnoreQuery && len(r.URL.RawQuery) > 0 {
+		key.WriteString("?")
+		key.WriteString(r.URL.RawQuery)
+	}
+
+	count := len(s.cache.KeyHeaders)
+	for i := 0; i < count; i++ {
+		name := s.cache.KeyHeaders[i]
+		fmt.Fprintf(key, "\n%s:%s", strings.ToLower(name), strings.Join(r.Header.Values(name), ","))
+	}
+
+	return key.String()
+}
+
+func varyKey(base string, names []string, header http.Header) string {
+	key := &strings.Builder{}
+	key.WriteString(base)
+
+	count := len(names)
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(key, "\n%s=%s", names[i], strings.Join(header.Values(names[i]), ","))
+	}
+
+	return key.String()
+}
+
+// lookup returns a copy of the entry for the request, or nil when not cached.
+func (s *cacheStore) lookup(r *http.Request) *cacheEntry {
+	s.Lock()
+	defer s.Unlock()
+
+	base := s.baseKey(r)
+	entry, ok := s.entries[varyKey(base, s.vary[base], r.Header)]
+	if !ok {
+		return nil
+	}
+	s.lru.MoveToFront(entry.element)
+
+	snapshot := *entry
+	return &snapshot
+}
+
+// put adds the response to the store, replacing the one of the same key.
+func (s *cacheStore) put(r *http.Request, entry *cacheEntry, body []byte) {
+	names := make([]string, 0)
+	values := entry.header.Values("Vary")
+	for i := 0; i < len(values); i++ {
+		fields := strings.Split(values[i], ",")
+		for j := 0; j < len(fields); j++ {
+			name := strings.ToLower(strings.TrimSpace(fields[j]))
+			if len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	entry.base = s.baseKey(r)
+	entry.key = varyKey(entry.base, names, r.Header)
+	entry.host = strings.ToLower(hostName(r.Host))
+	entry.uri = r.URL.RequestURI()
+	entry.body = body
+	entry.size = int64(len(body))
+	if !equalNames(s.vary[entry.base], names) {
+		// the responses varying by the other headers are not reachable any more
+		s.removeBase(entry.base)
+		s.vary[entry.base] = names
+	}
+	prior, ok := s.entries[entry.key]
+	if ok {
+		s.remove(prior)
+	}
+
+	s.entries[entry.key] = entry
+	entry.element = s.lru.PushFront(entry)
+	s.memory += entry.size
+	if entry.size > s.cache.size() {
+		s.demote(entry)
+	}
+	s.evict()
+}
+
+// refresh updates the entry revalidated by the target with the freshness of
+// the not modified response, the body is kept as it is.
+func (s *cacheStore) refresh(snapshot *cacheEntry, fresh *cacheEntry) *cacheEntry {
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot.header = fresh.header
+	snapshot.stored = fresh.stored
+	snapshot.initialAge = fresh.initialAge
+	snapshot.fresh = fresh.fresh
+	snapshot.staleRevalidate = fresh.staleRevalidate
+	snapshot.staleError = fresh.staleError
+
+	entry, ok := s.entries[snapshot.key]
+	if ok && entry.element == snapshot.element {
+		entry.header = snapshot.header
+		entry.stored = snapshot.stored
+		entry.initialAge = snapshot.initialAge
+		entry.fresh = snapshot.fresh
+		entry.staleRevalidate = snapshot.staleRevalidate
+		entry.staleError = snapshot.staleError
+	}
+
+	return snapshot
+}
+
+// evict moves the least recently used entries to the disk while the memory is
+// full, and removes them while the disk is full.
+func (s *cacheStore) evict() {
+	memory := s.cache.size()
+	disk := s.cache.diskSize()
+	element := s.lru.Back()
+	for element != nil && (s.memory > memory || s.disk > disk) {
+		prev := element.Prev()
+		entry := element.Value.(*cacheEntry)
+		if len(entry.file) < 1 {
+			if s.memory > memory {
+				s.demote(entry)
+			}
+		} else if s.disk > disk {
+			s.remove(entry)
+		}
+		element = prev
+	}
+}
+
+// demote writes the body of the entry to the disk, the entry is removed when
+// there is no folder or the body is larger than the disk.
+func (s *cacheStore) demote(entry *cacheEntry) {
+	if len(s.folder) < 1 || entry.size > s.cache.diskSize() {
+		s.remove(entry)
+		return
+	}
+
+	s.files++
+	file := filepath.Join(s.folder, strconv.FormatUint(s.files, 10))
+	err := os.WriteFile(file, entry.body, 0600)
+	if err != nil {
+		os.Remove(file)
+		s.remove(entry)
+		return
+	}
+
+	s.memory -= entry.size
+	s.disk += entry.size
+	entry.body = nil
+	entry.file = file
+}
+
+func (s *cacheStore) remove(entry *cacheEntry) {
+	delete(s.entries, entry.key)
+	s.lru.Remove(entry.element)
+	if len(entry.file) > 0 {
+		s.disk -= entry.size
+		os.Remove(entry.file)
+	} else {
+		s.memory -= entry.size
+	}
+}
+
+func (s *cacheStore) removeBase(base string) {
+	element := s.lru.Front()
+	for element != nil {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		if entry.base == base {
+			s.remove(entry)
+		}
+		element = next
+	}
+}
+
+func (s *cacheStore) purge(filter *CacheFilter) int {
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	element := s.lru.Front()
+	for element != nil {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		if filter == nil || filter.match(entry) {
+			s.remove(entry)
+			count++
+		}
+		element = next
+	}
+
+	return count
+}
+
+// begin marks the entry being revalidated, which returns false when it is already.
+func (s *cacheStore) begin(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.pending[key] {
+		return false
+	}
+	s.pending[key] = true
+
+	return true
+}
+
+func (s *cacheStore) end(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.pending, key)
+}
+
+func (s *cacheStore) count(state string) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch state {
+	case CacheHit:
+		s.hits++
+	case CacheMiss:
+		s.misses++
+	case CacheStale:
+		s.stales++
+	case CacheRevalidated:
+		s.revalidations++
+	}
+}
+
+func (s *cacheStore) stat(address string) *CacheStat {
+	s.Lock()
+	defer s.Unlock()
+
+	return &CacheStat{
+		Id:            s.cache.Id,
+		ListenAddr:    address,
+		Domain:        s.route.Domain,
+		Path:          s.route.Path,
+		Entries:       len(s.entries),
+		MemorySize:    s.memory,
+		DiskSize:      s.disk,
+		Hits:          s.hits,
+		Misses:        s.misses,
+		Stales:        s.stales,
+		Revalidations: s.revalidations,
+	}
+}
+
+func (s *CacheFilter) match(entry *cacheEntry) bool {
+	if len(s.Domain) > 0 && !strings.EqualFold(s.Domain, entry.host) {
+		return false
+	}
+	if len(s.Prefix) > 0 && !strings.HasPrefix(entry.uri, s.Prefix) {
+		return false
+	}
+
+	return true
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newCacheEntry returns the entry of the response when it may be stored by a
+// shared cache (RFC 9111), or nil otherwise. The response of an authenticated
+// request is stored only when the target explicitly allows a shared cache to
+// (RFC 9111 section 3.5), as it would be served to the other users.
+func newCacheEntry(cache *Cache, status int, header http.Header, requested, received time.Time, authenticated bool) *cacheEntry {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+
+	directives := cacheControl(header)
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if noStore || private || len(header.Values("Set-Cookie")) > 0 || header.Get("Vary") == "*" {
+		return nil
+	}
+	if authenticated {
+		_, public := directives["public"]
+		_, sharedMaxAge := directives["s-maxage"]
+		_, mustRevalidate := directives["must-revalidate"]
+		if !public && !sharedMaxAge && !mustRevalidate {
+			return nil
+		}
+	}
+
+	entry := &cacheEntry{
+		status:          status,
+		header:          header,
+		stored:          received,
+		staleRevalidate: cache.StaleWhileRevalidate,
+		staleError:      cache.StaleIfError,
+		etag:            header.Get("ETag"),
+		lastModified:    header.Get("Last-Modified"),
+	}
+
+	// the age is corrected by the time spent on the way (RFC 9111 section 4.2.3)
+	age, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err == nil && age > 0 {
+		entry.initialAge = time.Duration(age) * time.Second
+	}
+	entry.initialAge += received.Sub(requested)
+
+	explicit := true
+	if value, ok := directives["s-maxage"]; ok {
+		entry.fresh = seconds(value)
+	} else if value, ok := directives["max-age"]; ok {
+		entry.fresh = seconds(value)
+	} else if expires := header.Get("Expires"); len(expires) > 0 {
+		expiresTime, err := http.ParseTime(expires)
+		date, e := http.ParseTime(header.Get("Date"))
+		if e != nil {
+			date = received
+		}
+		if err == nil && expiresTime.After(date) {
+			entry.fresh = expiresTime.Sub(date)
+		}
+	} else {
+		explicit = false
+		entry.fresh = cache.Ttl
+	}
+	if _, ok := directives["no-cache"]; ok {
+		entry.fresh = 0
+	}
+	if !explicit && entry.fresh <= 0 && !entry.validators() {
+		return nil
+	}
+
+	if value, ok := directives["stale-while-revalidate"]; ok {
+		entry.staleRevalidate = seconds(value)
+	}
+	if value, ok := directives["stale-if-error"]; ok {
+		entry.staleError = seconds(value)
+	}
+	_, mustRevalidate := directives["must-revalidate"]
+	_, proxyRevalidate := directives["proxy-revalidate"]
+	if mustRevalidate || proxyRevalidate {
+		entry.staleRevalidate = 0
+		entry.staleError = 0
+	}
+
+	return entry
+}
+
+// cacheControl returns the directives of the Cache-Control header with the
+// names in lower case, and the no-cache of the Pragma header.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	values := header.Values("Cache-Control")
+	for i := 0; i < len(values); i++ {
+		fields := strings.Split(values[i], ",")
+		for j := 0; j < len(fields); j++ {
+			name, value, _ := strings.Cut(strings.TrimSpace(fields[j]), "=")
+			if len(name) > 0 {
+				directives[strings.ToLower(name)] = strings.Trim(value, "\"")
+			}
+		}
+	}
+	if len(values) < 1 && strings.EqualFold(header.Get("Pragma"), "no-cache") {
+		directives["no-cache"] = ""
+	}
+
+	return directives
+}
+
+func seconds(value string) time.Duration {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Second
+}
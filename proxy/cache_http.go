@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+const (
+	cacheModePass = iota
+	cacheModeNotModified
+	cacheModeStale
+
+	revalidateTimeout = 60 * time.Second
+)
+
+// forward proxies the request to the target, the cacheable requests are
+// answered from the cache when the stored response is fresh enough.
+func (s *httpRoute) forward(w http.ResponseWriter, r *http.Request) {
+	sn := sessionFromContext(r.Context())
+	if s.cache == nil || sn == nil || sn.upgrade || !cacheable(r) {
+		s.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	entry := s.cache.lookup(r)
+	if entry != nil {
+		directives := cacheControl(r.Header)
+		_, noCache := directives["no-cache"]
+		age := entry.age(time.Now())
+		if value, ok := directives["max-age"]; ok && age > seconds(value) {
+			noCache = true
+		}
+		if !noCache && age < entry.fresh {
+			if s.serveEntry(w, r, entry, CacheHit, sn) {
+				return
+			}
+			entry = nil
+		} else if !noCache && age < entry.fresh+entry.staleRevalidate {
+			out := r.Clone(context.Background())
+			if entry.validators() {
+				out = conditionalRequest(r, context.Background(), entry)
+			}
+			if s.serveEntry(w, r, entry, CacheStale, sn) {
+				go s.revalidate(out, entry, sn.clone())
+				return
+			}
+			entry = nil
+		}
+	}
+
+	out := r
+	cw := &cacheWriter{
+		ResponseWriter: w,
+		route:          s,
+		header:         make(http.Header),
+		requested:      time.Now(),
+	}
+	if entry != nil {
+		if entry.age(time.Now()) < entry.fresh+entry.staleError {
+			cw.stale = entry
+		}
+		if entry.validators() {
+			cw.validating = entry
+			out = conditionalRequest(r, r.Context(), entry)
+		}
+	}
+	s.proxy.ServeHTTP(cw, out)
+	cw.finish(r, sn)
+}
+
+// revalidate refreshes the stale entry in the background with a session of its
+// own, as the one of the client request is closed once the stale one is served.
+func (s *httpRoute) revalidate(r *http.Request, entry *cacheEntry, background *session) {
+	if !s.cache.begin(entry.key) {
+		return
+	}
+	defer s.cache.end(entry.key)
+
+	ctx := context.WithValue(context.Background(), sessionKey{}, background)
+	ctx, cancel := context.WithTimeout(ctx, revalidateTimeout)
+	defer cancel()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: background.gotConn,
+	})
+
+	out := r.WithContext(ctx)
+	cw := &cacheWriter{
+		ResponseWriter: &discardWriter{header: make(http.Header)},
+		route:          s,
+		header:         make(http.Header),
+		requested:      time.Now(),
+		validating:     entry,
+		background:     true,
+	}
+	s.proxy.ServeHTTP(cw, out)
+	cw.finish(out, background)
+	background.close()
+}
+
+// serveEntry writes the stored response, which returns false when the body is
+// not available any more since the entry has been removed from the disk.
+func (s *httpRoute) serveEntry(w http.ResponseWriter, r *http.Request, entry *cacheEntry, state string, sn *session) bool {
+	var body io.Reader = nil
+	if len(entry.file) > 0 {
+		file, err := os.Open(entry.file)
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+		body = file
+	}
+	s.cache.count(state)
+
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(entry.age(time.Now())/time.Second), 10))
+	header.Set(cacheHeader, state)
+	header.Set("Content-Length", strconv.FormatInt(entry.size, 10))
+	sn.decorate(header)
+
+	if entry.status == http.StatusOK && notModified(r, entry) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.WriteHeader(entry.status)
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	if body != nil {
+		io.Copy(w, body)
+	} else {
+		w.Write(entry.body)
+	}
+
+	return true
+}
+
+// cacheable reports whether the response of the request may be taken from the cache.
+func cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if len(r.Header.Get("Authorization")) > 0 || len(r.Header.Get("Range")) > 0 {
+		return false
+	}
+	_, noStore := cacheControl(r.Header)["no-store"]
+
+	return !noStore
+}
+
+// conditionalRequest returns the request validating the entry with its own
+// validators instead of the ones of the client.
+func conditionalRequest(r *http.Request, ctx context.Context, entry *cacheEntry) *http.Request {
+	out := r.Clone(ctx)
+	out.Header.Del("If-None-Match")
+	out.Header.Del("If-Modified-Since")
+	out.Header.Del("If-Match")
+	out.Header.Del("If-Unmodified-Since")
+	if len(entry.etag) > 0 {
+		out.Header.Set("If-None-Match", entry.etag)
+	}
+	if len(entry.lastModified) > 0 {
+		out.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+
+	return out
+}
+
+// notModified reports whether the client already has the stored response.
+func notModified(r *http.Request, entry *cacheEntry) bool {
+	match := r.Header.Get("If-None-Match")
+	if len(match) > 0 {
+		return match == "*" || (len(entry.etag) > 0 && containsEtag(match, entry.etag))
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || len(entry.lastModified) < 1 {
+		return false
+	}
+	modified, err := http.ParseTime(entry.lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+func containsEtag(list, etag string) bool {
+	items := strings.Split(list, ",")
+	for i := 0; i < len(items); i++ {
+		if strings.TrimPrefix(strings.TrimSpace(items[i]), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheWriter decides on the response header whether the response is passed
+// to the client, or the stored one is used instead when the target answers
+// not modified or fails; the body of a passed response is recorded for storing.
+type cacheWriter struct {
+	http.ResponseWriter
+
+	route      *httpRoute
+	header     http.Header
+	requested  time.Time
+	received   time.Time
+	status     int
+	mode       int
+	decided    bool
+	record     bool
+	body       []byte
+	stale      *cacheEntry
+	validating *cacheEntry
+	background bool
+}
+
+func (s *cacheWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *cacheWriter) WriteHeader(code int) {
+	if s.decided || code < http.StatusOK {
+		// the interim responses are not forwarded as the header is not known yet
+		return
+	}
+	s.decided = true
+	s.status = code
+	s.received = time.Now()
+
+	if code == http.StatusNotModified && s.validating != nil {
+		s.mode = cacheModeNotModified
+		return
+	}
+	if code >= http.StatusInternalServerError && s.stale != nil {
+		s.mode = cacheModeStale
+		return
+	}
+
+	s.mode = cacheModePass
+	s.record = code != http.StatusNotModified
+	header := s.ResponseWriter.Header()
+	for name, values := range s.header {
+		header[name] = values
+	}
+	if !s.background {
+		header.Set(cacheHeader, CacheMiss)
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *cacheWriter) Write(data []byte) (int, error) {
+	if !s.decided {
+		s.WriteHeader(http.StatusOK)
+	}
+	if s.mode != cacheModePass {
+		return len(data), nil
+	}
+
+	if s.record {
+		if int64(len(s.body)+len(data)) > s.route.cache.cache.entrySize() {
+			s.record = false
+			s.body = nil
+		} else {
+			s.body = append(s.body, data...)
+		}
+	}
+
+	return s.ResponseWriter.Write(data)
+}
+
+func (s *cacheWriter) Flush() {
+	if s.mode != cacheModePass || !s.decided {
+		return
+	}
+
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *cacheWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// finish stores the passed response, or writes the stored one to the client.
+func (s *cacheWriter) finish(r *http.Request, sn *session) {
+	cache := s.route.cache
+	switch s.mode {
+	case cacheModeNotModified:
+		// the header of the not modified response updates the stored one
+		entry := s.validating
+		header := entry.header.Clone()
+		for name, values := range sn.header {
+			header[name] = values
+		}
+		fresh := newCacheEntry(cache.cache, entry.status, header, s.requested, s.received, s.route.auth != nil)
+		if fresh != nil {
+			entry = cache.refresh(entry, fresh)
+		}
+		if s.background {
+			cache.count(CacheRevalidated)
+		} else if !s.route.serveEntry(s.ResponseWriter, r, entry, CacheRevalidated, sn) {
+			s.fail(sn)
+		}
+	case cacheModeStale:
+		if !s.route.serveEntry(s.ResponseWriter, r, s.stale, CacheStale, sn) {
+			s.fail(sn)
+		}
+	default:
+		if !s.background {
+			cache.count(CacheMiss)
+		}
+		if !s.decided || !s.record || sn.header == nil || r.Method != http.MethodGet {
+			return
+		}
+		length := sn.header.Get("Content-Length")
+		if len(length) > 0 && length != strconv.Itoa(len(s.body)) {
+			return
+		}
+		entry := newCacheEntry(cache.cache, s.status, sn.header, s.requested, s.received, s.route.auth != nil)
+		if entry != nil {
+			cache.put(r, entry, s.body)
+		}
+	}
+}
+
+// fail writes the error held back for the stored response, which has been
+// removed from the disk in the meantime.
+func (s *cacheWriter) fail(sn *session) {
+	if s.background {
+		return
+	}
+
+	err := serveErrorPage(s.ResponseWriter, s.route.ErrorPages, http.StatusBadGateway, sn.id)
+	if err != nil {
+		s.route.server.LogError("proxy error page fail: ", err)
+	}
+}
+
+type discardWriter struct {
+	header http.Header
+}
+
+func (s *discardWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *discardWriter) WriteHeader(code int) {
+}
+
+func (s *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// clone returns a session of the same request for the requests sent on its behalf.
+func (s *session) clone() *session {
+	instance := &session{
+		origin:   s.origin,
+		server:   s.server,
+		route:    s.route,
+		link:     s.link,
+		replacer: s.replacer,
+	}
+	instance.link.Id = gtype.NewGuid()
+	instance.link.Time = gtype.DateTime(time.Now())
+	instance.link.TargetAddr = ""
+
+	return instance
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCacheKeptAcrossReload(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "%s-%d", r.URL.Path, calls.Add(1))
+	}))
+	defer backend.Close()
+
+	folder := t.TempDir()
+	addr := freeAddr(t)
+	route := Route{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Cache:   &Cache{Id: "c1", Folder: folder, Size: 1},
+	}
+	server := &Server{Routes: []Route{route}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+	if got := get("/a"); got != "/a-1" {
+		t.Fatalf("response %q, want %q", got, "/a-1")
+	}
+
+	// the route is changed but not its cache, which keeps the entry on the disk
+	route.Timeout.ResponseHeader = time.Minute
+	server.Routes = []Route{route}
+	err = server.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get("/a"); got != "/a-1" {
+		t.Fatalf("response %q after the reload, want the cached %q", got, "/a-1")
+	}
+	stats := server.CacheStats()
+	if len(stats) != 1 || stats[0].Hits != 1 || stats[0].Misses != 1 {
+		t.Fatalf("cache stats %+v, want the ones before the reload kept", stats)
+	}
+
+	// a new cache setting starts over with a store of its own
+	route.Cache = &Cache{Id: "c1", Folder: folder, Size: 2}
+	server.Routes = []Route{route}
+	err = server.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get("/b"); got != "/b-2" {
+		t.Fatalf("response %q, want %q", got, "/b-2")
+	}
+	if got := get("/a"); got != "/a-3" {
+		t.Fatalf("response %q of the new store, want %q", got, "/a-3")
+	}
+	items, _ := os.ReadDir(filepath.Join(folder, "c1"))
+	if len(items) != 1 {
+		t.Fatalf("%d store folder(s), want the one of the new store only", len(items))
+	}
+}
+
+func TestCacheStoresUseOwnFolders(t *testing.T) {
+	folder := t.TempDir()
+	left := filepath.Join(folder, "c2", "left")
+	os.MkdirAll(left, 0700)
+
+	// the files of the last run are removed by the first store only
+	route := &Route{Cache: &Cache{Id: "c2", Folder: folder}}
+	first, err := newCacheStore(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(left); !os.IsNotExist(err) {
+		t.Fatal("the files of the last run are kept")
+	}
+	entry := &cacheEntry{header: make(http.Header)}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	first.put(r, entry, []byte("first"))
+	first.Lock()
+	first.demote(entry)
+	file := entry.file
+	first.Unlock()
+
+	second, err := newCacheStore(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.folder == first.folder {
+		t.Fatalf("the stores share the folder %s", first.folder)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "first" {
+		t.Fatalf("the file of the first store is %q: %v", data, err)
+	}
+
+	closed := first.folder
+	first.close()
+	if _, err := os.Stat(closed); err == nil {
+		t.Fatal("the folder of the closed store is kept")
+	}
+	if _, err := os.Stat(second.folder); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheAuthenticatedResponses(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprintf(w, "%s-%d", r.Header.Get(authUserHeader), calls.Add(1))
+	}))
+	defer backend.Close()
+	users := make(map[string]string)
+	for _, name := range []string{"alice", "bob"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(name), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = string(hash)
+	}
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Auth:    &Auth{Type: AuthBasic, Users: users},
+		Cache:   &Cache{Id: "c1"},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	items := []struct {
+		name string
+		path string
+		user string
+		body string
+	}{
+		// the response of a user is not served to another one
+		{"private first", "/page", "alice", "alice-1"},
+		{"private other user", "/page", "bob", "bob-2"},
+		{"private same user", "/page", "alice", "alice-3"},
+		// unless the target allows a shared cache to
+		{"public first", "/public", "alice", "alice-4"},
+		{"public other user", "/public", "bob", "alice-4"},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+item.path, nil)
+		req.SetBasicAuth(item.user, item.user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(item.name, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != item.body {
+			t.Fatalf("%s: body %q, want %q", item.name, data, item.body)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clientVerifyHeader      = "X-Client-Verify"
+	clientCertHeader        = "X-Client-Cert"
+	clientSubjectHeader     = "X-Client-Cert-Subject"
+	clientIssuerHeader      = "X-Client-Cert-Issuer"
+	clientSerialHeader      = "X-Client-Cert-Serial"
+	clientFingerprintHeader = "X-Client-Cert-Fingerprint"
+	clientSanHeader         = "X-Client-Cert-San"
+
+	proxyV2TypeAuthority  = 0x02
+	proxyV2TypeSsl        = 0x20
+	proxyV2SubtypeVersion = 0x21
+	proxyV2SubtypeCn      = 0x22
+	proxyV2SubtypeCipher  = 0x23
+	proxyV2ClientSsl      = 0x01
+	proxyV2ClientCertConn = 0x02
+	proxyV2ClientCertSess = 0x04
+
+	crlCheckInterval = time.Minute
+)
+
+var clientCertHeaders = []string{
+	clientVerifyHeader, clientCertHeader, clientSubjectHeader, clientIssuerHeader,
+	clientSerialHeader, clientFingerprintHeader, clientSanHeader,
+}
+
+// ClientCert holds the client certificate requirement of a route on a server
+// terminating the TLS connections: a verified certificate is required, and it
+// must match one of the subjects (common name or distinguished name) or the
+// subject alternative names (a dns name may be a wildcard one) when they are
+// not empty; the details of the certificate are forwarded to the target as
+// headers, or the TLVs of the PROXY protocol (version 2) header.
+type ClientCert struct {
+	Require  bool
+	Subjects []string
+	Sans     []string
+	Headers  bool
+	Tlv      bool
+}
+
+// allows reports whether the verified certificate of the connection matches the requirement.
+func (s *ClientCert) allows(state *tls.ConnectionState) bool {
+	if state == nil || len(state.VerifiedChains) < 1 {
+		return !s.Require && len(s.Subjects) < 1 && len(s.Sans) < 1
+	}
+	if len(s.Subjects) < 1 && len(s.Sans) < 1 {
+		return true
+	}
+
+	cert := state.VerifiedChains[0][0]
+	for i := 0; i < len(s.Subjects); i++ {
+		subject := s.Subjects[i]
+		if subject == cert.Subject.CommonName || subject == cert.Subject.String() {
+			return true
+		}
+	}
+	names := certSans(cert)
+	for i := 0; i < len(s.Sans); i++ {
+		pattern := strings.ToLower(s.Sans[i])
+		for j := 0; j < len(names); j++ {
+			name := strings.ToLower(names[j])
+			if name == pattern {
+				return true
+			}
+			if strings.HasPrefix(pattern, "*.") {
+				// the wildcard matches one label only
+				label, rest, ok := strings.Cut(name, ".")
+				if ok && len(label) > 0 && "*."+rest == pattern {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// stripClientCertHeaders removes the headers of the client certificate sent by
+// the client, which the target must not take for the ones of the proxy.
+func stripClientCertHeaders(header http.Header) {
+	for i := 0; i < len(clientCertHeaders); i++ {
+		header.Del(clientCertHeaders[i])
+	}
+}
+
+// forward sets the headers of the client certificate, the ones sent by the
+// client have been removed.
+func (s *ClientCert) forward(r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+		r.Header.Set(clientVerifyHeader, "NONE")
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	r.Header.Set(clientVerifyHeader, "SUCCESS")
+	r.Header.Set(clientSubjectHeader, cert.Subject.String())
+	r.Header.Set(clientIssuerHeader, cert.Issuer.String())
+	r.Header.Set(clientSerialHeader, strings.ToUpper(cert.SerialNumber.Text(16)))
+	r.Header.Set(clientFingerprintHeader, hex.EncodeToString(fingerprint[:]))
+	r.Header.Set(clientCertHeader, url.QueryEscape(string(data)))
+	names := certSans(cert)
+	if len(names) > 0 {
+		r.Header.Set(clientSanHeader, strings.Join(names, ","))
+	}
+}
+
+func certSans(cert *x509.Certificate) []string {
+	names := make([]string, 0)
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for i := 0; i < len(cert.IPAddresses); i++ {
+		names = append(names, cert.IPAddresses[i].String())
+	}
+	for i := 0; i < len(cert.URIs); i++ {
+		names = append(names, cert.URIs[i].String())
+	}
+
+	return names
+}
+
+// clientVerifier checks the client certificates against the revocation lists,
+// which are loaded again once the file is modified.
+type clientVerifier struct {
+	file string
+	cas  []*x509.Certificate
+
+	mutex    sync.Mutex
+	checked  time.Time
+	modified time.Time
+	revoked  map[string]bool
+}
+
+func newClientVerifier(file string, cas []*x509.Certificate) (*clientVerifier, error) {
+	instance := &clientVerifier{
+		file:    file,
+		cas:     cas,
+		revoked: make(map[string]bool),
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := instance.load()
+	if err != nil {
+		return nil, err
+	}
+	instance.revoked = revoked
+	instance.modified = info.ModTime()
+	instance.checked = time.Now()
+
+	return instance, nil
+}
+
+// verify fails the handshake when one of the certificates of the chain is revoked.
+func (s *clientVerifier) verify(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) < 1 {
+		return nil
+	}
+	revoked := s.list()
+
+	chain := state.VerifiedChains[0]
+	for i := 0; i < len(chain); i++ {
+		cert := chain[i]
+		if revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.Bytes())] {
+			return fmt.Errorf("certificate '%s' (serial %s) is revoked", cert.Subject.String(), cert.SerialNumber.Text(16))
+		}
+	}
+
+	return nil
+}
+
+func (s *clientVerifier) list() map[string]bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.checked) < crlCheckInterval {
+		return s.revoked
+	}
+	s.checked = now
+	info, err := os.Stat(s.file)
+	if err != nil || info.ModTime().Equal(s.modified) {
+		return s.revoked
+	}
+	revoked, err := s.load()
+	if err == nil {
+		// the list loaded last time is kept when the new one is invalid
+		s.revoked = revoked
+		s.modified = info.ModTime()
+	}
+
+	return s.revoked
+}
+
+// load parses the revocation lists (PEM or DER) of the file, each of which
+// must be signed by one of the trusted certificate authorities.
+func (s *clientVerifier) load() (map[string]bool, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+
+	ders := make([][]byte, 0)
+	for {
+		var block *pem.Block = nil
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) < 1 && len(bytes.TrimSpace(data)) > 0 {
+		ders = append(ders, data)
+	}
+	if len(ders) < 1 {
+		return nil, fmt.Errorf("no revocation list in '%s'", s.file)
+	}
+
+	revoked := make(map[string]bool)
+	for i := 0; i < len(ders); i++ {
+		list, err := x509.ParseRevocationList(ders[i])
+		if err != nil {
+			return nil, fmt.Errorf("parse revocation list of '%s' fail: %v", s.file, err)
+		}
+		signed := false
+		for j := 0; j < len(s.cas) && !signed; j++ {
+			ca := s.cas[j]
+			signed = bytes.Equal(ca.RawSubject, list.RawIssuer) && list.CheckSignatureFrom(ca) == nil
+		}
+		if !signed {
+			return nil, fmt.Errorf("revocation list of '%s' is not signed by the client ca", s.file)
+		}
+		for j := 0; j < len(list.RevokedCertificateEntries); j++ {
+			entry := list.RevokedCertificateEntries[j]
+			revoked[revocationKey(list.RawIssuer, entry.SerialNumber.Bytes())] = true
+		}
+	}
+
+	return revoked, nil
+}
+
+func revocationKey(issuer, serial []byte) string {
+	return string(issuer) + "/" + string(serial)
+}
+
+// loadClientCas returns the certificates of the PEM bundle.
+func loadClientCas(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load client ca '%s' fail: %v", file, err)
+	}
+
+	cas := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block = nil
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("load client ca '%s' fail: %v", file, err)
+		}
+		cas = append(cas, cert)
+	}
+	if len(cas) < 1 {
+		return nil, fmt.Errorf("no certificate in client ca '%s'", file)
+	}
+
+	return cas, nil
+}
+
+// proxyTlvSsl returns the TLVs of the TLS connection for the PROXY protocol
+// (version 2) header: the server name as the authority and the ssl one.
+func proxyTlvSsl(state *tls.ConnectionState) []byte {
+	tlvs := &bytes.Buffer{}
+	if len(state.ServerName) > 0 {
+		writeProxyTlv(tlvs, proxyV2TypeAuthority, []byte(state.ServerName))
+	}
+
+	client := byte(proxyV2ClientSsl)
+	verify := uint32(1)
+	subs := &bytes.Buffer{}
+	writeProxyTlv(subs, proxyV2SubtypeVersion, []byte(tlsVersionName(state.Version)))
+	writeProxyTlv(subs, proxyV2SubtypeCipher, []byte(tls.CipherSuiteName(state.CipherSuite)))
+	if len(state.VerifiedChains) > 0 {
+		client |= proxyV2ClientCertConn
+		if state.DidResume {
+			client |= proxyV2ClientCertSess
+		}
+		verify = 0
+		cn := state.VerifiedChains[0][0].Subject.CommonName
+		if len(cn) > 0 {
+			writeProxyTlv(subs, proxyV2SubtypeCn, []byte(cn))
+		}
+	}
+	value := &bytes.Buffer{}
+	value.WriteByte(client)
+	binary.Write(value, binary.BigEndian, verify)
+	value.Write(subs.Bytes())
+	writeProxyTlv(tlvs, proxyV2TypeSsl, value.Bytes())
+
+	return tlvs.Bytes()
+}
+
+func writeProxyTlv(w *bytes.Buffer, kind byte, value []byte) {
+	w.WriteByte(kind)
+	binary.Write(w, binary.BigEndian, uint16(len(value)))
+	w.Write(value)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// clientCertAllowed checks the client certificate of the request against the
+// requirement of the route, which returns false when the response has been
+// written since the certificate is missing or not allowed.
+func (s *httpRoute) clientCertAllowed(w http.ResponseWriter, r *http.Request, sn *session) bool {
+	if s.ClientCert.allows(r.TLS) {
+		return true
+	}
+
+	subject := "-"
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		subject = r.TLS.PeerCertificates[0].Subject.String()
+	}
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	s.server.LogInfo("proxy client certificate of '", source, "' (", subject, ") is not allowed for '", r.Host, r.URL.Path, "'")
+
+	sn.decorate(w.Header())
+	err = serveErrorPage(w, s.ErrorPages, http.StatusForbidden, sn.id)
+	if err != nil {
+		s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+	}
+
+	return false
+}
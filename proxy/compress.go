@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+
+	defaultCompressMinSize = 1024
+)
+
+var (
+	defaultEncodings     = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	defaultCompressTypes = []string{
+		"text/html", "text/plain", "text/css", "text/javascript", "text/xml", "text/csv", "text/markdown",
+		"application/json", "application/javascript", "application/xml", "application/x-javascript",
+		"application/problem+json", "application/ld+json", "application/manifest+json", "application/rss+xml",
+		"application/atom+xml", "application/wasm", "image/svg+xml",
+	}
+)
+
+// Compress holds the compression of the responses of a route, the encodings
+// are in the order of preference and the types may end with a wildcard
+// subtype (text/*); empty ones and zero size mean the defaults.
+type Compress struct {
+	Encodings []string
+	MinSize   int64
+	Types     []string
+}
+
+func (s *Compress) encodings() []string {
+	if len(s.Encodings) > 0 {
+		return s.Encodings
+	}
+
+	return defaultEncodings
+}
+
+func (s *Compress) minSize() int64 {
+	if s.MinSize > 0 {
+		return s.MinSize
+	}
+
+	return defaultCompressMinSize
+}
+
+func (s *Compress) eligible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	types := s.Types
+	if len(types) < 1 {
+		types = defaultCompressTypes
+	}
+	count := len(types)
+	for i := 0; i < count; i++ {
+		item := strings.ToLower(types[i])
+		if strings.HasSuffix(item, "/*") {
+			if strings.HasPrefix(mediaType, item[:len(item)-1]) {
+				return true
+			}
+		} else if mediaType == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate returns the preferred encoding accepted by the client, or empty
+// when none of them is.
+func (s *Compress) negotiate(r *http.Request) string {
+	accepted := make(map[string]bool)
+	values := r.Header.Values("Accept-Encoding")
+	for i := 0; i < len(values); i++ {
+		items := strings.Split(values[i], ",")
+		for j := 0; j < len(items); j++ {
+			name, params, _ := strings.Cut(strings.TrimSpace(items[j]), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			quality := 1.0
+			key, value, ok := strings.Cut(strings.TrimSpace(params), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+			accepted[name] = quality > 0
+		}
+	}
+
+	encodings := s.encodings()
+	count := len(encodings)
+	for i := 0; i < count; i++ {
+		encoding := encodings[i]
+		if _, supported := encoderPools[encoding]; !supported {
+			continue
+		}
+		ok, explicit := accepted[encoding]
+		if !explicit {
+			ok = accepted["*"]
+		}
+		if ok {
+			return encoding
+		}
+	}
+
+	return ""
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	EncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+func newEncoder(encoding string, w io.Writer) encoder {
+	pool, ok := encoderPools[encoding]
+	if !ok {
+		return nil
+	}
+
+	switch e := pool.Get().(type) {
+	case *gzip.Writer:
+		e.Reset(w)
+		return e
+	case *brotli.Writer:
+		e.Reset(w)
+		return e
+	case *zstd.Encoder:
+		e.Reset(w)
+		return e
+	}
+
+	return nil
+}
+
+func releaseEncoder(encoding string, e encoder) {
+	pool, ok := encoderPools[encoding]
+	if ok {
+		pool.Put(e)
+	}
+}
+
+// compressWriter compresses the response when the content type is eligible and
+// the body is large enough; the body of unknown length is held until the
+// minimum size is reached, the handler flushes or finishes.
+type compressWriter struct {
+	http.ResponseWriter
+
+	compress *Compress
+	encoding string
+	encoder  encoder
+	status   int
+	pending  bool
+	decided  bool
+	buffer   []byte
+}
+
+// newCompressWriter returns nil when the response is not to be compressed
+// since the client accepts none of the encodings.
+func newCompressWriter(w http.ResponseWriter, r *http.Request, compress *Compress) *compressWriter {
+	if r.Method == http.MethodHead || len(r.Header.Get("Range")) > 0 {
+		return nil
+	}
+	encoding := compress.negotiate(r)
+	if len(encoding) < 1 {
+		return nil
+	}
+
+	return &compressWriter{
+		ResponseWriter: w,
+		compress:       compress,
+		encoding:       encoding,
+	}
+}
+
+func (s *compressWriter) WriteHeader(code int) {
+	if s.decided || s.pending {
+		return
+	}
+	if code < http.StatusOK {
+		s.ResponseWriter.WriteHeader(code)
+		return
+	}
+	s.status = code
+
+	header := s.ResponseWriter.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		len(header.Get("Content-Encoding")) > 0 || len(header.Get("Content-Range")) > 0 ||
+		strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") ||
+		!s.compress.eligible(header.Get("Content-Type")) {
+		s.pass()
+		return
+	}
+	addVary(header, "Accept-Encoding")
+
+	length := header.Get("Content-Length")
+	if len(length) > 0 {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err == nil && size < s.compress.minSize() {
+			s.pass()
+		} else {
+			s.start()
+		}
+		return
+	}
+
+	s.pending = true
+}
+
+func (s *compressWriter) Write(data []byte) (int, error) {
+	if !s.decided && !s.pending {
+		s.WriteHeader(http.StatusOK)
+	}
+
+	if s.pending {
+		s.buffer = append(s.buffer, data...)
+		if int64(len(s.buffer)) >= s.compress.minSize() {
+			s.start()
+		}
+		return len(data), nil
+	}
+	if s.encoder != nil {
+		return s.encoder.Write(data)
+	}
+
+	return s.ResponseWriter.Write(data)
+}
+
+// Flush compresses the body of unknown length held, as more is expected.
+func (s *compressWriter) Flush() {
+	if !s.decided && !s.pending {
+		return
+	}
+	if s.pending {
+		s.start()
+	}
+	if s.encoder != nil {
+		s.encoder.Flush()
+	}
+
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *compressWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// close writes the body held and finishes the compressed stream.
+func (s *compressWriter) close() {
+	if s.pending {
+		s.pass()
+	}
+	if s.encoder != nil {
+		s.encoder.Close()
+		releaseEncoder(s.encoding, s.encoder)
+		s.encoder = nil
+	}
+}
+
+func (s *compressWriter) pass() {
+	s.pending = false
+	s.decided = true
+	s.ResponseWriter.WriteHeader(s.status)
+	if len(s.buffer) > 0 {
+		s.ResponseWriter.Write(s.buffer)
+		s.buffer = nil
+	}
+}
+
+func (s *compressWriter) start() {
+	s.pending = false
+	s.decided = true
+
+	header := s.ResponseWriter.Header()
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", s.encoding)
+	etag := header.Get("ETag")
+	if len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		// the compressed representation is not byte by byte the same any more
+		header.Set("ETag", "W/"+etag)
+	}
+	s.ResponseWriter.WriteHeader(s.status)
+
+	s.encoder = newEncoder(s.encoding, s.ResponseWriter)
+	if len(s.buffer) > 0 {
+		s.encoder.Write(s.buffer)
+		s.buffer = nil
+	}
+}
+
+func addVary(header http.Header, name string) {
+	values := header.Values("Vary")
+	for i := 0; i < len(values); i++ {
+		items := strings.Split(values[i], ",")
+		for j := 0; j < len(items); j++ {
+			item := strings.TrimSpace(items[j])
+			if item == "*" || strings.EqualFold(item, name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
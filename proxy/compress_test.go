@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressNegotiate(t *testing.T) {
+	preferred := &Compress{Encodings: []string{EncodingGzip, EncodingBrotli}}
+	items := []struct {
+		compress *Compress
+		accept   string
+		encoding string
+	}{
+		{&Compress{}, "gzip", EncodingGzip},
+		{&Compress{}, "gzip, br", EncodingBrotli},
+		{&Compress{}, "GZIP;q=0.5", EncodingGzip},
+		{&Compress{}, "br;q=0, gzip", EncodingGzip},
+		{&Compress{}, "*", EncodingBrotli},
+		{&Compress{}, "*, br;q=0", EncodingZstd},
+		{&Compress{}, "gzip;q=0, *;q=0", ""},
+		{&Compress{}, "identity", ""},
+		{&Compress{}, "", ""},
+		{preferred, "br, gzip", EncodingGzip},
+		{preferred, "zstd", ""},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+		if len(item.accept) > 0 {
+			r.Header.Set("Accept-Encoding", item.accept)
+		}
+		if encoding := item.compress.negotiate(r); encoding != item.encoding {
+			t.Errorf("%v accepting %q: encoding %q, want %q", item.compress.Encodings, item.accept, encoding, item.encoding)
+		}
+	}
+}
+
+// decompress returns the body decoded by the encoding.
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+	var r io.Reader = nil
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case EncodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case EncodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return body
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(encoding, err)
+	}
+
+	return data
+}
+
+func TestCompressResponses(t *testing.T) {
+	large := strings.Repeat("compressible text ", 256)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("ETag", `"v1"`)
+		body := large
+		switch r.URL.Path {
+		case "/small":
+			body = large[:100]
+		case "/stream":
+			// the body of unknown length is compressed once it is large enough
+			io.WriteString(w, large[:512])
+			http.NewResponseController(w).Flush()
+			io.WriteString(w, large[512:])
+			return
+		case "/stream-small":
+			io.WriteString(w, large[:100])
+			return
+		case "/encoded":
+			header.Set("Content-Encoding", "identity")
+		case "/no-transform":
+			header.Set("Cache-Control", "public, no-transform")
+		case "/image":
+			header.Set("Content-Type", "image/png")
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address:  addr,
+		Target:   backend.Listener.Addr().String(),
+		Compress: &Compress{MinSize: 1024},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	encodings := []string{EncodingGzip, EncodingBrotli, EncodingZstd}
+	items := []struct {
+		path       string
+		body       string
+		compressed bool
+	}{
+		{"/large", large, true},
+		{"/small", large[:100], false},
+		{"/stream", large, true},
+		{"/stream-small", large[:100], false},
+		{"/encoded", large, false},
+		{"/no-transform", large, false},
+		{"/image", large, false},
+	}
+	for e := 0; e < len(encodings); e++ {
+		encoding := encodings[e]
+		for i := 0; i < len(items); i++ {
+			item := items[i]
+			req, _ := http.NewRequest(http.MethodGet, "http://"+addr+item.path, nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			name := encoding + " " + item.path
+			got := resp.Header.Get("Content-Encoding")
+			if !item.compressed {
+				if got == encoding {
+					t.Fatalf("%s: compressed, want the body passed as it is", name)
+				}
+				if string(data) != item.body {
+					t.Fatalf("%s: body of %d bytes, want %d", name, len(data), len(item.body))
+				}
+				continue
+			}
+			if got != encoding {
+				t.Fatalf("%s: encoding %q, want %q", name, got, encoding)
+			}
+			if len(data) >= len(item.body) {
+				t.Fatalf("%s: %d bytes compressed from %d", name, len(data), len(item.body))
+			}
+			if string(decompress(t, encoding, data)) != item.body {
+				t.Fatalf("%s: the body is not the same once decompressed", name)
+			}
+			if resp.Header.Get("Vary") != "Accept-Encoding" || resp.Header.Get("ETag") != `W/"v1"` ||
+				len(resp.Header.Get("Content-Length")) > 0 {
+				t.Fatalf("%s: headers %v of the compressed response", name, resp.Header)
+			}
+		}
+	}
+}
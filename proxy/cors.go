@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCorsMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	}
+	corsHeaders = []string{
+		"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Allow-Methods",
+		"Access-Control-Allow-Headers", "Access-Control-Expose-Headers", "Access-Control-Max-Age",
+	}
+)
+
+// Cors is the cross-origin resource sharing policy answering the preflight
+// requests and decorating the actual responses. An origin may be a wildcard
+// one (*, https://*.example.com); empty methods mean the common ones and empty
+// headers allow the ones requested, as the credentials require explicit names.
+// The credentials are allowed only to the origins listed apart from *.
+type Cors struct {
+	Origins       []string
+	Methods       []string
+	Headers       []string
+	ExposeHeaders []string
+	Credentials   bool
+	MaxAge        time.Duration
+}
+
+// IsPreflight reports whether the request is a preflight one.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && len(r.Header.Get("Origin")) > 0 &&
+		len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// Preflight answers the preflight request, the policy headers are set only
+// when the origin, method and headers requested are all allowed.
+func (s *Cors) Preflight(w http.ResponseWriter, r *http.Request) bool {
+	header := w.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !s.AllowOrigin(origin) || !s.allowMethod(method) {
+		return false
+	}
+	requested := make([]string, 0)
+	values := r.Header.Values("Access-Control-Request-Headers")
+	for i := 0; i < len(values); i++ {
+		items := strings.Split(values[i], ",")
+		for j := 0; j < len(items); j++ {
+			item := strings.TrimSpace(items[j])
+			if len(item) > 0 {
+				requested = append(requested, item)
+			}
+		}
+	}
+	for i := 0; i < len(requested); i++ {
+		if !s.allowHeader(requested[i]) {
+			return false
+		}
+	}
+
+	s.Apply(header, origin)
+	methods := s.Methods
+	if len(methods) < 1 {
+		methods = defaultCorsMethods
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(s.Headers) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(s.Headers, ", "))
+	} else if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if s.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(s.MaxAge/time.Second), 10))
+	}
+	header.Del("Access-Control-Expose-Headers")
+
+	return true
+}
+
+// Apply sets the policy headers of an actual response to the origin, the ones
+// of the target are replaced as the proxy owns the policy.
+func (s *Cors) Apply(header http.Header, origin string) {
+	for i := 0; i < len(corsHeaders); i++ {
+		header.Del(corsHeaders[i])
+	}
+	if len(origin) < 1 {
+		return
+	}
+	wildcard := s.allowAny()
+	if s.Credentials || !wildcard {
+		addVary(header, "Origin")
+	}
+	if !s.AllowOrigin(origin) {
+		return
+	}
+
+	// the credentials are not allowed to any origin, but to the listed ones
+	credentials := s.Credentials && s.matchOrigin(origin, false)
+	if wildcard && !credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(s.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(s.ExposeHeaders, ", "))
+	}
+}
+
+// AllowOrigin reports whether the origin matches one of the allowed ones, the
+// opaque origin (null) matches only when it is listed.
+func (s *Cors) AllowOrigin(origin string) bool {
+	return s.matchOrigin(origin, true)
+}
+
+// matchOrigin matches the origin with the allowed ones, * is taken only when wildcard is true.
+func (s *Cors) matchOrigin(origin string, wildcard bool) bool {
+	if len(origin) < 1 {
+		return false
+	}
+	origin = strings.ToLower(origin)
+
+	count := len(s.Origins)
+	for i := 0; i < count; i++ {
+		pattern := strings.ToLower(strings.TrimSuffix(s.Origins[i], "/"))
+		if pattern == "*" {
+			if wildcard && origin != "null" {
+				return true
+			}
+			continue
+		}
+		if pattern == origin {
+			return true
+		}
+		if origin == "null" {
+			continue
+		}
+		index := strings.Index(pattern, "*")
+		if index < 0 {
+			continue
+		}
+		// the wildcard matches one or more labels of the host
+		prefix, suffix := pattern[:index], pattern[index+1:]
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			labels := origin[len(prefix) : len(origin)-len(suffix)]
+			if !strings.ContainsAny(labels, "/:") && !strings.HasPrefix(labels, ".") && !strings.HasSuffix(labels, ".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Cors) allowAny() bool {
+	count := len(s.Origins)
+	for i := 0; i < count; i++ {
+		if s.Origins[i] == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Cors) allowMethod(method string) bool {
+	methods := s.Methods
+	if len(methods) < 1 {
+		methods = defaultCorsMethods
+	}
+	count := len(methods)
+	for i := 0; i < count; i++ {
+		if strings.EqualFold(methods[i], method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Cors) allowHeader(name string) bool {
+	if len(s.Headers) < 1 {
+		return true
+	}
+	count := len(s.Headers)
+	for i := 0; i < count; i++ {
+		if s.Headers[i] == "*" && !s.Credentials {
+			return true
+		}
+		if strings.EqualFold(s.Headers[i], name) {
+			return true
+		}
+	}
+
+	return false
+}
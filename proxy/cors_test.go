@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCorsAllowOrigin(t *testing.T) {
+	cors := &Cors{Origins: []string{"https://*.example.com", "http://app.test:8080/", "null"}}
+	items := []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://a.example.com", true},
+		{"https://a.b.example.com", true},
+		{"HTTPS://A.Example.com", true},
+		{"https://example.com", false},
+		{"https://.example.com", false},
+		{"http://a.example.com", false},
+		{"https://a.example.com:8443", false},
+		{"https://evil.com/.example.com", false},
+		{"https://a.example.com.evil.com", false},
+		{"http://app.test:8080", true},
+		{"http://app.test", false},
+		{"null", true},
+		{"", false},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if allow := cors.AllowOrigin(item.origin); allow != item.allow {
+			t.Errorf("origin %q allowed %v, want %v", item.origin, allow, item.allow)
+		}
+	}
+
+	// the opaque origin is not matched by *
+	if (&Cors{Origins: []string{"*"}}).AllowOrigin("null") {
+		t.Error("the opaque origin matches *")
+	}
+}
+
+func TestCorsPreflight(t *testing.T) {
+	cors := &Cors{
+		Origins:     []string{"https://*.example.com"},
+		Methods:     []string{http.MethodGet, http.MethodPost},
+		Headers:     []string{"X-Token", "Content-Type"},
+		Credentials: true,
+		MaxAge:      10 * time.Minute,
+	}
+	items := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		allow   bool
+	}{
+		{"allowed", "https://a.example.com", http.MethodPost, "x-token, content-type", true},
+		{"no headers", "https://a.example.com", http.MethodGet, "", true},
+		{"other origin", "https://a.other.com", http.MethodGet, "", false},
+		{"other method", "https://a.example.com", http.MethodDelete, "", false},
+		{"other header", "https://a.example.com", http.MethodGet, "X-Other", false},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		r := httptest.NewRequest(http.MethodOptions, "http://app.test/", nil)
+		r.Header.Set("Origin", item.origin)
+		r.Header.Set("Access-Control-Request-Method", item.method)
+		if len(item.headers) > 0 {
+			r.Header.Set("Access-Control-Request-Headers", item.headers)
+		}
+		if !IsPreflight(r) {
+			t.Fatalf("%s: not a preflight request", item.name)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("Access-Control-Expose-Headers", "X-Target")
+		allow := cors.Preflight(w, r)
+		header := w.Header()
+		if allow != item.allow {
+			t.Fatalf("%s: allowed %v, want %v", item.name, allow, item.allow)
+		}
+		if header.Values("Vary") == nil {
+			t.Fatalf("%s: no Vary of the preflight response", item.name)
+		}
+		if !item.allow {
+			if header.Get("Access-Control-Allow-Origin") != "" {
+				t.Fatalf("%s: the policy headers are set for a denied request: %v", item.name, header)
+			}
+			continue
+		}
+		if header.Get("Access-Control-Allow-Origin") != item.origin || header.Get("Access-Control-Allow-Credentials") != "true" ||
+			header.Get("Access-Control-Allow-Methods") != "GET, POST" || header.Get("Access-Control-Allow-Headers") != "X-Token, Content-Type" ||
+			header.Get("Access-Control-Max-Age") != "600" || header.Get("Access-Control-Expose-Headers") != "" {
+			t.Fatalf("%s: preflight headers %v", item.name, header)
+		}
+	}
+
+	// the headers requested are allowed as they are when none is listed
+	r := httptest.NewRequest(http.MethodOptions, "http://app.test/", nil)
+	r.Header.Set("Origin", "https://a.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	r.Header.Set("Access-Control-Request-Headers", "X-A, X-B")
+	w := httptest.NewRecorder()
+	if !(&Cors{Origins: []string{"*"}}).Preflight(w, r) {
+		t.Fatal("the requested headers are not allowed")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "X-A, X-B" {
+		t.Fatalf("allowed headers %q, want the requested ones", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, HEAD, POST, PUT, PATCH, DELETE" {
+		t.Fatalf("allowed methods %q, want the default ones", got)
+	}
+}
+
+func TestCorsApply(t *testing.T) {
+	listed := &Cors{Origins: []string{"https://app.test"}, ExposeHeaders: []string{"X-Total"}, Credentials: true}
+	wildcard := &Cors{Origins: []string{"*"}}
+	// the credentials are allowed to the listed origins only, not to *
+	mixed := &Cors{Origins: []string{"*", "https://app.test"}, Credentials: true}
+
+	items := []struct {
+		name        string
+		cors        *Cors
+		origin      string
+		allow       string
+		credentials string
+		expose      string
+		vary        bool
+	}{
+		{"listed", listed, "https://app.test", "https://app.test", "true", "X-Total", true},
+		{"not listed", listed, "https://other.test", "", "", "", true},
+		{"no origin", listed, "", "", "", "", false},
+		{"wildcard", wildcard, "https://other.test", "*", "", "", false},
+		{"listed with credentials", mixed, "https://app.test", "https://app.test", "true", "", true},
+		{"wildcard without credentials", mixed, "https://other.test", "*", "", "", true},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		header := make(http.Header)
+		// the policy of the target is replaced
+		header.Set("Access-Control-Allow-Origin", "https://target.test")
+		header.Set("Access-Control-Allow-Credentials", "true")
+		item.cors.Apply(header, item.origin)
+
+		if got := header.Get("Access-Control-Allow-Origin"); got != item.allow {
+			t.Fatalf("%s: allowed origin %q, want %q", item.name, got, item.allow)
+		}
+		if got := header.Get("Access-Control-Allow-Credentials"); got != item.credentials {
+			t.Fatalf("%s: credentials %q, want %q", item.name, got, item.credentials)
+		}
+		if got := header.Get("Access-Control-Expose-Headers"); got != item.expose {
+			t.Fatalf("%s: exposed headers %q, want %q", item.name, got, item.expose)
+		}
+		if vary := header.Get("Vary") == "Origin"; vary != item.vary {
+			t.Fatalf("%s: Vary %q, want the origin %v", item.name, header.Get("Vary"), item.vary)
+		}
+	}
+}
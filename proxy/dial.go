@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+
+	proxyV2TypeUniqueId = 0x05
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+type targetConn struct {
+	net.Conn
+
+	address string
+}
+
+// origin describes the client connection which a target connection is made for.
+type origin struct {
+	source net.Addr
+	local  net.Addr
+	id     string
+	tlvs   []byte // of the terminated TLS connection
+}
+
+// dial connects to the target of the route, starting from the given one or
+// from the balanced one when it is empty, and fails over to the other targets
+// in order; ejected backends are skipped unless no other backend is reachable.
+func (s *Server) dial(ctx context.Context, route *Route, from *origin, first string) (*targetConn, error) {
+	if len(first) < 1 {
+		first = route.balance()
+	}
+	targets := s.resolveTargets(ctx, route, route.targetsFrom(first))
+	if route.Retry.on(RetryOnConnect) {
+		return s.dialRetry(ctx, route, from, targets)
+	}
+
+	return s.dialAny(ctx, route, from, targets)
+}
+
+// dialAny connects to the first of the addresses reachable, the ejected ones
+// are tried last.
+func (s *Server) dialAny(ctx context.Context, route *Route, from *origin, addresses []string) (*targetConn, error) {
+	var err error = nil
+	skipped := make([]string, 0)
+	count := len(addresses)
+	for i := 0; i < count; i++ {
+		address := addresses[i]
+		if !s.health.allow(&route.Health, address) {
+			skipped = append(skipped, address)
+			continue
+		}
+
+		conn, e := s.dialTarget(ctx, route, address, from)
+		if e == nil {
+			return conn, nil
+		}
+		err = e
+	}
+
+	count = len(skipped)
+	for i := 0; i < count; i++ {
+		conn, e := s.dialTarget(ctx, route, skipped[i], from)
+		if e == nil {
+			return conn, nil
+		}
+		err = e
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no target available")
+	}
+	if len(skipped) == len(addresses) {
+		err = &unavailableError{err: err}
+	}
+
+	return nil, err
+}
+
+// resolveTargets replaces the targets given by host name with their addresses.
+func (s *Server) resolveTargets(ctx context.Context, route *Route, targets []string) []string {
+	if s.resolver == nil {
+		return targets
+	}
+
+	addresses := make([]string, 0, len(targets))
+	count := len(targets)
+	for i := 0; i < count; i++ {
+		addresses = append(addresses, s.resolver.resolve(ctx, targets[i], &route.Resolve)...)
+	}
+
+	return addresses
+}
+
+// dialRetry connects by the retry policy of the route, a retry goes to the
+// next target or the same one after the backoff while the budget allows.
+func (s *Server) dialRetry(ctx context.Context, route *Route, from *origin, targets []string) (*targetConn, error) {
+	var err error = nil
+
+	policy := &route.Retry
+	attempts := policy.attempts(len(targets))
+	available := false
+	index := -1
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if !route.budget.allow(policy.budget()) {
+				break
+			}
+			if !policy.wait(ctx, attempt) {
+				err = ctx.Err()
+				break
+			}
+		}
+		if index < 0 || policy.Backend != RetryBackendSame {
+			allowed := false
+			index, allowed = s.nextTarget(route, targets, index+1)
+			if allowed {
+				available = true
+			}
+		}
+
+		conn, e := s.dialTarget(ctx, route, targets[index], from)
+		if e == nil {
+			return conn, nil
+		}
+		err = e
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no target available")
+	}
+	if !available {
+		err = &unavailableError{err: err}
+	}
+
+	return nil, err
+}
+
+// nextTarget returns the index of the first target allowed by the health
+// detection from start on, or start itself when every target is ejected.
+func (s *Server) nextTarget(route *Route, targets []string, start int) (int, bool) {
+	count := len(targets)
+	for i := 0; i < count; i++ {
+		index := (start + i) % count
+		if s.health.allow(&route.Health, targets[index]) {
+			return index, true
+		}
+	}
+
+	return start % count, false
+}
+
+func (s *Server) dialTarget(ctx context.Context, route *Route, address string, from *origin) (*targetConn, error) {
+	dialer := &net.Dialer{Timeout: route.Timeout.connect()}
+	network, addr := splitAddress(route.network(), address)
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.health.fail(&route.Health, address, failureOf(err))
+			s.LogError("proxy connect to '", address, "' fail: ", err)
+		}
+		return nil, err
+	}
+
+	var header []byte = nil
+	switch route.Version {
+	case 1:
+		header = proxyHeader(from.source, from.local)
+	case 2:
+		header = proxyHeaderV2(from.source, from.local, from.id, from.tlvs)
+	}
+	if len(header) > 0 {
+		_, err = conn.Write(header)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if route.network() != ProtocolUdp {
+		// udp is connectionless, it succeeds on the first reply of the target
+		s.health.succeed(&route.Health, address, false)
+	}
+	if route.throttle != nil {
+		conn = throttleConn(conn, true, route.throttle)
+	}
+
+	return &targetConn{Conn: conn, address: address}, nil
+}
+
+func tcpAddrs(source, local net.Addr) (*net.TCPAddr, *net.TCPAddr, bool) {
+	src, ok := source.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	dst, ok := local.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return src, dst, true
+}
+
+// proxyHeader returns the PROXY protocol (version 1) header line.
+func proxyHeader(source, local net.Addr) []byte {
+	src, dst, ok := tcpAddrs(source, local)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+	}
+
+	// both addresses are of the same family, an ipv4 one is given as ipv4-mapped
+	return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(src.IP), ipv6String(dst.IP), src.Port, dst.Port))
+}
+
+func ipv6String(ip net.IP) string {
+	v4 := ip.To4()
+	if v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+
+	return ip.String()
+}
+
+// proxyHeaderV2 returns the PROXY protocol (version 2) binary header, the id
+// is sent as the unique id TLV when not empty, followed by the other TLVs.
+func proxyHeaderV2(source, local net.Addr, id string, extra []byte) []byte {
+	addresses := &bytes.Buffer{}
+	family := byte(0x00)
+	src, dst, ok := tcpAddrs(source, local)
+	if ok {
+		if src.IP.To4() != nil && dst.IP.To4() != nil {
+			family = 0x11
+			addresses.Write(src.IP.To4())
+			addresses.Write(dst.IP.To4())
+		} else {
+			family = 0x21
+			addresses.Write(src.IP.To16())
+			addresses.Write(dst.IP.To16())
+		}
+		binary.Write(addresses, binary.BigEndian, uint16(src.Port))
+		binary.Write(addresses, binary.BigEndian, uint16(dst.Port))
+	}
+
+	tlvs := &bytes.Buffer{}
+	if len(id) > 0 && len(id) <= 128 {
+		tlvs.WriteByte(proxyV2TypeUniqueId)
+		binary.Write(tlvs, binary.BigEndian, uint16(len(id)))
+		tlvs.WriteString(id)
+	}
+	tlvs.Write(extra)
+
+	header := &bytes.Buffer{}
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x21) // version 2, PROXY command
+	header.WriteByte(family)
+	binary.Write(header, binary.BigEndian, uint16(addresses.Len()+tlvs.Len()))
+	header.Write(addresses.Bytes())
+	header.Write(tlvs.Bytes())
+
+	return header.Bytes()
+}
@@ -0,0 +1,14 @@
+// Package proxy is the reverse proxy engine of the service, which replaces
+// gwsf/gproxy as that one only splices the raw connections to the target.
+//
+// Splicing leaves the backend chosen once per client connection, so the
+// health of a backend can not be told by the responses and a keep-alive
+// client sticks to one backend. Routes served over http are terminated here
+// instead: each request goes through an httputil.ReverseProxy, which picks a
+// backend from the balancer and keeps its own pool of target connections.
+// Routes with tls passthrough, and tcp and udp ones, are still spliced.
+//
+// Links are tracked the same way: a spliced connection or a udp session is one
+// link, while on the terminated routes each request in flight is one link, so
+// that the link list shows the backend of every request.
+package proxy
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const errorPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{code} {status}</title>
+</head>
+<body>
+<h1>{code} {status}</h1>
+<p>The server is temporarily unable to service your request, please try again later.</p>
+<hr>
+<p>Request ID: {requestId}<br>Time: {time}</p>
+</body>
+</html>
+`
+
+// ErrorPage is the template of the response when the upstream is unavailable,
+// the template may contain the placeholders {code}, {status}, {requestId} and {time}.
+type ErrorPage struct {
+	File        string
+	ContentType string
+}
+
+// unavailableError is returned when every target of the route has been ejected.
+type unavailableError struct {
+	err error
+}
+
+func (s *unavailableError) Error() string {
+	return s.err.Error()
+}
+
+func (s *unavailableError) Unwrap() error {
+	return s.err
+}
+
+func errorCode(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, errSlowUpload) {
+		return http.StatusRequestTimeout
+	}
+
+	var ue *unavailableError
+	if errors.As(err, &ue) {
+		return http.StatusServiceUnavailable
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusBadGateway
+}
+
+func serveErrorPage(w http.ResponseWriter, pages map[int]*ErrorPage, code int, requestId string) error {
+	var err error = nil
+	content := errorPage
+	contentType := "text/html; charset=utf-8"
+
+	page, ok := pages[code]
+	if ok && page != nil {
+		data, e := os.ReadFile(page.File)
+		if e == nil {
+			content = string(data)
+			contentType = page.ContentType
+			if len(contentType) < 1 {
+				contentType = mime.TypeByExtension(filepath.Ext(page.File))
+			}
+			if len(contentType) < 1 {
+				contentType = http.DetectContentType(data)
+			}
+		} else {
+			err = e
+		}
+	}
+
+	content = strings.NewReplacer(
+		"{code}", strconv.Itoa(code),
+		"{status}", http.StatusText(code),
+		"{requestId}", requestId,
+		"{time}", time.Now().Format("2006-01-02 15:04:05"),
+	).Replace(content)
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	header.Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write([]byte(content))
+
+	return err
+}
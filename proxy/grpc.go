@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	grpcStatusDeadlineExceeded = 4
+	grpcStatusUnavailable      = 14
+)
+
+func isGrpc(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus returns the status of a trailers-only response, -1 when the
+// status is sent in the trailers later on.
+func grpcStatus(resp *http.Response) int {
+	value := resp.Header.Get("Grpc-Status")
+	if len(value) < 1 {
+		return -1
+	}
+	status, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+
+	return status
+}
+
+// serveGrpcError answers a gRPC request the proxy failed to forward with the
+// status matching the http status code.
+func serveGrpcError(w http.ResponseWriter, code int) {
+	status := grpcStatusUnavailable
+	if code == http.StatusGatewayTimeout {
+		status = grpcStatusDeadlineExceeded
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(status))
+	header.Set("Grpc-Message", url.PathEscape(http.StatusText(code)))
+	w.WriteHeader(http.StatusOK)
+}
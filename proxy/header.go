@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderActionSet    = "set"
+	HeaderActionAppend = "append"
+	HeaderActionRemove = "remove"
+)
+
+// HeaderRule changes a header, the value may contain the variables {clientIp},
+// {clientPort}, {host}, {scheme}, {sni}, {linkId}, {requestId}, {server} of
+// the server name and {route} of the domain and path of the matched route.
+type HeaderRule struct {
+	Action string
+	Name   string
+	Value  string
+}
+
+type Headers struct {
+	XForwarded bool
+	XRealIP    bool
+	Forwarded  bool
+	Request    []HeaderRule
+	Response   []HeaderRule
+}
+
+func (s *Headers) applyRequest(header http.Header, r *http.Request, replacer *strings.Replacer) {
+	clientIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIp = r.RemoteAddr
+	}
+
+	if s.XForwarded {
+		if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+			header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIp)
+		} else {
+			header.Set("X-Forwarded-For", clientIp)
+		}
+		header.Set("X-Forwarded-Proto", requestScheme(r))
+		header.Set("X-Forwarded-Host", r.Host)
+	}
+	if s.XRealIP {
+		header.Set("X-Real-IP", clientIp)
+	}
+	if s.Forwarded {
+		header.Add("Forwarded", forwardedElement(clientIp, r))
+	}
+
+	applyHeaderRules(header, s.Request, replacer)
+}
+
+func (s *Headers) applyResponse(header http.Header, replacer *strings.Replacer) {
+	applyHeaderRules(header, s.Response, replacer)
+}
+
+func applyHeaderRules(header http.Header, rules []HeaderRule, replacer *strings.Replacer) {
+	count := len(rules)
+	for i := 0; i < count; i++ {
+		rule := rules[i]
+		switch rule.Action {
+		case HeaderActionSet:
+			header.Set(rule.Name, replacer.Replace(rule.Value))
+		case HeaderActionAppend:
+			header.Add(rule.Name, replacer.Replace(rule.Value))
+		case HeaderActionRemove:
+			header.Del(rule.Name)
+		}
+	}
+}
+
+// forwardedElement returns the element of the Forwarded header (RFC 7239) for the request.
+func forwardedElement(clientIp string, r *http.Request) string {
+	node := clientIp
+	index := strings.IndexByte(node, '%')
+	if index >= 0 {
+		// the zone of a scoped address is meaningless to others
+		node = node[:index]
+	}
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"`
+	}
+
+	host := r.Host
+	if strings.ContainsAny(host, ":[]") {
+		host = `"` + host + `"`
+	}
+
+	return "for=" + node + ";host=" + host + ";proto=" + requestScheme(r)
+}
+
+func headerReplacer(r *http.Request, sn *session) *strings.Replacer {
+	clientIp, clientPort, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIp = r.RemoteAddr
+	}
+	sni := ""
+	if r.TLS != nil {
+		sni = r.TLS.ServerName
+	}
+
+	return strings.NewReplacer(
+		"{clientIp}", clientIp,
+		"{clientPort}", clientPort,
+		"{host}", r.Host,
+		"{scheme}", requestScheme(r),
+		"{sni}", sni,
+		"{linkId}", sn.link.Id,
+		"{requestId}", sn.id,
+		"{server}", sn.route.Name,
+		"{route}", sn.route.Domain+sn.route.Path,
+	)
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderVariables(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Route"))
+	}))
+	defer backend.Close()
+
+	addr := freeAddr(t)
+	headers := Headers{Request: []HeaderRule{{Action: HeaderActionSet, Name: "X-Route", Value: "{server} {route} {host}"}}}
+	server := &Server{Routes: []Route{
+		{Name: "edge", Address: addr, Domain: "api.test", Path: "/v1/", Target: backend.Listener.Addr().String(), Headers: headers},
+		{Name: "edge", Address: addr, Path: "/", Target: backend.Listener.Addr().String(), Headers: headers},
+	}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// the route is the one matched by the request, the server is the same
+	items := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"api.test", "/v1/items", "edge api.test/v1/ api.test"},
+		{"api.test", "/v2/items", "edge / api.test"},
+		{"other.test", "/v1/items", "edge / other.test"},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+item.path, nil)
+		req.Host = item.host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != item.want {
+			t.Fatalf("%s%s: header %q, want %q", item.host, item.path, data, item.want)
+		}
+	}
+}
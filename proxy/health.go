@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+const (
+	defaultHealthMaxFails     = 3
+	defaultHealthMaxTimeouts  = 3
+	defaultHealthMaxErrors    = 5
+	defaultHealthEjectTime    = 30 * time.Second
+	defaultHealthMaxEjectTime = 5 * time.Minute
+)
+
+// Health is the passive health detection policy of a route, a backend is
+// ejected after the number of consecutive failures reaches the threshold,
+// and restored after a successful trial once the eject time has elapsed.
+type Health struct {
+	Enable       bool
+	MaxFails     int
+	MaxTimeouts  int
+	MaxErrors    int
+	EjectTime    time.Duration
+	MaxEjectTime time.Duration
+}
+
+func (s *Health) maxFails() int {
+	if s.MaxFails > 0 {
+		return s.MaxFails
+	}
+	return defaultHealthMaxFails
+}
+
+func (s *Health) maxTimeouts() int {
+	if s.MaxTimeouts > 0 {
+		return s.MaxTimeouts
+	}
+	return defaultHealthMaxTimeouts
+}
+
+func (s *Health) maxErrors() int {
+	if s.MaxErrors > 0 {
+		return s.MaxErrors
+	}
+	return defaultHealthMaxErrors
+}
+
+func (s *Health) ejectTime() time.Duration {
+	if s.EjectTime > 0 {
+		return s.EjectTime
+	}
+	return defaultHealthEjectTime
+}
+
+func (s *Health) maxEjectTime() time.Duration {
+	if s.MaxEjectTime > 0 {
+		return s.MaxEjectTime
+	}
+	if s.ejectTime() > defaultHealthMaxEjectTime {
+		return s.ejectTime()
+	}
+	return defaultHealthMaxEjectTime
+}
+
+type Backend struct {
+	Address     string          `json:"address" note:"后端地址"`
+	Ejected     bool            `json:"ejected" note:"是否已隔离"`
+	Reason      string          `json:"reason" note:"隔离原因: fail-连接失败; timeout-连接超时; error-5xx响应"`
+	Ejections   int             `json:"ejections" note:"连续隔离次数"`
+	EjectTime   *gtype.DateTime `json:"ejectTime" note:"隔离时间"`
+	RestoreTime *gtype.DateTime `json:"restoreTime" note:"允许试探连接的时间"`
+}
+
+type failure int
+
+const (
+	failureConnect failure = iota
+	failureTimeout
+	failureError
+)
+
+func (s failure) String() string {
+	switch s {
+	case failureConnect:
+		return "fail"
+	case failureTimeout:
+		return "timeout"
+	case failureError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+func failureOf(err error) failure {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return failureTimeout
+	}
+
+	return failureConnect
+}
+
+type backendState struct {
+	address    string
+	fails      int
+	timeouts   int
+	errors     int
+	ejected    bool
+	reason     failure
+	ejections  int
+	ejectAt    time.Time
+	restoreAt  time.Time
+	restoredAt time.Time
+	trying     bool
+	tryAt      time.Time
+}
+
+func (s *backendState) backend() Backend {
+	backend := Backend{
+		Address:   s.address,
+		Ejected:   s.ejected,
+		Reason:    s.reason.String(),
+		Ejections: s.ejections,
+	}
+	if !s.ejectAt.IsZero() {
+		ejectTime := gtype.DateTime(s.ejectAt)
+		backend.EjectTime = &ejectTime
+	}
+	if s.ejected {
+		restoreTime := gtype.DateTime(s.restoreAt)
+		backend.RestoreTime = &restoreTime
+	}
+
+	return backend
+}
+
+type health struct {
+	sync.Mutex
+
+	backends map[string]*backendState
+	changed  func(backend Backend)
+}
+
+func newHealth(changed func(backend Backend)) *health {
+	return &health{
+		backends: make(map[string]*backendState),
+		changed:  changed,
+	}
+}
+
+// allow reports whether a new connection may be made to the backend, once the
+// eject time of an ejected backend has elapsed a single trial is let through.
+func (s *health) allow(policy *Health, address string) bool {
+	if !policy.Enable {
+		return true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	state, ok := s.backends[address]
+	if !ok || !state.ejected {
+		return true
+	}
+
+	now := time.Now()
+	if now.Before(state.restoreAt) {
+		return false
+	}
+	if state.trying && now.Sub(state.tryAt) < policy.ejectTime() {
+		return false
+	}
+	state.trying = true
+	state.tryAt = now
+
+	return true
+}
+
+// ready reports whether allow lets a connection to the backend through, which
+// leaves the trial of an ejected backend to the connection.
+func (s *health) ready(policy *Health, address string) bool {
+	if !policy.Enable {
+		return true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	state, ok := s.backends[address]
+	if !ok || !state.ejected {
+		return true
+	}
+
+	now := time.Now()
+	if now.Before(state.restoreAt) {
+		return false
+	}
+
+	return !state.trying || now.Sub(state.tryAt) >= policy.ejectTime()
+}
+
+// succeed records a successful connect, or a non 5xx response when response is true.
+func (s *health) succeed(policy *Health, address string, response bool) {
+	if !policy.Enable {
+		return
+	}
+
+	s.Lock()
+	state, ok := s.backends[address]
+	if !ok {
+		s.Unlock()
+		return
+	}
+	if response {
+		state.errors = 0
+	} else {
+		state.fails = 0
+		state.timeouts = 0
+	}
+
+	restored := false
+	if state.ejected && state.trying {
+		// a backend ejected for error responses must answer a trial request well
+		if response || state.reason != failureError {
+			state.ejected = false
+			state.trying = false
+			state.restoredAt = time.Now()
+			restored = true
+		}
+	}
+	backend := state.backend()
+	s.Unlock()
+
+	if restored {
+		s.notify(backend)
+	}
+}
+
+func (s *health) fail(policy *Health, address string, reason failure) {
+	if !policy.Enable {
+		return
+	}
+
+	s.Lock()
+	state, ok := s.backends[address]
+	if !ok {
+		state = &backendState{address: address}
+		s.backends[address] = state
+	}
+
+	ejected := false
+	if state.ejected {
+		if state.trying {
+			s.eject(policy, state, reason)
+			ejected = true
+		}
+	} else {
+		switch reason {
+		case failureConnect:
+			state.fails++
+			ejected = state.fails >= policy.maxFails()
+		case failureTimeout:
+			state.timeouts++
+			ejected = state.timeouts >= policy.maxTimeouts()
+		case failureError:
+			state.errors++
+			ejected = state.errors >= policy.maxErrors()
+		}
+		if ejected {
+			if !state.restoredAt.IsZero() && time.Since(state.restoredAt) > policy.maxEjectTime() {
+				state.ejections = 0
+			}
+			s.eject(policy, state, reason)
+		}
+	}
+	backend := state.backend()
+	s.Unlock()
+
+	if ejected {
+		s.notify(backend)
+	}
+}
+
+func (s *health) eject(policy *Health, state *backendState, reason failure) {
+	state.ejections++
+
+	duration := policy.ejectTime()
+	maxDuration := policy.maxEjectTime()
+	for i := 1; i < state.ejections && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+
+	now := time.Now()
+	state.ejected = true
+	state.trying = false
+	state.reason = reason
+	state.ejectAt = now
+	state.restoreAt = now.Add(duration)
+	state.fails = 0
+	state.timeouts = 0
+	state.errors = 0
+}
+
+func (s *health) reset() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.backends = make(map[string]*backendState)
+}
+
+func (s *health) list() []*Backend {
+	s.Lock()
+	defer s.Unlock()
+
+	backends := make([]*Backend, 0)
+	for _, state := range s.backends {
+		if !state.ejected {
+			continue
+		}
+		backend := state.backend()
+		backends = append(backends, &backend)
+	}
+
+	return backends
+}
+
+func (s *health) notify(backend Backend) {
+	if s.changed == nil {
+		return
+	}
+
+	s.changed(backend)
+}
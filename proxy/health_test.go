@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthReadyLeavesTrial(t *testing.T) {
+	policy := &Health{Enable: true, MaxFails: 1, EjectTime: 20 * time.Millisecond}
+	h := newHealth(nil)
+	h.fail(policy, "10.0.0.1:80", failureConnect)
+	if h.ready(policy, "10.0.0.1:80") {
+		t.Fatal("the ejected backend is ready before the eject time")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !h.ready(policy, "10.0.0.1:80") {
+			t.Fatal("the backend is not ready for its trial")
+		}
+	}
+	if !h.allow(policy, "10.0.0.1:80") {
+		t.Fatal("the trial is taken by ready")
+	}
+	if h.allow(policy, "10.0.0.1:80") || h.ready(policy, "10.0.0.1:80") {
+		t.Fatal("a second trial is let through")
+	}
+}
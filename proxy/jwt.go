@@ -0,0 +1,438 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJwksRefresh  = time.Hour
+	jwksRetryInterval   = 30 * time.Second
+	jwksRequestTimeout  = 10 * time.Second
+	maxJwksDocumentSize = 1 << 20
+)
+
+var defaultJwtAlgorithms = []string{
+	"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA",
+}
+
+// JwtKey is a static key verifying the tokens, which is a public key of
+// *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey, or the []byte secret of HMAC.
+type JwtKey struct {
+	Id  string
+	Key any
+}
+
+// Jwt requires a bearer token signed by one of the static keys or the keys of
+// the JWKS document, which is discovered from the issuer (OIDC) when the url
+// is empty; the required claims must be present and equal to the value unless
+// it is empty, and the claims of the headers are forwarded to the target.
+type Jwt struct {
+	Keys       []JwtKey
+	JwksUrl    string
+	Discovery  bool
+	Refresh    time.Duration
+	Issuer     string
+	Audience   []string
+	Algorithms []string
+	Leeway     time.Duration
+	Claims     map[string]string
+	Headers    map[string]string
+	Realm      string
+}
+
+type jwtValidator struct {
+	*Jwt
+
+	server *Server
+	client *http.Client
+	parser *jwt.Parser
+
+	mutex    sync.Mutex
+	keys     map[string]any
+	fetched  time.Time
+	tried    time.Time
+	fetching chan struct{}
+}
+
+func newJwtValidator(server *Server, config *Jwt) *jwtValidator {
+	instance := &jwtValidator{
+		Jwt:    config,
+		server: server,
+		client: &http.Client{Timeout: jwksRequestTimeout},
+		keys:   make(map[string]any),
+	}
+
+	algorithms := config.Algorithms
+	if len(algorithms) < 1 {
+		algorithms = defaultJwtAlgorithms
+		for i := 0; i < len(config.Keys); i++ {
+			_, ok := config.Keys[i].Key.([]byte)
+			if ok {
+				algorithms = append(algorithms, "HS256", "HS384", "HS512")
+				break
+			}
+		}
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if len(config.Issuer) > 0 {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if len(config.Audience) > 0 {
+		options = append(options, jwt.WithAudience(config.Audience...))
+	}
+	instance.parser = jwt.NewParser(options...)
+
+	return instance
+}
+
+// serve validates the bearer token of the request, which returns false when
+// the response has been written since the token is missing or invalid.
+func (s *jwtValidator) serve(w http.ResponseWriter, r *http.Request, sn *session, pages map[int]*ErrorPage) bool {
+	// the claims are always taken from the token
+	for _, header := range s.Headers {
+		r.Header.Del(header)
+	}
+
+	value := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(strings.TrimSpace(token)) < 1 {
+		s.fail(w, r, sn, pages, "")
+		return false
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := s.parser.ParseWithClaims(strings.TrimSpace(token), claims, func(t *jwt.Token) (any, error) {
+		return s.key(r.Context(), t)
+	})
+	if err == nil {
+		err = s.require(claims)
+	}
+	if err != nil {
+		s.fail(w, r, sn, pages, err.Error())
+		return false
+	}
+
+	for claim, header := range s.Headers {
+		value, ok := claimString(claims[claim])
+		if ok {
+			r.Header.Set(header, value)
+		}
+	}
+
+	return true
+}
+
+func (s *jwtValidator) require(claims jwt.MapClaims) error {
+	for name, expected := range s.Claims {
+		value, ok := claims[name]
+		if !ok {
+			return fmt.Errorf("token is missing the required claim '%s'", name)
+		}
+		if len(expected) < 1 {
+			continue
+		}
+		// an array claim, the scopes or the roles, requires one of its items
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		matched := false
+		for i := 0; i < len(items) && !matched; i++ {
+			text, ok := claimString(items[i])
+			matched = ok && text == expected
+		}
+		if !matched {
+			return fmt.Errorf("token claim '%s' is not '%s'", name, expected)
+		}
+	}
+
+	return nil
+}
+
+func (s *jwtValidator) fail(w http.ResponseWriter, r *http.Request, sn *session, pages map[int]*ErrorPage, reason string) {
+	realm := s.Realm
+	if len(realm) < 1 {
+		realm = "grps"
+	}
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	if len(reason) > 0 {
+		challenge += fmt.Sprintf(", error=\"invalid_token\", error_description=%q", reason)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	sn.decorate(w.Header())
+
+	err := serveErrorPage(w, pages, http.StatusUnauthorized, sn.id)
+	if err != nil {
+		s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+	}
+}
+
+// key returns the key verifying the token, the static keys are tried all when
+// the token has no key id.
+func (s *jwtValidator) key(ctx context.Context, token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	set := jwt.VerificationKeySet{Keys: make([]jwt.VerificationKey, 0)}
+	for i := 0; i < len(s.Keys); i++ {
+		key := s.Keys[i]
+		if len(kid) < 1 || len(key.Id) < 1 || key.Id == kid {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	if len(s.JwksUrl) > 0 || s.Discovery {
+		keys := s.jwks(ctx, kid)
+		for id, key := range keys {
+			if len(kid) < 1 || id == kid {
+				set.Keys = append(set.Keys, key)
+			}
+		}
+	}
+	if len(set.Keys) < 1 {
+		return nil, fmt.Errorf("no key for the token (kid '%s')", kid)
+	}
+
+	return set, nil
+}
+
+// jwks returns the keys of the JWKS document, which is fetched again when it
+// expires or the key id is unknown, but not more often than the retry interval.
+// One fetch runs at a time without holding the lock, the stale keys are served
+// meanwhile unless the key id is not among them.
+func (s *jwtValidator) jwks(ctx context.Context, kid string) map[string]any {
+	s.mutex.Lock()
+	refresh := s.Refresh
+	if refresh <= 0 {
+		refresh = defaultJwksRefresh
+	}
+	now := time.Now()
+	keys := s.keys
+	_, known := keys[kid]
+	if len(kid) < 1 {
+		known = len(keys) > 0
+	}
+	expired := now.Sub(s.fetched) >= refresh
+	if !expired && (known || len(kid) < 1) {
+		s.mutex.Unlock()
+		return keys
+	}
+	done := s.fetching
+	if done == nil && now.Sub(s.tried) >= jwksRetryInterval {
+		s.tried = now
+		done = make(chan struct{})
+		s.fetching = done
+		// the fetch is shared by the requests, it outlives the one starting it
+		go s.refresh(context.WithoutCancel(ctx), done)
+	}
+	s.mutex.Unlock()
+
+	if known || done == nil {
+		return keys
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return keys
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.keys
+}
+
+// refresh fetches the keys and closes done, the keys fetched last time are
+// kept when it fails.
+func (s *jwtValidator) refresh(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		s.server.LogError("proxy jwks '", s.JwksUrl, s.Issuer, "' fail: ", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err == nil {
+		s.keys = keys
+		s.fetched = time.Now()
+	}
+	s.fetching = nil
+}
+
+func (s *jwtValidator) fetch(ctx context.Context) (map[string]any, error) {
+	url := s.JwksUrl
+	if len(url) < 1 {
+		discovery := struct {
+			JwksUri string `json:"jwks_uri"`
+		}{}
+		err := s.get(ctx, strings.TrimSuffix(s.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+		if err != nil {
+			return nil, err
+		}
+		if len(discovery.JwksUri) < 1 {
+			return nil, fmt.Errorf("no jwks_uri in the openid configuration")
+		}
+		url = discovery.JwksUri
+	}
+
+	document := struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}{}
+	err := s.get(ctx, url, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]any)
+	for i := 0; i < len(document.Keys); i++ {
+		item := document.Keys[i]
+		if item == nil || (len(item.Use) > 0 && item.Use != "sig") {
+			continue
+		}
+		key, err := item.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[item.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (s *jwtValidator) get(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, jwksRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get '%s' fail: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJwksDocumentSize)).Decode(v)
+}
+
+// jsonWebKey is a public key of the JWKS document (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (s *jsonWebKey) publicKey() (any, error) {
+	switch s.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(s.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(s.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch s.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", s.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(s.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(s.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid ec point")
+		}
+		return key, nil
+	case "OKP":
+		if s.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", s.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(s.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	// the symmetric keys are never taken from a document
+	return nil, fmt.Errorf("unsupported key type '%s'", s.Kty)
+}
+
+func claimString(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case []any:
+		items := make([]string, 0, len(v))
+		for i := 0; i < len(v); i++ {
+			item, ok := claimString(v[i])
+			if ok {
+				items = append(items, item)
+			}
+		}
+		return strings.Join(items, ","), true
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
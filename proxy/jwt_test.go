@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksStandIn serves the JWKS document of its keys, the fetches wait while
+// it is held.
+type jwksStandIn struct {
+	*httptest.Server
+
+	fetches atomic.Int32
+	mutex   sync.Mutex
+	keys    map[string]ed25519.PublicKey
+	hold    chan struct{}
+}
+
+func newJwksStandIn() *jwksStandIn {
+	instance := &jwksStandIn{keys: make(map[string]ed25519.PublicKey)}
+	instance.Server = httptest.NewServer(http.HandlerFunc(instance.serve))
+
+	return instance
+}
+
+func (s *jwksStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.fetches.Add(1)
+	s.mutex.Lock()
+	hold := s.hold
+	keys := make([]map[string]string, 0, len(s.keys))
+	for kid, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": kid,
+			"x":   base64.RawURLEncoding.EncodeToString(key),
+		})
+	}
+	s.mutex.Unlock()
+	if hold != nil {
+		<-hold
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+// rotate replaces the keys with a new one of the key id, and returns the
+// private key signing the tokens.
+func (s *jwksStandIn) rotate(kid string) ed25519.PrivateKey {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys = map[string]ed25519.PublicKey{kid: public}
+
+	return private
+}
+
+func (s *jwksStandIn) setHold(hold chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.hold = hold
+}
+
+func signJwt(t *testing.T, key ed25519.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = kid
+	value, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func validateJwt(v *jwtValidator, token string) error {
+	_, err := v.parser.ParseWithClaims(token, jwt.MapClaims{}, func(t *jwt.Token) (any, error) {
+		return v.key(context.Background(), t)
+	})
+
+	return err
+}
+
+// expireJwks lets the next lookup fetch the document again at once.
+func expireJwks(v *jwtValidator) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.fetched = time.Time{}
+	v.tried = time.Time{}
+}
+
+func TestJwksKidRotation(t *testing.T) {
+	jwks := newJwksStandIn()
+	defer jwks.Close()
+	first := jwks.rotate("k1")
+	v := newJwtValidator(&Server{}, &Jwt{JwksUrl: jwks.URL})
+
+	token := signJwt(t, first, "k1")
+	for i := 0; i < 3; i++ {
+		err := validateJwt(v, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := jwks.fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches, want the cached document", n)
+	}
+
+	// a token of the new key id fetches the document again, no more often
+	// than the retry interval
+	second := jwks.rotate("k2")
+	rotated := signJwt(t, second, "k2")
+	if validateJwt(v, rotated) == nil {
+		t.Fatal("the token of the new key is valid within the retry interval")
+	}
+	expireJwks(v)
+	err := validateJwt(v, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validateJwt(v, token) == nil {
+		t.Fatal("the token of the removed key is still valid")
+	}
+	if n := jwks.fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches, want 2", n)
+	}
+}
+
+func TestJwksFetchOutsideLock(t *testing.T) {
+	jwks := newJwksStandIn()
+	defer jwks.Close()
+	jwks.rotate("k1")
+	v := newJwtValidator(&Server{}, &Jwt{JwksUrl: jwks.URL})
+	if keys := v.jwks(context.Background(), "k1"); len(keys) != 1 {
+		t.Fatalf("%d key(s) fetched, want 1", len(keys))
+	}
+
+	hold := make(chan struct{})
+	jwks.setHold(hold)
+	jwks.rotate("k2")
+	expireJwks(v)
+
+	// the known key id is served the stale keys while the fetch waits
+	for i := 0; i < 3; i++ {
+		keys := v.jwks(context.Background(), "k1")
+		if _, ok := keys["k1"]; !ok {
+			t.Fatal("the stale keys are not served during the fetch")
+		}
+	}
+
+	// the unknown key id waits for the fetch in flight
+	var wait sync.WaitGroup
+	found := atomic.Int32{}
+	for i := 0; i < 3; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			keys := v.jwks(context.Background(), "k2")
+			if _, ok := keys["k2"]; ok {
+				found.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := found.Load(); n != 0 {
+		t.Fatalf("%d lookup(s) returned before the fetch", n)
+	}
+	close(hold)
+	wait.Wait()
+	if n := found.Load(); n != 3 {
+		t.Fatalf("%d lookup(s) got the new key, want 3", n)
+	}
+	if n := jwks.fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches, want a single one in flight", n)
+	}
+}
+
+func TestJwksFetchOutlivesRequest(t *testing.T) {
+	jwks := newJwksStandIn()
+	defer jwks.Close()
+	hold := make(chan struct{})
+	jwks.setHold(hold)
+	jwks.rotate("k1")
+	v := newJwtValidator(&Server{}, &Jwt{JwksUrl: jwks.URL})
+
+	// the request starting the fetch is gone before the document arrives
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if keys := v.jwks(ctx, "k1"); len(keys) != 0 {
+		t.Fatalf("%d key(s) before the fetch, want none", len(keys))
+	}
+	close(hold)
+	for i := 0; i < 100; i++ {
+		v.mutex.Lock()
+		_, ok := v.keys["k1"]
+		v.mutex.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the fetch is canceled with the request")
+}
+
+func TestJwtClaims(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-User")+";"+r.Header.Get("X-Tenant"))
+	}))
+	defer backend.Close()
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Jwt: &Jwt{
+			Keys:     []JwtKey{{Id: "k1", Key: public}},
+			Issuer:   "https://issuer.example.com",
+			Audience: []string{"api"},
+			Claims:   map[string]string{"scope": "read", "tenant": ""},
+			Headers:  map[string]string{"sub": "X-User", "tenant": "X-Tenant"},
+			Realm:    "api",
+		},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":    "https://issuer.example.com",
+			"aud":    "api",
+			"sub":    "alice",
+			"scope":  "read",
+			"tenant": "t1",
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		}
+	}
+	items := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		code   int
+		body   string
+		reason string
+	}{
+		// the claim headers of the client are replaced with the ones of the token
+		{"valid", func(claims jwt.MapClaims) {}, http.StatusOK, "alice;t1", ""},
+		{"one of the scopes", func(claims jwt.MapClaims) { claims["scope"] = []any{"write", "read"} }, http.StatusOK, "alice;t1", ""},
+		{"other issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://other.example.com" }, http.StatusUnauthorized, "", "issuer"},
+		{"other audience", func(claims jwt.MapClaims) { claims["aud"] = "web" }, http.StatusUnauthorized, "", "aud"},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }, http.StatusUnauthorized, "", "expired"},
+		{"no expiration", func(claims jwt.MapClaims) { delete(claims, "exp") }, http.StatusUnauthorized, "", "exp"},
+		{"missing claim", func(claims jwt.MapClaims) { delete(claims, "tenant") }, http.StatusUnauthorized, "", "tenant"},
+		{"other claim value", func(claims jwt.MapClaims) { claims["scope"] = "write" }, http.StatusUnauthorized, "", "scope"},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		claims := valid()
+		item.change(claims)
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "k1"
+		value, err := token.SignedString(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+		req.Header.Set("Authorization", "Bearer "+value)
+		req.Header.Set("X-User", "mallory")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(item.name, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.code {
+			t.Fatalf("%s: status %d, want %d", item.name, resp.StatusCode, item.code)
+		}
+		if len(item.body) > 0 && string(data) != item.body {
+			t.Fatalf("%s: body %q, want %q", item.name, data, item.body)
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if len(item.reason) < 1 {
+			if len(challenge) > 0 {
+				t.Fatalf("%s: WWW-Authenticate %q of an accepted token", item.name, challenge)
+			}
+			continue
+		}
+		if !strings.HasPrefix(challenge, `Bearer realm="api", error="invalid_token", error_description=`) ||
+			!strings.Contains(challenge, item.reason) {
+			t.Fatalf("%s: WWW-Authenticate %q, want the invalid token about %q", item.name, challenge, item.reason)
+		}
+	}
+
+	// a request without token is asked for one
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Fatalf("status %d WWW-Authenticate %q without token, want 401 %q",
+			resp.StatusCode, resp.Header.Get("WWW-Authenticate"), `Bearer realm="api"`)
+	}
+}
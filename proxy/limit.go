@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultUploadTimeout = 10 * time.Second
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errSlowUpload   = errors.New("request body uploaded too slowly")
+)
+
+// Limits holds the request limits of a route, zero means no limit. The body
+// of a minimum upload rate has to arrive within the upload timeout, which is
+// extended by the time the rate allows for each chunk received. The maximum
+// incomplete connections of a client ip address apply to the whole listener.
+type Limits struct {
+	MaxHeaderSize int64
+	MaxBodySize   int64
+	MinUploadRate int64
+	UploadTimeout time.Duration
+	MaxIncomplete int
+}
+
+func (s *Limits) uploadTimeout() time.Duration {
+	if s.UploadTimeout > 0 {
+		return s.UploadTimeout
+	}
+
+	return defaultUploadTimeout
+}
+
+type LimitStat struct {
+	HeaderTooLarge uint64 `json:"headerTooLarge" note:"请求头过大(431)的请求数"`
+	BodyTooLarge   uint64 `json:"bodyTooLarge" note:"请求体过大(413)的请求数"`
+	SlowUpload     uint64 `json:"slowUpload" note:"上传过慢(408)的请求数"`
+	Incomplete     uint64 `json:"incomplete" note:"因未完成请求头的连接过多而拒绝的连接数"`
+}
+
+type limitCounter struct {
+	headerTooLarge atomic.Uint64
+	bodyTooLarge   atomic.Uint64
+	slowUpload     atomic.Uint64
+	incomplete     atomic.Uint64
+}
+
+func (s *limitCounter) stat() *LimitStat {
+	return &LimitStat{
+		HeaderTooLarge: s.headerTooLarge.Load(),
+		BodyTooLarge:   s.bodyTooLarge.Load(),
+		SlowUpload:     s.slowUpload.Load(),
+		Incomplete:     s.incomplete.Load(),
+	}
+}
+
+// headerSize returns the size of the request line and the header as sent.
+func headerSize(r *http.Request) int64 {
+	size := int64(len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4)
+	size += int64(len(r.Host) + 8)
+	for name, values := range r.Header {
+		for i := 0; i < len(values); i++ {
+			size += int64(len(name) + len(values[i]) + 4)
+		}
+	}
+
+	return size
+}
+
+// limitedBody fails the request body which exceeds the maximum size or does not
+// arrive at the minimum upload rate.
+type limitedBody struct {
+	io.ReadCloser
+
+	limits   *Limits
+	counter  *limitCounter
+	control  *http.ResponseController
+	size     int64
+	deadline time.Time
+	err      error
+	failure  atomic.Value // the err for the handler, which reads it in another goroutine
+}
+
+func newLimitedBody(w http.ResponseWriter, r *http.Request, limits *Limits, counter *limitCounter) *limitedBody {
+	instance := &limitedBody{
+		ReadCloser: r.Body,
+		limits:     limits,
+		counter:    counter,
+	}
+	if limits.MinUploadRate > 0 {
+		instance.control = http.NewResponseController(w)
+		instance.deadline = time.Now().Add(limits.uploadTimeout())
+		instance.control.SetReadDeadline(instance.deadline)
+	}
+
+	return instance
+}
+
+func (s *limitedBody) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	limit := s.limits.MaxBodySize
+	if limit > 0 && int64(len(p)) > limit-s.size+1 {
+		// one more byte tells whether the body is too large
+		p = p[:limit-s.size+1]
+	}
+
+	n, err := s.ReadCloser.Read(p)
+	s.size += int64(n)
+	if limit > 0 && s.size > limit {
+		s.counter.bodyTooLarge.Add(1)
+		s.fail(errBodyTooLarge)
+		return 0, s.err
+	}
+	if s.control != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.counter.slowUpload.Add(1)
+			s.fail(errSlowUpload)
+			return n, s.err
+		}
+		if err == io.EOF {
+			s.control.SetReadDeadline(time.Time{})
+		} else if n > 0 {
+			s.deadline = s.deadline.Add(time.Duration(n) * time.Second / time.Duration(s.limits.MinUploadRate))
+			s.control.SetReadDeadline(s.deadline)
+		}
+	}
+
+	return n, err
+}
+
+func (s *limitedBody) fail(err error) {
+	s.err = err
+	s.failure.Store(err)
+}
+
+// failed returns the error the body failed with, or nil.
+func (s *limitedBody) failed() error {
+	err, _ := s.failure.Load().(error)
+
+	return err
+}
+
+// incompleteListener refuses the connections of a client ip address which
+// already has the maximum of connections not having sent a request header.
+type incompleteListener struct {
+	net.Listener
+
+	limit   int
+	counter *limitCounter
+	mutex   sync.Mutex
+	clients map[string]int
+}
+
+func newIncompleteListener(ln net.Listener, limit int, counter *limitCounter) *incompleteListener {
+	return &incompleteListener{
+		Listener: ln,
+		limit:    limit,
+		counter:  counter,
+		clients:  make(map[string]int),
+	}
+}
+
+func (s *incompleteListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return conn, nil
+		}
+
+		ip := addr.IP.String()
+		s.mutex.Lock()
+		count := s.clients[ip]
+		if count >= s.limit {
+			s.mutex.Unlock()
+			s.counter.incomplete.Add(1)
+			conn.Close()
+			continue
+		}
+		s.clients[ip] = count + 1
+		s.mutex.Unlock()
+
+		return &incompleteConn{Conn: conn, listener: s, ip: ip}, nil
+	}
+}
+
+func (s *incompleteListener) release(ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := s.clients[ip] - 1
+	if count > 0 {
+		s.clients[ip] = count
+	} else {
+		delete(s.clients, ip)
+	}
+}
+
+type incompleteConnKey struct{}
+
+// incompleteConn is counted for the client until the first request header is
+// read or it is closed.
+type incompleteConn struct {
+	net.Conn
+
+	listener *incompleteListener
+	ip       string
+	once     sync.Once
+}
+
+func (s *incompleteConn) complete() {
+	s.once.Do(func() {
+		s.listener.release(s.ip)
+	})
+}
+
+func (s *incompleteConn) Close() error {
+	s.complete()
+
+	return s.Conn.Close()
+}
+
+// withIncompleteConn keeps the counted connection in the context of the
+// connection for the handler, the TLS connection is unwrapped.
+func withIncompleteConn(ctx context.Context, conn net.Conn) context.Context {
+	tc, ok := conn.(interface{ NetConn() net.Conn })
+	if ok {
+		conn = tc.NetConn()
+	}
+	ic, ok := conn.(*incompleteConn)
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, incompleteConnKey{}, ic)
+}
+
+func completeConn(ctx context.Context) {
+	ic, ok := ctx.Value(incompleteConnKey{}).(*incompleteConn)
+	if ok {
+		ic.complete()
+	}
+}
+
+// allowSize checks the header and body size of the request against the limits
+// of the route, which returns false when the response has been written; the
+// body of unknown size is checked while it is read.
+func (s *httpRoute) allowSize(w http.ResponseWriter, r *http.Request, sn *session) bool {
+	code := 0
+	if s.Limits.MaxHeaderSize > 0 && headerSize(r) > s.Limits.MaxHeaderSize {
+		s.server.limits.headerTooLarge.Add(1)
+		code = http.StatusRequestHeaderFieldsTooLarge
+	} else if s.Limits.MaxBodySize > 0 && r.ContentLength > s.Limits.MaxBodySize {
+		s.server.limits.bodyTooLarge.Add(1)
+		code = http.StatusRequestEntityTooLarge
+	}
+	if code != 0 {
+		sn.decorate(w.Header())
+		err := serveErrorPage(w, s.ErrorPages, code, sn.id)
+		if err != nil {
+			s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+		}
+		return false
+	}
+
+	if (s.Limits.MaxBodySize > 0 || s.Limits.MinUploadRate > 0) && r.Body != nil && r.Body != http.NoBody {
+		sn.body = newLimitedBody(w, r, &s.Limits, s.server.limits)
+		r.Body = sn.body
+	}
+
+	return true
+}
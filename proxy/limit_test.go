@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns the chunks of data, the last one after the delay.
+type slowReader struct {
+	data  []string
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if len(s.data) < 1 {
+		return 0, io.EOF
+	}
+	if len(s.data) == 1 {
+		time.Sleep(s.delay)
+	}
+	n := copy(p, s.data[0])
+	s.data = s.data[1:]
+
+	return n, nil
+}
+
+func TestLimitsRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		fmt.Fprint(w, len(data))
+	}))
+	defer backend.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Limits: Limits{
+			MaxHeaderSize: 2048,
+			MaxBodySize:   1000,
+			MinUploadRate: 1000,
+			UploadTimeout: 300 * time.Millisecond,
+		},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	items := []struct {
+		name   string
+		body   func() io.Reader
+		header string
+		code   int
+		want   string
+	}{
+		{"within the limits", func() io.Reader { return strings.NewReader(strings.Repeat("a", 1000)) }, "", http.StatusOK, "1000"},
+		{"declared too large", func() io.Reader { return strings.NewReader(strings.Repeat("a", 1001)) }, "", http.StatusRequestEntityTooLarge, ""},
+		// the body of unknown length is counted as it is read
+		{"streamed too large", func() io.Reader {
+			return io.MultiReader(strings.NewReader(strings.Repeat("a", 600)), strings.NewReader(strings.Repeat("a", 600)))
+		}, "", http.StatusRequestEntityTooLarge, ""},
+		{"header too large", func() io.Reader { return nil }, strings.Repeat("b", 3000), http.StatusRequestHeaderFieldsTooLarge, ""},
+		// 6 bytes at 1000 bytes per second are late after the upload timeout
+		{"slow upload", func() io.Reader { return &slowReader{data: []string{"abc", "def"}, delay: 800 * time.Millisecond} },
+			"", http.StatusRequestTimeout, ""},
+		{"slow but in time", func() io.Reader { return &slowReader{data: []string{"abc", "def"}, delay: 100 * time.Millisecond} },
+			"", http.StatusOK, "6"},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/", item.body())
+		if len(item.header) > 0 {
+			req.Header.Set("X-Large", item.header)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(item.name, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.code {
+			t.Fatalf("%s: status %d, want %d", item.name, resp.StatusCode, item.code)
+		}
+		if len(item.want) > 0 && string(data) != item.want {
+			t.Fatalf("%s: body %q, want %q", item.name, data, item.want)
+		}
+	}
+
+	stat := server.Result().Limits
+	if stat.BodyTooLarge != 2 || stat.HeaderTooLarge != 1 || stat.SlowUpload != 1 || stat.Incomplete != 0 {
+		t.Fatalf("limit stat %+v", stat)
+	}
+}
+
+// refused reports whether the connection is closed by the server without a response.
+func refused(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+
+	return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func TestLimitsIncompleteConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Limits:  Limits{MaxIncomplete: 2},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dial := func(ip string) net.Conn {
+		t.Helper()
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	get := func(conn net.Conn, header string) {
+		t.Helper()
+		conn.Write([]byte(header))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	// the connections of a client which have not sent the header yet
+	incomplete := make([]net.Conn, 2)
+	for i := 0; i < len(incomplete); i++ {
+		incomplete[i] = dial("127.0.0.1")
+		defer incomplete[i].Close()
+		incomplete[i].Write([]byte("GET / HTTP/1.1\r\n"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	third := dial("127.0.0.1")
+	defer third.Close()
+	if !refused(third) {
+		t.Fatal("the connection over the maximum is not refused")
+	}
+
+	// another client ip address is counted apart
+	other := dial("127.0.0.2")
+	defer other.Close()
+	get(other, "GET / HTTP/1.1\r\nHost: app.test\r\n\r\n")
+
+	// the completed header frees the place of the connection
+	get(incomplete[0], "Host: app.test\r\n\r\n")
+	time.Sleep(50 * time.Millisecond)
+	fourth := dial("127.0.0.1")
+	defer fourth.Close()
+	get(fourth, "GET / HTTP/1.1\r\nHost: app.test\r\n\r\n")
+
+	if stat := server.Result().Limits; stat.Incomplete != 1 {
+		t.Fatalf("%d connection(s) refused, want 1", stat.Incomplete)
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+type Link struct {
+	Id         string         `json:"id" note:"标识ID"`
+	Time       gtype.DateTime `json:"time" note:"连接时间"`
+	Protocol   string         `json:"protocol" note:"协议: tcp; udp，udp为客户端会话"`
+	ListenAddr string         `json:"listenAddr" note:"监听地址"`
+	Domain     string         `json:"domain" note:"域名"`
+	SourceAddr string         `json:"sourceAddr" note:"源地址"`
+	TargetAddr string         `json:"targetAddr" note:"目标地址"`
+	RequestId  string         `json:"requestId" note:"请求ID，仅http有效"`
+	Upgrade    string         `json:"upgrade" note:"升级的协议，仅http有效: 空-未升级; websocket-已升级为WebSocket"`
+}
+
+type LinkFilter struct {
+	Protocol   string `json:"protocol" note:"协议，空表示全部"`
+	ListenAddr string `json:"listenAddr" note:"监听地址，空表示全部"`
+	Domain     string `json:"domain" note:"域名，空表示全部"`
+	SourceAddr string `json:"sourceAddr" note:"源地址，空表示全部"`
+	TargetAddr string `json:"targetAddr" note:"目标地址，空表示全部"`
+	RequestId  string `json:"requestId" note:"请求ID，空表示全部"`
+	Upgrade    string `json:"upgrade" note:"升级的协议，空表示全部"`
+}
+
+func (s *LinkFilter) match(link *Link) bool {
+	if len(s.Protocol) > 0 && s.Protocol != link.Protocol {
+		return false
+	}
+	if len(s.ListenAddr) > 0 && !strings.Contains(link.ListenAddr, s.ListenAddr) {
+		return false
+	}
+	if len(s.Domain) > 0 && !strings.Contains(link.Domain, s.Domain) {
+		return false
+	}
+	if len(s.SourceAddr) > 0 && !strings.Contains(link.SourceAddr, s.SourceAddr) {
+		return false
+	}
+	if len(s.TargetAddr) > 0 && !strings.Contains(link.TargetAddr, s.TargetAddr) {
+		return false
+	}
+	if len(s.RequestId) > 0 && s.RequestId != link.RequestId && s.RequestId != link.Id {
+		return false
+	}
+
+	if len(s.Upgrade) > 0 && s.Upgrade != link.Upgrade {
+		return false
+	}
+
+	return true
+}
+
+type LinkCollection interface {
+	Add(link *Link)
+	Del(id string)
+	Lst(filter *LinkFilter) []*Link
+}
+
+func NewLinkCollection() LinkCollection {
+	return &linkCollection{
+		items: make(map[string]*Link),
+	}
+}
+
+type linkCollection struct {
+	sync.RWMutex
+
+	items map[string]*Link
+}
+
+func (s *linkCollection) Add(link *Link) {
+	if link == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.items[link.Id] = link
+}
+
+func (s *linkCollection) Del(id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.items, id)
+}
+
+func (s *linkCollection) Lst(filter *LinkFilter) []*Link {
+	s.RLock()
+	defer s.RUnlock()
+
+	links := make([]*Link, 0)
+	for _, item := range s.items {
+		if filter != nil && !filter.match(item) {
+			continue
+		}
+		links = append(links, item)
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return time.Time(links[i].Time).Before(time.Time(links[j].Time))
+	})
+
+	return links
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	peekTimeout = 30 * time.Second
+)
+
+type listener interface {
+	serve()
+	close()
+
+	// current returns the group being served, update replaces it with one of
+	// the same listening settings while serving.
+	current() *routeGroup
+	update(group *routeGroup)
+}
+
+func closeListeners(listeners []listener) {
+	count := len(listeners)
+	for i := 0; i < count; i++ {
+		listeners[i].close()
+	}
+}
+
+// serveListener accepts connections until the listener is closed.
+func serveListener(ln net.Listener, handle func(conn net.Conn)) {
+	delay := time.Duration(0)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay < time.Second {
+				delay *= 2
+			}
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		go handle(conn)
+	}
+}
+
+// pipe copies data between the two connections until either side is closed,
+// the connections are idle for longer than the idle timeout, or the lifetime elapsed.
+func pipe(source, target net.Conn, timeout *Timeout) {
+	if timeout.Lifetime > 0 {
+		timer := time.AfterFunc(timeout.Lifetime, func() {
+			source.Close()
+			target.Close()
+		})
+		defer timer.Stop()
+	}
+
+	act := &activity{}
+	act.touch()
+	done := make(chan struct{}, 2)
+	go func() {
+		copyIdle(target, source, timeout.Idle, act)
+		done <- struct{}{}
+	}()
+	go func() {
+		copyIdle(source, target, timeout.Idle, act)
+		done <- struct{}{}
+	}()
+
+	<-done
+	source.Close()
+	target.Close()
+	<-done
+}
+
+type connSet struct {
+	sync.Mutex
+
+	items  map[net.Conn]struct{}
+	closed bool
+}
+
+func (s *connSet) add(conn net.Conn) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if s.items == nil {
+		s.items = make(map[net.Conn]struct{})
+	}
+	s.items[conn] = struct{}{}
+
+	return true
+}
+
+func (s *connSet) del(conn net.Conn) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.items, conn)
+}
+
+func (s *connSet) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	for conn := range s.items {
+		conn.Close()
+	}
+	s.items = nil
+}
@@ -0,0 +1,763 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+// httpListener routes plain HTTP requests by host and path.
+type httpListener struct {
+	server   *Server
+	listener net.Listener
+	http     *http.Server
+
+	mutex  sync.RWMutex
+	group  *routeGroup
+	routes []*httpRoute
+}
+
+// newHttpListener creates the listener of the group, the TLS connections are
+// terminated with the configuration when it is not nil.
+func newHttpListener(server *Server, ln net.Listener, group *routeGroup, config *tls.Config) *httpListener {
+	instance := &httpListener{
+		server:   server,
+		listener: ln,
+		group:    group,
+		routes:   newHttpRoutes(server, group, nil),
+	}
+	instance.http = &http.Server{
+		Handler:   instance,
+		TLSConfig: config,
+		Protocols: &http.Protocols{},
+	}
+	instance.http.Protocols.SetHTTP1(true)
+	if len(group.routes) > 0 {
+		route := group.routes[0]
+		instance.http.ReadHeaderTimeout = route.Timeout.ReadHeader
+		instance.http.IdleTimeout = route.Timeout.KeepAlive
+		instance.http.MaxHeaderBytes = maxHeaderBytes(group.routes)
+		if route.Limits.MaxIncomplete > 0 {
+			instance.listener = newIncompleteListener(ln, route.Limits.MaxIncomplete, server.limits)
+			instance.http.ConnContext = withIncompleteConn
+		}
+		if route.Http2 {
+			// h2 is negotiated by ALPN over TLS, h2c requires prior knowledge: the
+			// upgrade from HTTP/1.1 is deprecated (RFC 9113) and such clients stay on HTTP/1.1
+			instance.http.Protocols.SetHTTP2(true)
+			instance.http.Protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+
+	return instance
+}
+
+// newHttpRoutes builds the routes of the group, the running ones of the routes
+// kept by a reload are used as they are, and a new route takes the cache of a
+// replaced one of the same cache settings.
+func newHttpRoutes(server *Server, group *routeGroup, running []*httpRoute) []*httpRoute {
+	routes := make([]*httpRoute, len(group.routes))
+	kept := make([]bool, len(running))
+	count := len(group.routes)
+	for i := 0; i < count; i++ {
+		for j := 0; j < len(running); j++ {
+			if running[j].Route == group.routes[i] {
+				routes[i] = running[j]
+				kept[j] = true
+				break
+			}
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if routes[i] != nil {
+			continue
+		}
+		route := group.routes[i]
+		var cache *cacheStore = nil
+		for j := 0; j < len(running) && route.Cache != nil; j++ {
+			if !kept[j] && running[j].cache != nil && reflect.DeepEqual(running[j].Cache, route.Cache) {
+				cache = running[j].cache
+				cache.reuse(route)
+				kept[j] = true
+				break
+			}
+		}
+		routes[i] = newHttpRoute(server, route, cache)
+	}
+
+	return routes
+}
+
+// replacedRoutes returns the running routes which are not in the routes.
+func replacedRoutes(running, routes []*httpRoute) []*httpRoute {
+	replaced := make([]*httpRoute, 0)
+	for i := 0; i < len(running); i++ {
+		kept := false
+		for j := 0; j < len(routes); j++ {
+			if running[i] == routes[j] {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			replaced = append(replaced, running[i])
+		}
+	}
+
+	return replaced
+}
+
+// closeCaches removes the files of the caches of the routes, unless a cache
+// is taken by one of the routes kept.
+func closeCaches(routes []*httpRoute, kept []*httpRoute) {
+	for i := 0; i < len(routes); i++ {
+		cache := routes[i].cache
+		if cache == nil {
+			continue
+		}
+		taken := false
+		for j := 0; j < len(kept); j++ {
+			if kept[j].cache == cache {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			cache.close()
+		}
+	}
+}
+
+// maxHeaderBytes returns the largest header size limit of the routes, or zero
+// for the default when one of them has no limit.
+func maxHeaderBytes(routes []*Route) int {
+	size := int64(0)
+	count := len(routes)
+	for i := 0; i < count; i++ {
+		limit := routes[i].Limits.MaxHeaderSize
+		if limit <= 0 {
+			return 0
+		}
+		if limit > size {
+			size = limit
+		}
+	}
+
+	return int(size)
+}
+
+func (s *httpListener) serve() {
+	var err error = nil
+	if s.http.TLSConfig != nil {
+		err = s.http.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.http.Serve(s.listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		s.server.LogError("proxy http server '", s.current().address, "' error: ", err)
+	}
+}
+
+func (s *httpListener) close() {
+	s.http.Close()
+	_, routes := s.table()
+	closeIdleConnections(routes)
+	closeCaches(routes, nil)
+}
+
+func (s *httpListener) closeIdleConnections() {
+	_, routes := s.table()
+	closeIdleConnections(routes)
+}
+
+func closeIdleConnections(routes []*httpRoute) {
+	count := len(routes)
+	for i := 0; i < count; i++ {
+		routes[i].transport.CloseIdleConnections()
+		if routes[i].mirror != nil {
+			routes[i].mirror.transport.CloseIdleConnections()
+		}
+	}
+}
+
+func (s *httpListener) current() *routeGroup {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.group
+}
+
+// table returns the group being served together with the routes built of it.
+func (s *httpListener) table() (*routeGroup, []*httpRoute) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.group, s.routes
+}
+
+// update builds the routes of the group, the requests in flight finish on the
+// replaced ones while their idle connections are closed.
+func (s *httpListener) update(group *routeGroup) {
+	_, running := s.table()
+	routes := newHttpRoutes(s.server, group, running)
+	s.mutex.Lock()
+	s.group = group
+	s.routes = routes
+	s.mutex.Unlock()
+
+	replaced := replacedRoutes(running, routes)
+	closeIdleConnections(replaced)
+	closeCaches(replaced, routes)
+}
+
+func (s *httpListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	completeConn(r.Context())
+	domain := hostName(r.Host)
+	group, routes := s.table()
+	index := matchRoute(group.routes, domain, r.URL.Path)
+	if index < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	route := routes[index]
+
+	sn := &session{
+		server: s.server,
+		route:  route.Route,
+		link: Link{
+			Id:         gtype.NewGuid(),
+			Time:       gtype.DateTime(time.Now()),
+			Protocol:   ProtocolTcp,
+			ListenAddr: group.address,
+			Domain:     domain,
+			SourceAddr: r.RemoteAddr,
+		},
+	}
+	// the reverse proxy panics with http.ErrAbortHandler when a copy fails
+	defer sn.close()
+	source, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err == nil {
+		sn.source = source
+	}
+	sn.local, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	sn.id = sn.link.Id
+	if route.RequestId.Enable {
+		sn.id = route.RequestId.get(r.Header, sn.link.Id)
+		sn.link.RequestId = sn.id
+		r.Header.Set(route.RequestId.header(), sn.id)
+	}
+	sn.replacer = headerReplacer(r, sn)
+	sn.upgrade = isWebSocket(r)
+	if route.Cors != nil && !IsPreflight(r) {
+		sn.corsOrigin = r.Header.Get("Origin")
+	}
+
+	if route.AccessLog {
+		aw := &accessWriter{ResponseWriter: w}
+		defer sn.logAccess(aw, r)
+		w = aw
+	}
+	if route.Compress != nil && !sn.upgrade {
+		cw := newCompressWriter(w, r, route.Compress)
+		if cw != nil {
+			defer cw.close()
+			w = cw
+		}
+	}
+
+	if route.Maintenance != nil && route.Maintenance.blocks(r.RemoteAddr) {
+		sn.decorate(w.Header())
+		err := route.Maintenance.serve(w, r)
+		if err != nil {
+			s.server.LogError("proxy maintenance response '", r.Host, r.URL.Path, "' fail: ", err)
+		}
+		return
+	}
+	if !route.allowSize(w, r, sn) {
+		return
+	}
+	if route.Cors != nil {
+		// the preflight carries no credentials, it is answered before any authentication
+		if IsPreflight(r) {
+			code := http.StatusNoContent
+			if !route.Cors.Preflight(w, r) {
+				code = http.StatusForbidden
+			}
+			sn.decorate(w.Header())
+			w.WriteHeader(code)
+			return
+		}
+	}
+	// whether the route forwards them or not
+	stripClientCertHeaders(r.Header)
+	if route.ClientCert != nil {
+		if !route.clientCertAllowed(w, r, sn) {
+			return
+		}
+		if route.ClientCert.Headers {
+			route.ClientCert.forward(r)
+		}
+		if route.ClientCert.Tlv && r.TLS != nil {
+			sn.tlvs = proxyTlvSsl(r.TLS)
+		}
+	}
+	if route.auth != nil && !route.auth.serve(w, r, sn, route.ErrorPages) {
+		return
+	}
+	if route.jwt != nil && !route.jwt.serve(w, r, sn, route.ErrorPages) {
+		return
+	}
+	if route.Redirect != nil {
+		sn.decorate(w.Header())
+		route.Redirect.serve(w, r)
+		return
+	}
+	if route.Static != nil {
+		sn.decorate(w.Header())
+		err := route.Static.serve(w, r)
+		if err != nil {
+			s.server.LogError("proxy static response '", r.Host, r.URL.Path, "' fail: ", err)
+		}
+		return
+	}
+
+	if sn.upgrade && route.WebSocket.Deny {
+		s.server.webSocket.denied.Add(1)
+		sn.decorate(w.Header())
+		err := serveErrorPage(w, route.ErrorPages, http.StatusForbidden, sn.id)
+		if err != nil {
+			s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+		}
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), sessionKey{}, sn)
+	if route.Timeout.Lifetime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout.Lifetime)
+		defer cancel()
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: sn.gotConn,
+	})
+	var body *mirrorBody = nil
+	if route.mirror != nil && !sn.upgrade {
+		body = route.mirror.capture(r)
+	}
+	route.forward(w, r.WithContext(ctx))
+	if body != nil {
+		route.mirror.send(r, body)
+	}
+}
+
+type httpRoute struct {
+	*Route
+
+	server    *Server
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	cache     *cacheStore
+	auth      *authenticator
+	jwt       *jwtValidator
+	targets   []string
+	hosts     []string
+}
+
+// newHttpRoute creates the http route, with the cache of the replaced route
+// when it is not nil.
+func newHttpRoute(server *Server, route *Route, cache *cacheStore) *httpRoute {
+	instance := &httpRoute{
+		Route:   route,
+		server:  server,
+		targets: route.targets(),
+		hosts:   make([]string, 0),
+	}
+	// the pooled connections are keyed by the host of the request url, which
+	// is not able to hold the path of a unix domain socket
+	count := len(instance.targets)
+	for i := 0; i < count; i++ {
+		target := instance.targets[i]
+		if strings.HasPrefix(target, UnixPrefix) {
+			target = fmt.Sprintf("unix-socket-%d", i)
+		}
+		instance.hosts = append(instance.hosts, target)
+	}
+	instance.transport = &http.Transport{
+		DialContext:           instance.dial,
+		DisableKeepAlives:     route.Version > 0,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: route.Timeout.ResponseHeader,
+	}
+	if route.TargetHttp2 {
+		instance.transport.Protocols = &http.Protocols{}
+		instance.transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	instance.proxy = &httputil.ReverseProxy{
+		Rewrite:        instance.rewrite,
+		Transport:      instance,
+		FlushInterval:  -1,
+		ModifyResponse: instance.modifyResponse,
+		ErrorHandler:   instance.errorHandler,
+	}
+	if route.Auth != nil {
+		instance.auth = newAuthenticator(server, route.Auth)
+	}
+	if route.Jwt != nil {
+		instance.jwt = newJwtValidator(server, route.Jwt)
+	}
+	if cache != nil {
+		instance.cache = cache
+	} else if route.Cache != nil {
+		cache, err := newCacheStore(route)
+		if err != nil {
+			server.LogError("proxy cache folder of '", route.Domain, route.Path, "' fail, only memory is used: ", err)
+		}
+		instance.cache = cache
+	}
+
+	return instance
+}
+
+func (s *httpRoute) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	from := &origin{}
+	sn := sessionFromContext(ctx)
+	if sn != nil {
+		from = &sn.origin
+	}
+
+	// the connection is pooled by the host of the target, it must not reach
+	// another target, which is tried by RoundTrip instead
+	addresses := s.server.resolveTargets(ctx, s.Route, []string{s.targetOf(addr)})
+	conn, err := s.server.dialAny(ctx, s.Route, from, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// targetOf returns the target of the address dialed by the transport.
+func (s *httpRoute) targetOf(addr string) string {
+	count := len(s.hosts)
+	for i := 0; i < count; i++ {
+		if s.hosts[i] == addr || s.hosts[i]+":80" == addr {
+			return s.targets[i]
+		}
+	}
+
+	return s.Target
+}
+
+// pick returns the host of the target which the request is sent to, that is
+// the balanced one or the next one not ejected, so the pooled connections of
+// each target serve its own requests only.
+func (s *httpRoute) pick() string {
+	first := s.balance()
+	targets := s.targetsFrom(first)
+	count := len(targets)
+	for i := 0; i < count; i++ {
+		if s.ready(targets[i]) {
+			return s.hostOf(targets[i])
+		}
+	}
+
+	return s.hostOf(first)
+}
+
+// ready reports whether one of the addresses of the target is not ejected,
+// the health is kept by the resolved addresses which the connections are made to.
+func (s *httpRoute) ready(target string) bool {
+	addresses := []string{target}
+	if s.server.resolver != nil {
+		addresses = s.server.resolver.cached(target, &s.Resolve)
+	}
+	count := len(addresses)
+	for i := 0; i < count; i++ {
+		if s.server.health.ready(&s.Health, addresses[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *httpRoute) hostOf(target string) string {
+	count := len(s.targets)
+	for i := 0; i < count; i++ {
+		if s.targets[i] == target {
+			return s.hosts[i]
+		}
+	}
+
+	return s.hosts[0]
+}
+
+// nextHost returns the host of the first target not ejected following the one
+// of the host, or of the target right after it when all of them are.
+func (s *httpRoute) nextHost(host string) string {
+	count := len(s.hosts)
+	for i := 0; i < count; i++ {
+		if s.hosts[i] != host {
+			continue
+		}
+		for j := 1; j < count; j++ {
+			index := (i + j) % count
+			if s.ready(s.targets[index]) {
+				return s.hosts[index]
+			}
+		}
+		return s.hosts[(i+1)%count]
+	}
+
+	return s.hosts[0]
+}
+
+func (s *httpRoute) rewrite(r *httputil.ProxyRequest) {
+	r.Out.URL.Scheme = "http"
+	r.Out.URL.Host = s.pick()
+	r.Out.Host = r.In.Host
+
+	// forward the headers as they are, like a tcp proxy does
+	forwardedHeaders := []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+	for _, name := range forwardedHeaders {
+		if values, ok := r.In.Header[name]; ok {
+			r.Out.Header[name] = values
+		}
+	}
+
+	sn := sessionFromContext(r.In.Context())
+	if sn != nil {
+		s.Headers.applyRequest(r.Out.Header, r.In, sn.replacer)
+	}
+}
+
+// RoundTrip sends the request to the target. A request which reached no target
+// since the connect failed goes to the next one whatever the request is, by the
+// retry policy of the route when it retries connect failures; an idempotent
+// request without body is sent again by the retry policy when the response or
+// error matches one of the other retry conditions.
+func (s *httpRoute) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.budget.request()
+	attempts := 1
+	if s.Retry.Enable && replayable(r) {
+		attempts = s.Retry.attempts(len(s.hosts))
+	}
+	var body *unsentBody = nil
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &unsentBody{ReadCloser: r.Body}
+		r = r.Clone(r.Context())
+		r.Body = body
+	}
+
+	failovers := 0
+	attempt := 1
+	for {
+		target := ""
+		ctx := httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				conn, ok := info.Conn.(*targetConn)
+				if ok {
+					target = conn.address
+				}
+			},
+		})
+		resp, err := s.transport.RoundTrip(r.WithContext(ctx))
+		s.checkHealth(target, resp, err)
+
+		unsent := err != nil && len(target) < 1 && !body.started() && r.Context().Err() == nil
+		if unsent && !s.Retry.on(RetryOnConnect) {
+			// each of the other targets is tried once
+			failovers++
+			if failovers >= len(s.hosts) {
+				return resp, err
+			}
+			next := r.Clone(r.Context())
+			next.URL.Host = s.nextHost(r.URL.Host)
+			r = next
+			continue
+		}
+
+		if unsent {
+			if attempt >= s.Retry.attempts(len(s.hosts)) {
+				return resp, err
+			}
+		} else if attempt >= attempts || !s.Retry.retryable(resp, err, len(target) > 0) {
+			return resp, err
+		}
+		next := r.Clone(r.Context())
+		if s.Retry.Backend != RetryBackendSame {
+			next.URL.Host = s.nextHost(r.URL.Host)
+		}
+		if !s.budget.allow(s.Retry.budget()) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if !s.Retry.wait(r.Context(), attempt) {
+			return nil, r.Context().Err()
+		}
+		r = next
+		attempt++
+	}
+}
+
+// checkHealth records the result of a request sent to the target.
+func (s *httpRoute) checkHealth(target string, resp *http.Response, err error) {
+	if len(target) < 1 {
+		return
+	}
+
+	if err != nil {
+		if errorCode(err) == http.StatusGatewayTimeout && !errors.Is(err, context.DeadlineExceeded) {
+			s.server.health.fail(&s.Health, target, failureTimeout)
+		}
+	} else if resp.StatusCode >= http.StatusInternalServerError || grpcStatus(resp) == grpcStatusUnavailable {
+		s.server.health.fail(&s.Health, target, failureError)
+	} else {
+		s.server.health.succeed(&s.Health, target, true)
+	}
+}
+
+func (s *httpRoute) modifyResponse(resp *http.Response) error {
+	sn := sessionFromContext(resp.Request.Context())
+	if sn != nil {
+		if s.cache != nil {
+			sn.header = resp.Header.Clone()
+		}
+		sn.decorate(resp.Header)
+		if sn.upgrade {
+			// the link of an upgrade request is reported once the target answered
+			if resp.StatusCode == http.StatusSwitchingProtocols {
+				conn, ok := resp.Body.(io.ReadWriteCloser)
+				if ok {
+					resp.Body = newWebSocketConn(conn, s.server.webSocket, s.WebSocket.Idle)
+					sn.link.Upgrade = UpgradeWebSocket
+				}
+			}
+			sn.open()
+		}
+	}
+
+	return nil
+}
+
+func (s *httpRoute) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	sn := sessionFromContext(r.Context())
+	if sn != nil && sn.body != nil && sn.body.failed() != nil {
+		// the request is canceled as well when the connection of the slow client times out
+		err = sn.body.failed()
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	code := errorCode(err)
+	requestId := ""
+	if sn != nil {
+		requestId = sn.id
+		sn.decorate(w.Header())
+	}
+	s.server.LogError("proxy request ", requestId, " '", r.Host, r.URL.Path, "' fail: ", err)
+
+	if isGrpc(r) {
+		serveGrpcError(w, code)
+		return
+	}
+
+	err = serveErrorPage(w, s.ErrorPages, code, requestId)
+	if err != nil {
+		s.server.LogError("proxy error page of '", r.Host, r.URL.Path, "' fail: ", err)
+	}
+}
+
+type sessionKey struct{}
+
+// session holds the state of a proxied http request.
+type session struct {
+	origin
+
+	server     *Server
+	route      *Route
+	link       Link
+	connected  bool
+	opened     bool
+	upgrade    bool
+	replacer   *strings.Replacer
+	corsOrigin string
+	body       *limitedBody
+	header     http.Header // of the target response before decoration, for the cache
+}
+
+func sessionFromContext(ctx context.Context) *session {
+	sn, _ := ctx.Value(sessionKey{}).(*session)
+	return sn
+}
+
+func (s *session) gotConn(info httptrace.GotConnInfo) {
+	conn, ok := info.Conn.(*targetConn)
+	if !ok || s.connected {
+		return
+	}
+	s.connected = true
+	s.link.TargetAddr = conn.address
+	if !s.upgrade {
+		s.open()
+	}
+}
+
+func (s *session) open() {
+	if s.opened || !s.connected {
+		return
+	}
+	s.opened = true
+	s.server.connected(s.link)
+}
+
+// decorate sets the request id and the cors policy, and applies the response header rules.
+func (s *session) decorate(header http.Header) {
+	if s.route.RequestId.Enable {
+		header.Set(s.route.RequestId.header(), s.id)
+	}
+	if len(s.corsOrigin) > 0 {
+		s.route.Cors.Apply(header, s.corsOrigin)
+	}
+	s.route.Headers.applyResponse(header, s.replacer)
+}
+
+func (s *session) logAccess(w *accessWriter, r *http.Request) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	target := s.link.TargetAddr
+	if len(target) < 1 {
+		target = "-"
+	}
+
+	s.server.LogInfo("proxy access ", s.id, " ", r.RemoteAddr, " \"", r.Method, " ", r.Host, r.URL.RequestURI(), " ",
+		r.Proto, "\" ", status, " ", w.size, " ", target, " ", time.Since(time.Time(s.link.Time)))
+}
+
+func (s *session) close() {
+	if !s.opened {
+		return
+	}
+
+	s.server.disconnected(s.link)
+}
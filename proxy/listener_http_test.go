@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHttpRoundRobinOnPooledConnections(t *testing.T) {
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "a")
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "b")
+	}))
+	defer b.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address:      addr,
+		Target:       a.Listener.Addr().String(),
+		SpareTargets: []string{b.Listener.Addr().String()},
+		Balance:      BalanceRoundRobin,
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// the client keeps its connection alive, the proxy keeps the ones to the targets
+	got := ""
+	for i := 0; i < 6; i++ {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		got += string(data)
+	}
+	if got != "ababab" {
+		t.Fatalf("responses %q, want %q", got, "ababab")
+	}
+}
+
+func TestHttpStripsClientCertHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Client-Verify")+r.Header.Get("X-Client-Cert-Subject"))
+	}))
+	defer backend.Close()
+
+	// the route has no client certificate setting at all
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Header.Set("X-Client-Verify", "SUCCESS")
+	req.Header.Set("X-Client-Cert-Subject", "CN=admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(data) > 0 {
+		t.Fatalf("the target got the client certificate headers of the client: %q", data)
+	}
+}
+
+func TestHttpConnectFailoverKeepsTargetConnections(t *testing.T) {
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "b"+string(data))
+	}))
+	defer b.Close()
+
+	down := freeAddr(t)
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address:      addr,
+		Target:       down,
+		SpareTargets: []string{b.Listener.Addr().String()},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// the request reached no target, it goes to the next one with its body
+	post := func() string {
+		t.Helper()
+		resp, err := http.Post("http://"+addr+"/", "text/plain", strings.NewReader(":body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(data)
+	}
+	if got := post(); got != "b:body" {
+		t.Fatalf("response %q after the connect failed, want %q", got, "b:body")
+	}
+
+	// the connection to the spare one is not pooled for the first target
+	ln, err := net.Listen("tcp", down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "a"+string(data))
+	}))
+	a.Listener.Close()
+	a.Listener = ln
+	a.Start()
+	defer a.Close()
+	if got := post(); got != "a:body" {
+		t.Fatalf("response %q once the target is up, want %q", got, "a:body")
+	}
+}
+
+func TestHttpGrpcRetriedOnlyUnsent(t *testing.T) {
+	var calls atomic.Int32
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		data, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/reset":
+			conn, _, _ := http.NewResponseController(w).Hijack()
+			conn.Close()
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Write(data)
+		}
+	}))
+	defer b.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address:      addr,
+		Target:       freeAddr(t),
+		SpareTargets: []string{b.Listener.Addr().String()},
+		Retry:        Retry{Enable: true, RetryOn: []string{RetryOnConnect, RetryOn503, RetryOnReset}},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// the call which reached no target goes to the next one, the one which
+	// reached a target is not sent again whatever the failure is
+	items := []struct {
+		path   string
+		code   int
+		status string
+		body   string
+	}{
+		{"/pkg.Svc/Call", http.StatusOK, "", "call"},
+		{"/unavailable", http.StatusServiceUnavailable, "", ""},
+		{"/reset", http.StatusOK, "14", ""},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		calls.Store(0)
+		resp, err := http.Post("http://"+addr+item.path, "application/grpc", strings.NewReader("call"))
+		if err != nil {
+			t.Fatal(item.path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != item.code || resp.Header.Get("Grpc-Status") != item.status {
+			t.Fatalf("%s: status %d grpc %q, want %d grpc %q", item.path, resp.StatusCode,
+				resp.Header.Get("Grpc-Status"), item.code, item.status)
+		}
+		if string(data) != item.body {
+			t.Fatalf("%s: body %q, want %q", item.path, data, item.body)
+		}
+		if calls.Load() != 1 {
+			t.Fatalf("%s: the target got %d calls, want 1", item.path, calls.Load())
+		}
+	}
+}
+
+// hostLookup resolves the host names to its addresses.
+type hostLookup map[string][]string
+
+func (s hostLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, ok := s[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return ips, nil
+}
+
+func (s hostLookup) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestHttpPickSkipsEjectedHostTarget(t *testing.T) {
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "b")
+	}))
+	defer b.Close()
+
+	// the health is kept by the resolved address of the target given by name
+	_, port, _ := net.SplitHostPort(a.Listener.Addr().String())
+	addr := freeAddr(t)
+	server := &Server{
+		Routes: []Route{{
+			Address:      addr,
+			Target:       net.JoinHostPort("a.grps.test", port),
+			SpareTargets: []string{b.Listener.Addr().String()},
+			Health:       Health{Enable: true, MaxErrors: 1, EjectTime: time.Minute},
+		}},
+		Resolver: hostLookup{"a.grps.test": {"127.0.0.1"}},
+	}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	codes := make([]int, 0)
+	for i := 0; i < 4; i++ {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+	if codes[0] != http.StatusInternalServerError || codes[1] != http.StatusOK ||
+		codes[2] != http.StatusOK || codes[3] != http.StatusOK {
+		t.Fatalf("status codes %v, want the ejected target skipped after the first", codes)
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+// tcpListener forwards tcp connections without terminating them, TLS
+// connections are routed by the server name of the client hello while plain
+// ones go to the default route without any sniffing.
+type tcpListener struct {
+	server   *Server
+	listener net.Listener
+	conns    connSet
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mutex sync.RWMutex
+	group *routeGroup
+}
+
+func newTcpListener(server *Server, ln net.Listener, group *routeGroup) *tcpListener {
+	instance := &tcpListener{
+		server:   server,
+		group:    group,
+		listener: ln,
+	}
+	instance.ctx, instance.cancel = context.WithCancel(context.Background())
+
+	return instance
+}
+
+func (s *tcpListener) serve() {
+	serveListener(s.listener, s.handle)
+}
+
+func (s *tcpListener) close() {
+	s.cancel()
+	s.listener.Close()
+	s.conns.close()
+}
+
+func (s *tcpListener) current() *routeGroup {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.group
+}
+
+func (s *tcpListener) update(group *routeGroup) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.group = group
+}
+
+func (s *tcpListener) handle(conn net.Conn) {
+	defer conn.Close()
+	if !s.conns.add(conn) {
+		return
+	}
+	defer s.conns.del(conn)
+
+	group := s.current()
+	domain := ""
+	var data []byte = nil
+	if group.isTls {
+		var err error
+		conn.SetReadDeadline(time.Now().Add(peekTimeout))
+		domain, data, err = readClientHello(conn)
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	index := matchRoute(group.routes, domain, "")
+	if index < 0 {
+		return
+	}
+	route := group.routes[index]
+	if route.Maintenance != nil && route.Maintenance.blocks(conn.RemoteAddr().String()) {
+		return
+	}
+
+	from := &origin{
+		source: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+		id:     gtype.NewGuid(),
+	}
+	route.budget.request()
+	target, err := s.server.dial(s.ctx, route, from, "")
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	if len(data) > 0 {
+		_, err = target.Write(data)
+		if err != nil {
+			return
+		}
+	}
+
+	link := Link{
+		Id:         from.id,
+		Time:       gtype.DateTime(time.Now()),
+		Protocol:   ProtocolTcp,
+		ListenAddr: group.address,
+		Domain:     domain,
+		SourceAddr: conn.RemoteAddr().String(),
+		TargetAddr: target.address,
+	}
+	s.server.connected(link)
+	defer s.server.disconnected(link)
+
+	var source net.Conn = conn
+	if route.mirror != nil {
+		stream := route.mirror.stream()
+		if stream != nil {
+			defer stream.close()
+			stream.write(data)
+			source = &mirrorConn{Conn: conn, stream: stream}
+		}
+	}
+	pipe(source, target, &route.Timeout)
+	if route.AccessLog {
+		mode := "tcp"
+		if group.isTls {
+			mode = "tls " + domain
+		}
+		s.server.LogInfo("proxy access ", link.Id, " ", link.SourceAddr, " ", mode, " ",
+			target.address, " ", time.Since(time.Time(link.Time)))
+	}
+}
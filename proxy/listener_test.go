@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local tcp address which is not listened on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+// listenV6 listens on the ipv6 loopback, the test is skipped without ipv6.
+func listenV6(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6: ", err)
+	}
+
+	return ln
+}
+
+func TestProxyHeaderIpv6(t *testing.T) {
+	items := []struct {
+		version int
+		header  func(client, proxy *net.TCPAddr) []byte
+	}{
+		{1, func(client, proxy *net.TCPAddr) []byte {
+			return []byte(fmt.Sprintf("PROXY TCP6 ::1 ::1 %d %d\r\n", client.Port, proxy.Port))
+		}},
+		{2, func(client, proxy *net.TCPAddr) []byte {
+			header := append([]byte(nil), proxyV2Signature...)
+			header = append(header, 0x21, 0x21)
+			// the length is checked apart as it counts the unique id
+			header = append(header, 0, 0)
+			header = append(header, net.IPv6loopback...)
+			header = append(header, net.IPv6loopback...)
+			header = binary.BigEndian.AppendUint16(header, uint16(client.Port))
+			return binary.BigEndian.AppendUint16(header, uint16(proxy.Port))
+		}},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		target := listenV6(t)
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			data := make([]byte, 0)
+			buf := make([]byte, 512)
+			for !bytes.HasSuffix(data, []byte("ping")) {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break
+				}
+				data = append(data, buf[:n]...)
+			}
+			received <- data
+		}()
+
+		ln := listenV6(t)
+		addr := ln.Addr().String()
+		ln.Close()
+		server := &Server{Routes: []Route{{
+			Mode:    ModeTcp,
+			Address: addr,
+			Target:  target.Addr().String(),
+			Version: item.version,
+		}}}
+		err := server.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("tcp6", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("ping"))
+		var data []byte
+		select {
+		case data = <-received:
+		case <-time.After(3 * time.Second):
+		}
+		conn.Close()
+		server.Stop()
+		target.Close()
+
+		want := item.header(conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr))
+		if !bytes.HasSuffix(data, []byte("ping")) || len(data) < len(want)+4 {
+			t.Fatalf("version %d: target received %q", item.version, data)
+		}
+		header := data[:len(data)-4]
+		if item.version == 2 {
+			length := binary.BigEndian.Uint16(header[14:16])
+			if int(length) != len(header)-16 || length < 36 {
+				t.Fatalf("version 2: length %d of the header of %d bytes", length, len(header))
+			}
+			header = append(header[:14:14], append([]byte{0, 0}, header[16:len(want)]...)...)
+		}
+		if !bytes.Equal(header, want) {
+			t.Fatalf("version %d: header %q, want %q", item.version, header, want)
+		}
+	}
+}
+
+func TestHttpIpv6(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-For"))
+	}))
+	backend.Listener.Close()
+	backend.Listener = listenV6(t)
+	backend.Start()
+	defer backend.Close()
+
+	ln := listenV6(t)
+	addr := ln.Addr().String()
+	ln.Close()
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Headers: Headers{XForwarded: true},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "::1" {
+		t.Fatalf("status %d, forwarded for %q, want the ipv6 client", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,290 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+const (
+	udpIdleTimeout      = 60 * time.Second
+	udpBufferSize       = 64 * 1024
+	udpPendingDatagrams = 32
+)
+
+// udpListener forwards datagrams to the target of the default route, a
+// session is kept for each client address until it is idle.
+type udpListener struct {
+	server *Server
+	conn   net.PacketConn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex    sync.Mutex
+	group    *routeGroup
+	sessions map[string]*udpSession
+	closed   bool
+}
+
+func newUdpListener(server *Server, conn net.PacketConn, group *routeGroup) *udpListener {
+	instance := &udpListener{
+		server:   server,
+		group:    group,
+		conn:     conn,
+		sessions: make(map[string]*udpSession),
+	}
+	instance.ctx, instance.cancel = context.WithCancel(context.Background())
+
+	return instance
+}
+
+func (s *udpListener) serve() {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		sn := s.session(addr)
+		if sn == nil {
+			continue
+		}
+		sn.forward(buf[:n])
+	}
+}
+
+func (s *udpListener) close() {
+	s.cancel()
+	s.conn.Close()
+
+	s.mutex.Lock()
+	s.closed = true
+	sessions := make([]*udpSession, 0, len(s.sessions))
+	for _, sn := range s.sessions {
+		sessions = append(sessions, sn)
+	}
+	s.mutex.Unlock()
+
+	count := len(sessions)
+	for i := 0; i < count; i++ {
+		sessions[i].close()
+	}
+}
+
+func (s *udpListener) current() *routeGroup {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.group
+}
+
+// update leaves the sessions on the routes they started with.
+func (s *udpListener) update(group *routeGroup) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.group = group
+}
+
+// session returns the session of the client, a new one is created for the
+// first datagram of the client. The target is dialed apart from the read loop,
+// the datagrams of the session are queued meanwhile.
+func (s *udpListener) session(addr net.Addr) *udpSession {
+	key := addr.String()
+	s.mutex.Lock()
+	sn, ok := s.sessions[key]
+	group := s.group
+	s.mutex.Unlock()
+	if ok {
+		return sn
+	}
+
+	index := matchRoute(group.routes, "", "")
+	if index < 0 {
+		return nil
+	}
+	route := group.routes[index]
+	if route.Maintenance != nil && route.Maintenance.blocks(key) {
+		return nil
+	}
+
+	from := &origin{
+		source: addr,
+		local:  s.conn.LocalAddr(),
+		id:     gtype.NewGuid(),
+	}
+	sn = &udpSession{
+		listener: s,
+		route:    route,
+		client:   addr,
+		link: Link{
+			Id:         from.id,
+			Time:       gtype.DateTime(time.Now()),
+			Protocol:   ProtocolUdp,
+			ListenAddr: group.address,
+			SourceAddr: key,
+		},
+	}
+	sn.activity.touch()
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.sessions[key] = sn
+	s.mutex.Unlock()
+
+	route.budget.request()
+	go s.connect(sn, from)
+
+	return sn
+}
+
+// connect dials the target of the session and sends the queued datagrams, the
+// session is dropped when the dial fails so that the next datagram tries again.
+func (s *udpListener) connect(sn *udpSession, from *origin) {
+	target, err := s.server.dial(s.ctx, sn.route, from, "")
+	if err != nil {
+		s.mutex.Lock()
+		if s.sessions[sn.link.SourceAddr] == sn {
+			delete(s.sessions, sn.link.SourceAddr)
+		}
+		s.mutex.Unlock()
+		return
+	}
+
+	// the queued datagrams go first, the ones read meanwhile wait for the lock
+	sn.mutex.Lock()
+	sn.link.TargetAddr = target.address
+	for i := 0; i < len(sn.pending); i++ {
+		target.Write(sn.pending[i])
+	}
+	sn.pending = nil
+	sn.target = target
+	sn.mutex.Unlock()
+
+	// the listener closed before the target is set leaves it to the session
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		target.Close()
+		return
+	}
+
+	s.server.connected(sn.link)
+	go sn.serve()
+}
+
+func (s *udpListener) remove(sn *udpSession) {
+	s.mutex.Lock()
+	delete(s.sessions, sn.link.SourceAddr)
+	s.mutex.Unlock()
+
+	sn.target.Close()
+	s.server.disconnected(sn.link)
+	if sn.route.AccessLog {
+		s.server.LogInfo("proxy access ", sn.link.Id, " ", sn.link.SourceAddr, " udp ",
+			sn.target.address, " ", time.Since(time.Time(sn.link.Time)))
+	}
+}
+
+// udpSession relays the datagrams between a client and its target, the
+// datagrams received before the target is connected are queued up to a limit.
+type udpSession struct {
+	listener *udpListener
+	route    *Route
+	client   net.Addr
+	link     Link
+	activity activity
+
+	mutex   sync.Mutex
+	target  *targetConn
+	pending [][]byte
+}
+
+func (s *udpSession) forward(data []byte) {
+	s.activity.touch()
+	s.mutex.Lock()
+	target := s.target
+	if target == nil {
+		if len(s.pending) < udpPendingDatagrams {
+			s.pending = append(s.pending, append([]byte(nil), data...))
+		}
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+
+	_, err := target.Write(data)
+	if err != nil {
+		target.Close()
+	}
+}
+
+// close closes the target of the session, the session being connected is
+// closed once its target is.
+func (s *udpSession) close() {
+	s.mutex.Lock()
+	target := s.target
+	s.mutex.Unlock()
+
+	if target != nil {
+		target.Close()
+	}
+}
+
+// serve sends the replies of the target back to the client until the session
+// is idle, its lifetime elapsed, or the target is unreachable.
+func (s *udpSession) serve() {
+	defer s.listener.remove(s)
+
+	if s.route.Timeout.Lifetime > 0 {
+		timer := time.AfterFunc(s.route.Timeout.Lifetime, func() {
+			s.target.Close()
+		})
+		defer timer.Stop()
+	}
+
+	idle := s.route.Timeout.Idle
+	if idle <= 0 {
+		idle = udpIdleTimeout
+	}
+	health := s.listener.server.health
+	replied := false
+	buf := make([]byte, udpBufferSize)
+	for {
+		s.target.SetReadDeadline(time.Now().Add(idle))
+		n, err := s.target.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if s.activity.idle() < idle {
+					continue
+				}
+				return
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				health.fail(&s.route.Health, s.target.address, failureConnect)
+				s.listener.server.LogError("proxy udp target '", s.target.address, "' fail: ", err)
+			}
+			return
+		}
+
+		if !replied {
+			replied = true
+			health.succeed(&s.route.Health, s.target.address, false)
+		}
+		s.activity.touch()
+		s.listener.conn.WriteTo(buf[:n], s.client)
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpEcho returns a local udp target which replies each datagram with the prefix.
+func udpEcho(t *testing.T, prefix string) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+
+	return conn
+}
+
+// freeUdpAddr returns a local udp address which is not listened on.
+func freeUdpAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+type linkRecorder struct {
+	mutex  sync.Mutex
+	opened []Link
+	closed []Link
+}
+
+func (s *linkRecorder) connected(link Link) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.opened = append(s.opened, link)
+}
+
+func (s *linkRecorder) disconnected(link Link) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = append(s.closed, link)
+}
+
+func (s *linkRecorder) count() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.opened), len(s.closed)
+}
+
+func TestUdpSessions(t *testing.T) {
+	echo := udpEcho(t, "echo:")
+	defer echo.Close()
+
+	addr := freeUdpAddr(t)
+	links := &linkRecorder{}
+	server := &Server{
+		Routes: []Route{{
+			Protocol: ProtocolUdp,
+			Address:  addr,
+			Target:   echo.LocalAddr().String(),
+			Timeout:  Timeout{Idle: 200 * time.Millisecond},
+		}},
+		OnConnected:    links.connected,
+		OnDisconnected: links.disconnected,
+	}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	clients := make([]net.Conn, 2)
+	for i := 0; i < len(clients); i++ {
+		clients[i], err = net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+
+	// the replies go back to the client of each session
+	buf := make([]byte, 100)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < len(clients); i++ {
+			_, err = clients[i].Write([]byte{byte('a' + i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < len(clients); i++ {
+			clients[i].SetReadDeadline(time.Now().Add(time.Second))
+			n, err := clients[i].Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "echo:" + string(rune('a'+i))
+			if string(buf[:n]) != want {
+				t.Fatalf("client %d got %q, want %q", i, buf[:n], want)
+			}
+		}
+	}
+
+	opened, closed := links.count()
+	if opened != 2 || closed != 0 {
+		t.Fatalf("%d session(s) opened, %d closed, want 2 and 0", opened, closed)
+	}
+	links.mutex.Lock()
+	sources := map[string]bool{links.opened[0].SourceAddr: true, links.opened[1].SourceAddr: true}
+	links.mutex.Unlock()
+	for i := 0; i < len(clients); i++ {
+		if !sources[clients[i].LocalAddr().String()] {
+			t.Fatalf("no session of client %s", clients[i].LocalAddr())
+		}
+	}
+
+	// both sessions expire once idle, a new datagram opens another one
+	deadline := time.Now().Add(2 * time.Second)
+	for closed < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		opened, closed = links.count()
+	}
+	if closed != 2 {
+		t.Fatalf("%d session(s) closed after idle, want 2", closed)
+	}
+	clients[0].Write([]byte("x"))
+	clients[0].SetReadDeadline(time.Now().Add(time.Second))
+	n, err := clients[0].Read(buf)
+	if err != nil || string(buf[:n]) != "echo:x" {
+		t.Fatalf("reply %q after expiry: %v", buf[:n], err)
+	}
+	if opened, _ = links.count(); opened != 3 {
+		t.Fatalf("%d session(s) opened, want 3", opened)
+	}
+}
+
+// heldLookup answers the host lookups once released.
+type heldLookup struct {
+	hostLookup
+
+	release chan struct{}
+}
+
+func (s *heldLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return s.hostLookup.LookupHost(ctx, host)
+}
+
+func TestUdpSessionsDialApartFromReads(t *testing.T) {
+	echo := udpEcho(t, "echo:")
+	defer echo.Close()
+
+	// the target is not resolved until released, which holds the dials
+	_, port, _ := net.SplitHostPort(echo.LocalAddr().String())
+	lookup := &heldLookup{hostLookup: hostLookup{"echo.grps.test": {"127.0.0.1"}}, release: make(chan struct{})}
+	addr := freeUdpAddr(t)
+	server := &Server{
+		Routes: []Route{{
+			Protocol: ProtocolUdp,
+			Address:  addr,
+			Target:   net.JoinHostPort("echo.grps.test", port),
+		}},
+		Resolver: lookup,
+	}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	clients := make([]net.Conn, 2)
+	for i := 0; i < len(clients); i++ {
+		clients[i], err = net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+	sent := [][]string{{"a1", "a2"}, {"b1"}}
+	for i := 0; i < len(clients); i++ {
+		for j := 0; j < len(sent[i]); j++ {
+			_, err = clients[i].Write([]byte(sent[i][j]))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the datagrams of the second client are read while the first one dials
+	ln := server.listeners[0].(*udpListener)
+	sessions := 0
+	for i := 0; i < 100 && sessions < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ln.mutex.Lock()
+		sessions = len(ln.sessions)
+		ln.mutex.Unlock()
+	}
+	if sessions != 2 {
+		t.Fatalf("%d session(s) while dialing, want 2", sessions)
+	}
+
+	// the queued datagrams are sent in order once the target is connected
+	close(lookup.release)
+	buf := make([]byte, 100)
+	for i := 0; i < len(clients); i++ {
+		for j := 0; j < len(sent[i]); j++ {
+			clients[i].SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, err := clients[i].Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "echo:" + sent[i][j]
+			if string(buf[:n]) != want {
+				t.Fatalf("client %d got %q, want %q", i, buf[:n], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maintenancePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Service Unavailable</title>
+</head>
+<body>
+<h1>Service Unavailable</h1>
+<p>The service is under maintenance, please try again later.</p>
+</body>
+</html>
+`
+
+// Maintenance answers the requests of a route with a maintenance response,
+// or closes the connections for tls routes, except for whitelisted clients.
+type Maintenance struct {
+	Static
+
+	RetryAfter time.Duration
+	Whitelist  []*net.IPNet
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+func (s *Maintenance) active(now time.Time) bool {
+	if !s.StartTime.IsZero() && now.Before(s.StartTime) {
+		return false
+	}
+	if !s.EndTime.IsZero() && !now.Before(s.EndTime) {
+		return false
+	}
+
+	return true
+}
+
+// blocks reports whether the client is affected by the maintenance at the moment.
+func (s *Maintenance) blocks(address string) bool {
+	if !s.active(time.Now()) {
+		return false
+	}
+
+	ip := hostIP(address)
+	if ip == nil {
+		return true
+	}
+	count := len(s.Whitelist)
+	for i := 0; i < count; i++ {
+		if s.Whitelist[i].Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Maintenance) serve(w http.ResponseWriter, r *http.Request) error {
+	if s.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.RetryAfter/time.Second)))
+	} else if !s.EndTime.IsZero() {
+		w.Header().Set("Retry-After", s.EndTime.UTC().Format(http.TimeFormat))
+	}
+
+	static := s.Static
+	if static.Code == 0 {
+		static.Code = http.StatusServiceUnavailable
+	}
+	if len(static.Body) < 1 && len(static.File) < 1 {
+		static.Body = maintenancePage
+	}
+
+	return static.serve(w, r)
+}
+
+// ParseIPNet parses an ip address or a CIDR notation network.
+func ParseIPNet(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip address '%s'", value)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	} else {
+		ip = ip.To4()
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMirrorTimeout     = 10 * time.Second
+	defaultMirrorBodySize    = 1 << 20
+	defaultMirrorConcurrency = 64
+	mirrorStreamChunks       = 64
+)
+
+// Mirror sends a copy of the percentage of the requests, or of the whole tcp
+// streams, to the shadow target, whose responses are discarded. The requests
+// are mirrored once the primary one completes, the ones whose body is larger
+// than the maximum or not read completely are skipped, and the ones exceeding
+// the concurrency are dropped, so the shadow never slows the primary down.
+type Mirror struct {
+	Id          string
+	Target      string
+	Percent     float64
+	Timeout     time.Duration
+	MaxBodySize int64
+	Concurrency int
+}
+
+type MirrorStat struct {
+	Id         string            `json:"id" note:"目标标识ID"`
+	ListenAddr string            `json:"listenAddr" note:"监听地址"`
+	Domain     string            `json:"domain" note:"域名"`
+	Path       string            `json:"path" note:"路径"`
+	Target     string            `json:"target" note:"镜像目标地址"`
+	Requests   uint64            `json:"requests" note:"镜像的请求数(tcp为连接数)"`
+	Skipped    uint64            `json:"skipped" note:"因请求体过大或未读取完整而跳过的请求数"`
+	Dropped    uint64            `json:"dropped" note:"因超过并发数或镜像目标过慢而丢弃的请求数(tcp为连接数)"`
+	Failures   uint64            `json:"failures" note:"镜像目标连接或响应失败的次数"`
+	Bytes      uint64            `json:"bytes" note:"镜像的数据量(字节)，仅tcp有效"`
+	Codes      map[string]uint64 `json:"codes" note:"镜像目标的响应状态码及次数，仅http有效"`
+	Latency    float64           `json:"latency" note:"镜像目标的平均延迟(毫秒)，http为响应时间，tcp为连接时间"`
+	MaxLatency float64           `json:"maxLatency" note:"镜像目标的最大延迟(毫秒)"`
+}
+
+type mirror struct {
+	*Mirror
+
+	server    *Server
+	route     *Route
+	transport *http.Transport
+	slots     chan struct{}
+
+	requests atomic.Uint64
+	skipped  atomic.Uint64
+	dropped  atomic.Uint64
+	failures atomic.Uint64
+	bytes    atomic.Uint64
+
+	mutex   sync.Mutex
+	codes   map[int]uint64
+	total   time.Duration
+	samples int64
+	longest time.Duration
+}
+
+func newMirror(server *Server, route *Route) *mirror {
+	config := route.Mirror
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultMirrorConcurrency
+	}
+	instance := &mirror{
+		Mirror: config,
+		server: server,
+		route:  route,
+		slots:  make(chan struct{}, concurrency),
+		codes:  make(map[int]uint64),
+	}
+	instance.transport = &http.Transport{
+		DialContext:         instance.dial,
+		MaxIdleConnsPerHost: concurrency,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return instance
+}
+
+func (s *mirror) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+
+	return defaultMirrorTimeout
+}
+
+func (s *mirror) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout()}
+	network, address := splitAddress("tcp", s.Target)
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+// sample reports whether the request or stream is to be mirrored.
+func (s *mirror) sample() bool {
+	return s.Percent >= 100 || rand.Float64()*100 < s.Percent
+}
+
+func (s *mirror) acquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		s.dropped.Add(1)
+		return false
+	}
+}
+
+func (s *mirror) release() {
+	<-s.slots
+}
+
+func (s *mirror) record(code int, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if code > 0 {
+		s.codes[code]++
+	}
+	s.total += latency
+	s.samples++
+	if latency > s.longest {
+		s.longest = latency
+	}
+}
+
+func (s *mirror) stat(listenAddr string) *MirrorStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &MirrorStat{
+		Id:         s.Id,
+		ListenAddr: listenAddr,
+		Domain:     s.route.Domain,
+		Path:       s.route.Path,
+		Target:     s.Target,
+		Requests:   s.requests.Load(),
+		Skipped:    s.skipped.Load(),
+		Dropped:    s.dropped.Load(),
+		Failures:   s.failures.Load(),
+		Bytes:      s.bytes.Load(),
+		Codes:      make(map[string]uint64),
+		MaxLatency: float64(s.longest) / float64(time.Millisecond),
+	}
+	for code, count := range s.codes {
+		stat.Codes[strconv.Itoa(code)] = count
+	}
+	if s.samples > 0 {
+		stat.Latency = float64(s.total) / float64(s.samples) / float64(time.Millisecond)
+	}
+
+	return stat
+}
+
+// capture records the body of the request read by the primary one, it returns
+// nil when the request is not to be mirrored.
+func (s *mirror) capture(r *http.Request) *mirrorBody {
+	if !s.sample() {
+		return nil
+	}
+
+	instance := &mirrorBody{limit: s.MaxBodySize}
+	if instance.limit <= 0 {
+		instance.limit = defaultMirrorBodySize
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		instance.done = true
+	} else {
+		instance.ReadCloser = r.Body
+		r.Body = instance
+	}
+
+	return instance
+}
+
+// send mirrors the completed request in the background.
+func (s *mirror) send(r *http.Request, body *mirrorBody) {
+	if !body.done || body.overflow {
+		s.skipped.Add(1)
+		return
+	}
+	if !s.acquire() {
+		return
+	}
+
+	out, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), bytes.NewReader(body.data))
+	if err != nil {
+		s.release()
+		s.failures.Add(1)
+		return
+	}
+	for name, values := range r.Header {
+		if isHopHeader(name) {
+			continue
+		}
+		out.Header[name] = append([]string(nil), values...)
+	}
+	out.Host = r.Host
+	out.ContentLength = int64(len(body.data))
+	s.requests.Add(1)
+
+	go func() {
+		defer s.release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+		defer cancel()
+		start := time.Now()
+		resp, err := s.transport.RoundTrip(out.WithContext(ctx))
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil {
+			s.failures.Add(1)
+			return
+		}
+		s.record(resp.StatusCode, time.Since(start))
+	}()
+}
+
+// mirrorBody keeps a copy of the request body as it is read by the primary request.
+type mirrorBody struct {
+	io.ReadCloser
+
+	limit    int64
+	data     []byte
+	done     bool
+	overflow bool
+}
+
+func (s *mirrorBody) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if n > 0 && !s.overflow {
+		if int64(len(s.data)+n) > s.limit {
+			s.overflow = true
+			s.data = nil
+		} else {
+			s.data = append(s.data, p[:n]...)
+		}
+	}
+	if err == io.EOF {
+		s.done = true
+	}
+
+	return n, err
+}
+
+// stream mirrors the data sent by the client of a tcp connection, it returns
+// nil when the stream is not to be mirrored.
+func (s *mirror) stream() *mirrorStream {
+	if !s.sample() || !s.acquire() {
+		return nil
+	}
+	s.requests.Add(1)
+
+	instance := &mirrorStream{
+		mirror: s,
+		chunks: make(chan []byte, mirrorStreamChunks),
+		closed: make(chan struct{}),
+	}
+	go instance.run()
+
+	return instance
+}
+
+// mirrorStream writes the data of the client to the shadow target, the stream
+// is abandoned once the shadow target falls behind.
+type mirrorStream struct {
+	mirror    *mirror
+	chunks    chan []byte
+	closed    chan struct{}
+	once      sync.Once
+	abandoned atomic.Bool
+}
+
+func (s *mirrorStream) write(data []byte) {
+	if s.abandoned.Load() || len(data) < 1 {
+		return
+	}
+
+	select {
+	case s.chunks <- append([]byte(nil), data...):
+	default:
+		if !s.abandoned.Swap(true) {
+			s.mirror.dropped.Add(1)
+		}
+		s.close()
+	}
+}
+
+func (s *mirrorStream) close() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+}
+
+func (s *mirrorStream) run() {
+	defer s.mirror.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.mirror.timeout())
+	start := time.Now()
+	conn, err := s.mirror.dial(ctx, "tcp", s.mirror.Target)
+	cancel()
+	if err != nil {
+		s.abandoned.Store(true)
+		s.mirror.failures.Add(1)
+		return
+	}
+	defer conn.Close()
+	s.mirror.record(0, time.Since(start))
+
+	// the responses of the shadow target are discarded
+	go io.Copy(io.Discard, conn)
+
+	for {
+		select {
+		case data := <-s.chunks:
+			if !s.send(conn, data) {
+				return
+			}
+		case <-s.closed:
+			// the data received before the client closed is still sent
+			for !s.abandoned.Load() {
+				select {
+				case data := <-s.chunks:
+					if !s.send(conn, data) {
+						return
+					}
+				default:
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func (s *mirrorStream) send(conn net.Conn, data []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(s.mirror.timeout()))
+	_, err := conn.Write(data)
+	if err != nil {
+		s.abandoned.Store(true)
+		s.mirror.failures.Add(1)
+		return false
+	}
+	s.mirror.bytes.Add(uint64(len(data)))
+
+	return true
+}
+
+// mirrorConn copies the data read from the client to the mirror stream.
+type mirrorConn struct {
+	net.Conn
+
+	stream *mirrorStream
+}
+
+func (s *mirrorConn) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	if n > 0 {
+		s.stream.write(p[:n])
+	}
+
+	return n, err
+}
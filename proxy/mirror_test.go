@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorSampling(t *testing.T) {
+	items := []struct {
+		percent float64
+		min     int
+		max     int
+	}{
+		{0, 0, 0},
+		{100, 10000, 10000},
+		{30, 2700, 3300},
+		{0.5, 10, 100},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		m := &mirror{Mirror: &Mirror{Percent: item.percent}}
+		sampled := 0
+		for j := 0; j < 10000; j++ {
+			if m.sample() {
+				sampled++
+			}
+		}
+		if sampled < item.min || sampled > item.max {
+			t.Errorf("%v%%: %d of 10000 sampled, want %d to %d", item.percent, sampled, item.min, item.max)
+		}
+	}
+}
+
+// mirrorStat waits until the statistics of the only mirror of the server
+// satisfy the condition, as the requests are mirrored once the primary one
+// completes, and returns the last ones.
+func mirrorStat(t *testing.T, server *Server, done func(stat *MirrorStat) bool) *MirrorStat {
+	t.Helper()
+	var stat *MirrorStat = nil
+	for i := 0; i < 200; i++ {
+		stats := server.MirrorStats()
+		if len(stats) != 1 {
+			t.Fatalf("%d mirror(s), want 1", len(stats))
+		}
+		stat = stats[0]
+		if done(stat) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return stat
+}
+
+func TestMirrorHttp(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer backend.Close()
+	var mutex sync.Mutex
+	mirrored := make([]string, 0)
+	hold := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		mirrored = append(mirrored, r.Method+" "+r.URL.Path+" "+string(data))
+		mutex.Unlock()
+		if r.URL.Path == "/hold" {
+			<-hold
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer shadow.Close()
+	defer close(hold)
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  backend.Listener.Addr().String(),
+		Mirror: &Mirror{
+			Id:          "m1",
+			Target:      shadow.Listener.Addr().String(),
+			Percent:     100,
+			MaxBodySize: 16,
+			Concurrency: 1,
+		},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	post := func(path, body string) {
+		t.Helper()
+		resp, err := http.Post("http://"+addr+path, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != body {
+			t.Fatalf("primary response %q, want %q", data, body)
+		}
+	}
+
+	// the body read by the primary request goes to the shadow as well
+	post("/a", "hello")
+	stat := mirrorStat(t, server, func(stat *MirrorStat) bool { return stat.Codes["202"] == 1 })
+	if stat.Requests != 1 || stat.Codes["202"] != 1 {
+		t.Fatalf("mirror stat %+v after the first request", stat)
+	}
+
+	// the body over the maximum is skipped
+	post("/large", strings.Repeat("x", 32))
+	stat = mirrorStat(t, server, func(stat *MirrorStat) bool { return stat.Skipped > 0 })
+	if stat.Requests != 1 || stat.Skipped != 1 {
+		t.Fatalf("mirror stat %+v after the large request", stat)
+	}
+
+	// the request over the concurrency is dropped while the shadow is held
+	post("/hold", "held")
+	post("/b", "dropped")
+	stat = mirrorStat(t, server, func(stat *MirrorStat) bool { return stat.Dropped > 0 })
+	if stat.Requests != 2 || stat.Dropped != 1 {
+		t.Fatalf("mirror stat %+v while the shadow is held", stat)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(mirrored) != 2 || mirrored[0] != "POST /a hello" || mirrored[1] != "POST /hold held" {
+		t.Fatalf("mirrored %q", mirrored)
+	}
+}
+
+// tcpEcho returns a tcp target which echoes the data of each connection.
+func tcpEcho(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln
+}
+
+// echoStream sends the bytes through the address and returns the time taken
+// until all of them came back.
+func echoStream(t *testing.T, addr string, size int) time.Duration {
+	t.Helper()
+	start := time.Now()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, io.LimitReader(conn, int64(size)))
+		received <- n
+	}()
+	chunk := bytes.Repeat([]byte("m"), 32<<10)
+	for sent := 0; sent < size; sent += len(chunk) {
+		_, err = conn.Write(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := <-received; n != int64(size) {
+		t.Fatalf("%d bytes echoed, want %d", n, size)
+	}
+
+	return time.Since(start)
+}
+
+func TestMirrorTcp(t *testing.T) {
+	target := tcpEcho(t)
+	defer target.Close()
+
+	// the shadow reading the stream gets all of it
+	var received atomic.Int64
+	shadow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	go func() {
+		for {
+			conn, err := shadow.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				n, _ := io.Copy(io.Discard, conn)
+				received.Add(n)
+			}()
+		}
+	}()
+
+	// the slow shadow never reads
+	var mutex sync.Mutex
+	slowConns := make([]net.Conn, 0)
+	slow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	go func() {
+		for {
+			conn, err := slow.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			slowConns = append(slowConns, conn)
+			mutex.Unlock()
+		}
+	}()
+	defer func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for i := 0; i < len(slowConns); i++ {
+			slowConns[i].Close()
+		}
+	}()
+
+	items := []struct {
+		name    string
+		shadow  string
+		size    int
+		dropped uint64
+	}{
+		{"shadow", shadow.Addr().String(), 1 << 20, 0},
+		// more than the queued chunks and the socket buffers hold
+		{"slow shadow", slow.Addr().String(), 32 << 20, 1},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		addr := freeAddr(t)
+		server := &Server{Routes: []Route{{
+			Mode:    ModeTcp,
+			Address: addr,
+			Target:  target.Addr().String(),
+			Mirror:  &Mirror{Id: "m1", Target: item.shadow, Percent: 100, Timeout: 5 * time.Second},
+		}}}
+		err := server.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the primary stream is not slowed down by the shadow
+		if elapsed := echoStream(t, addr, item.size); elapsed > 4*time.Second {
+			t.Fatalf("%s: echoed in %v", item.name, elapsed)
+		}
+		stat := mirrorStat(t, server, func(stat *MirrorStat) bool {
+			return stat.Dropped > 0 || stat.Bytes == uint64(item.size)
+		})
+		server.Stop()
+		if stat.Requests != 1 || stat.Dropped != item.dropped {
+			t.Fatalf("%s: mirror stat %+v", item.name, stat)
+		}
+		if item.dropped == 0 && stat.Bytes != uint64(item.size) {
+			t.Fatalf("%s: %d bytes mirrored, want %d", item.name, stat.Bytes, item.size)
+		}
+	}
+	for i := 0; i < 100 && received.Load() < 1<<20; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := received.Load(); n != 1<<20 {
+		t.Fatalf("the shadow received %d bytes, want %d", n, 1<<20)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+const (
+	defaultRequestIdHeader = "X-Request-Id"
+	maxRequestIdLength     = 128
+)
+
+// RequestId generates, or takes from the incoming request when trusted, the
+// id of each http request, which is forwarded to the target and returned to the client.
+type RequestId struct {
+	Enable bool
+	Header string
+	Trust  bool
+}
+
+func (s *RequestId) header() string {
+	if len(s.Header) > 0 {
+		return s.Header
+	}
+
+	return defaultRequestIdHeader
+}
+
+func (s *RequestId) get(header http.Header, id string) string {
+	if !s.Trust {
+		return id
+	}
+
+	value := header.Get(s.header())
+	if len(value) < 1 || len(value) > maxRequestIdLength {
+		return id
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x21 || value[i] > 0x7e {
+			return id
+		}
+	}
+
+	return value
+}
+
+// accessWriter records the status and size of the response for the access log.
+type accessWriter struct {
+	http.ResponseWriter
+
+	status int
+	size   int64
+}
+
+func (s *accessWriter) WriteHeader(code int) {
+	if s.status == 0 && code >= http.StatusOK {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *accessWriter) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(data)
+	s.size += int64(n)
+
+	return n, err
+}
+
+func (s *accessWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,339 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+const (
+	defaultResolveRefresh = 30 * time.Second
+	resolveTimeout        = 5 * time.Second
+)
+
+// Resolver looks up the addresses of host names, it is satisfied by *net.Resolver
+// whose Dial may send the queries to another name server. The server uses
+// net.DefaultResolver when it is nil.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Resolve is the resolution policy of the targets given by host name, the
+// target is a SRV record name (without port) when Srv is true.
+type Resolve struct {
+	Refresh time.Duration
+	Srv     bool
+}
+
+func (s *Resolve) refresh() time.Duration {
+	if s.Refresh > 0 {
+		return s.Refresh
+	}
+
+	return defaultResolveRefresh
+}
+
+type Resolution struct {
+	Target      string          `json:"target" note:"目标"`
+	Addresses   []string        `json:"addresses" note:"解析得到的地址"`
+	Error       string          `json:"error" note:"最近一次解析的错误，成功时为空"`
+	ResolveTime *gtype.DateTime `json:"resolveTime" note:"最近一次解析的时间"`
+	ExpireTime  *gtype.DateTime `json:"expireTime" note:"重新解析的时间"`
+}
+
+type resolution struct {
+	sync.Mutex
+
+	target    string
+	srv       bool
+	addresses []string
+	err       error
+	resolved  bool
+	resolveAt time.Time
+	expireAt  time.Time
+	next      atomic.Uint64
+	refresh   atomic.Int64
+	ready     chan struct{} // closed once the first lookup is done
+	stop      chan struct{}
+}
+
+func (s *resolution) result() *Resolution {
+	s.Lock()
+	defer s.Unlock()
+
+	resolveTime := gtype.DateTime(s.resolveAt)
+	expireTime := gtype.DateTime(s.expireAt)
+	result := &Resolution{
+		Target:      s.target,
+		Addresses:   make([]string, 0, len(s.addresses)),
+		ResolveTime: &resolveTime,
+		ExpireTime:  &expireTime,
+	}
+	result.Addresses = append(result.Addresses, s.addresses...)
+	if s.err != nil {
+		result.Error = s.err.Error()
+	}
+
+	return result
+}
+
+// resolver looks up the addresses of the targets in the background every
+// refresh interval, the requests take the last addresses without waiting but
+// for the first lookup; the last addresses are kept when a lookup fails.
+type resolver struct {
+	sync.Mutex
+
+	lookup Resolver
+	items  map[string]*resolution
+	closed bool
+}
+
+func newResolver(lookup Resolver) *resolver {
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+
+	return &resolver{
+		lookup: lookup,
+		items:  make(map[string]*resolution),
+	}
+}
+
+// resolvable reports whether the target is given by host name or SRV record.
+func resolvable(target string, policy *Resolve) bool {
+	if strings.HasPrefix(target, UnixPrefix) {
+		return false
+	}
+
+	return policy.Srv || hostIP(target) == nil
+}
+
+func resolveKey(target string, policy *Resolve) string {
+	if policy.Srv {
+		return "srv:" + target
+	}
+
+	return target
+}
+
+// watch returns the resolution of the target, which is refreshed from then on
+// until the resolver is closed or the target is not retained.
+func (s *resolver) watch(target string, policy *Resolve) *resolution {
+	s.Lock()
+	defer s.Unlock()
+
+	key := resolveKey(target, policy)
+	item, ok := s.items[key]
+	if !ok {
+		item = &resolution{
+			target: target,
+			srv:    policy.Srv,
+			ready:  make(chan struct{}),
+			stop:   make(chan struct{}),
+		}
+		s.items[key] = item
+		if s.closed {
+			close(item.ready)
+		} else {
+			go s.run(item)
+		}
+	}
+	item.refresh.Store(int64(policy.refresh()))
+
+	return item
+}
+
+func (s *resolver) run(item *resolution) {
+	for {
+		s.update(item)
+
+		timer := time.NewTimer(time.Duration(item.refresh.Load()))
+		select {
+		case <-item.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *resolver) update(item *resolution) {
+	addresses, err := s.lookupTarget(context.Background(), item.target, item.srv)
+	now := time.Now()
+
+	item.Lock()
+	item.err = err
+	item.resolveAt = now
+	item.expireAt = now.Add(time.Duration(item.refresh.Load()))
+	if err == nil {
+		item.addresses = addresses
+	}
+	first := !item.resolved
+	item.resolved = true
+	item.Unlock()
+
+	if first {
+		close(item.ready)
+	}
+}
+
+// resolve returns the addresses of the target in rotating order, so that new
+// connections are balanced across them; ip and unix socket targets are returned as they are.
+func (s *resolver) resolve(ctx context.Context, target string, policy *Resolve) []string {
+	if !resolvable(target, policy) {
+		return []string{target}
+	}
+
+	item := s.watch(target, policy)
+	select {
+	case <-item.ready:
+	case <-ctx.Done():
+	}
+
+	return item.rotate()
+}
+
+// cached returns the addresses of the target like resolve, without waiting for
+// the first lookup.
+func (s *resolver) cached(target string, policy *Resolve) []string {
+	if !resolvable(target, policy) {
+		return []string{target}
+	}
+
+	return s.watch(target, policy).rotate()
+}
+
+func (s *resolution) rotate() []string {
+	s.Lock()
+	addresses := s.addresses
+	s.Unlock()
+
+	count := len(addresses)
+	if count < 1 {
+		// let the dialer report the error of the lookup
+		return []string{s.target}
+	}
+	start := int((s.next.Add(1) - 1) % uint64(count))
+	ordered := make([]string, 0, count)
+	ordered = append(ordered, addresses[start:]...)
+
+	return append(ordered, addresses[:start]...)
+}
+
+// retain stops refreshing the targets which are not in the routes any more.
+func (s *resolver) retain(routes []*Route) {
+	keys := make(map[string]bool)
+	count := len(routes)
+	for i := 0; i < count; i++ {
+		targets := routes[i].targets()
+		for j := 0; j < len(targets); j++ {
+			if len(targets[j]) > 0 && resolvable(targets[j], &routes[i].Resolve) {
+				keys[resolveKey(targets[j], &routes[i].Resolve)] = true
+				s.watch(targets[j], &routes[i].Resolve)
+			}
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for key, item := range s.items {
+		if !keys[key] {
+			close(item.stop)
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *resolver) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, item := range s.items {
+		close(item.stop)
+	}
+}
+
+func (s *resolver) lookupTarget(ctx context.Context, target string, srv bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if !srv {
+		return s.lookupHost(ctx, host, port)
+	}
+
+	_, records, err := s.lookup.LookupSRV(ctx, "", "", host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0)
+	count := len(records)
+	for i := 0; i < count; i++ {
+		record := records[i]
+		items, e := s.lookupHost(ctx, strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		if e != nil {
+			err = e
+			continue
+		}
+		addresses = append(addresses, items...)
+	}
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+
+	return nil, err
+}
+
+func (s *resolver) lookupHost(ctx context.Context, host, port string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{net.JoinHostPort(host, port)}, nil
+	}
+
+	ips, err := s.lookup.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(ips))
+	count := len(ips)
+	for i := 0; i < count; i++ {
+		addresses = append(addresses, net.JoinHostPort(ips[i], port))
+	}
+
+	return addresses, nil
+}
+
+func (s *resolver) list() []*Resolution {
+	s.Lock()
+	items := make([]*resolution, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	s.Unlock()
+
+	results := make([]*Resolution, 0, len(items))
+	count := len(items)
+	for i := 0; i < count; i++ {
+		results = append(results, items[i].result())
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Target < results[j].Target
+	})
+
+	return results
+}
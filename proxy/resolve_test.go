@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeSrv  = 33
+)
+
+// dnsStandIn answers the A, AAAA and SRV questions of its records over udp,
+// the records may be changed while it is serving.
+type dnsStandIn struct {
+	conn net.PacketConn
+
+	mutex sync.Mutex
+	hosts map[string][]net.IP
+	srv   map[string][]*net.SRV
+}
+
+func newDnsStandIn(t *testing.T) *dnsStandIn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &dnsStandIn{
+		conn:  conn,
+		hosts: make(map[string][]net.IP),
+		srv:   make(map[string][]*net.SRV),
+	}
+	go instance.serve()
+
+	return instance
+}
+
+// resolver returns the resolver sending all its queries to the stand-in.
+func (s *dnsStandIn) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStandIn) setHost(name string, ips ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	items := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(ips); i++ {
+		items = append(items, net.ParseIP(ips[i]))
+	}
+	s.hosts[name+"."] = items
+}
+
+func (s *dnsStandIn) setSrv(name string, records ...*net.SRV) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.srv[name+"."] = records
+}
+
+func (s *dnsStandIn) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply := s.answer(buf[:n])
+		if reply != nil {
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// answer returns the response of the query, which holds one question.
+func (s *dnsStandIn) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	labels := make([]string, 0)
+	offset := 12
+	for offset < len(query) && query[offset] != 0 {
+		size := int(query[offset])
+		if offset+1+size > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+size]))
+		offset += 1 + size
+	}
+	offset += 5
+	if offset > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(query[offset-4:])
+
+	answers := make([][]byte, 0)
+	s.mutex.Lock()
+	ips, isHost := s.hosts[name]
+	records, isSrv := s.srv[name]
+	for i := 0; i < len(ips); i++ {
+		if qtype == dnsTypeA && ips[i].To4() != nil {
+			answers = append(answers, ips[i].To4())
+		} else if qtype == dnsTypeAAAA && ips[i].To4() == nil {
+			answers = append(answers, ips[i].To16())
+		}
+	}
+	for i := 0; i < len(records) && qtype == dnsTypeSrv; i++ {
+		data := binary.BigEndian.AppendUint16(nil, records[i].Priority)
+		data = binary.BigEndian.AppendUint16(data, records[i].Weight)
+		data = binary.BigEndian.AppendUint16(data, records[i].Port)
+		parts := strings.Split(strings.TrimSuffix(records[i].Target, "."), ".")
+		for j := 0; j < len(parts); j++ {
+			data = append(data, byte(len(parts[j])))
+			data = append(data, parts[j]...)
+		}
+		answers = append(answers, append(data, 0))
+	}
+	s.mutex.Unlock()
+
+	reply := make([]byte, 0, 512)
+	reply = append(reply, query[0], query[1])
+	if isHost || isSrv {
+		reply = append(reply, 0x81, 0x80)
+	} else {
+		// no such name
+		reply = append(reply, 0x81, 0x83)
+	}
+	reply = binary.BigEndian.AppendUint16(reply, 1)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(len(answers)))
+	reply = append(reply, 0, 0, 0, 0)
+	reply = append(reply, query[12:offset]...)
+	for i := 0; i < len(answers); i++ {
+		// the name points to the one of the question
+		reply = append(reply, 0xc0, 12)
+		reply = binary.BigEndian.AppendUint16(reply, qtype)
+		reply = binary.BigEndian.AppendUint16(reply, 1)
+		reply = binary.BigEndian.AppendUint32(reply, 60)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(answers[i])))
+		reply = append(reply, answers[i]...)
+	}
+
+	return reply
+}
+
+func (s *dnsStandIn) close() {
+	s.conn.Close()
+}
+
+func resolved(r *resolver, target string, policy *Resolve) string {
+	addresses := r.resolve(context.Background(), target, policy)
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, " ")
+}
+
+func TestResolveReresolvesRecords(t *testing.T) {
+	dns := newDnsStandIn(t)
+	defer dns.close()
+	dns.setHost("app.grps.test", "10.0.0.1", "fd00::1")
+	dns.setHost("b.grps.test", "10.0.1.1")
+	dns.setHost("c.grps.test", "10.0.1.2")
+	dns.setSrv("_http._tcp.grps.test",
+		&net.SRV{Target: "b.grps.test.", Port: 8081},
+		&net.SRV{Target: "c.grps.test.", Port: 8082})
+
+	r := newResolver(dns.resolver())
+	defer r.close()
+	host := &Resolve{Refresh: 100 * time.Millisecond}
+	srv := &Resolve{Refresh: 100 * time.Millisecond, Srv: true}
+	items := []struct {
+		target string
+		policy *Resolve
+		want   string
+	}{
+		{"app.grps.test:80", host, "10.0.0.1:80 [fd00::1]:80"},
+		{"_http._tcp.grps.test", srv, "10.0.1.1:8081 10.0.1.2:8082"},
+		{"10.0.0.9:80", host, "10.0.0.9:80"},
+		{"[::1]:80", host, "[::1]:80"},
+	}
+	for i := 0; i < len(items); i++ {
+		got := resolved(r, items[i].target, items[i].policy)
+		if got != items[i].want {
+			t.Fatalf("%s resolved to %q, want %q", items[i].target, got, items[i].want)
+		}
+	}
+
+	// the cached addresses are kept until the refresh elapsed
+	dns.setHost("app.grps.test", "fd00::2")
+	dns.setHost("c.grps.test", "10.0.1.3")
+	dns.setHost("d.grps.test", "10.0.1.4")
+	dns.setSrv("_http._tcp.grps.test",
+		&net.SRV{Target: "c.grps.test.", Port: 8082},
+		&net.SRV{Target: "d.grps.test.", Port: 8083})
+	if got := resolved(r, "app.grps.test:80", host); got != items[0].want {
+		t.Fatalf("resolved to %q before the refresh, want %q", got, items[0].want)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := resolved(r, "app.grps.test:80", host); got != "[fd00::2]:80" {
+		t.Fatalf("re-resolved to %q, want %q", got, "[fd00::2]:80")
+	}
+	if got := resolved(r, "_http._tcp.grps.test", srv); got != "10.0.1.3:8082 10.0.1.4:8083" {
+		t.Fatalf("srv re-resolved to %q, want %q", got, "10.0.1.3:8082 10.0.1.4:8083")
+	}
+
+	// a failed lookup keeps the last addresses
+	dns.mutex.Lock()
+	delete(dns.hosts, "app.grps.test.")
+	dns.mutex.Unlock()
+	time.Sleep(150 * time.Millisecond)
+	if got := resolved(r, "app.grps.test:80", host); got != "[fd00::2]:80" {
+		t.Fatalf("resolved to %q after a failed lookup, want %q", got, "[fd00::2]:80")
+	}
+	results := r.list()
+	if len(results) != 2 || results[1].Target != "app.grps.test:80" || len(results[1].Error) < 1 {
+		t.Fatalf("the failed lookup is not reported in %d resolution(s)", len(results))
+	}
+}
+
+// slowLookup answers the host lookups with its addresses once released.
+type slowLookup struct {
+	net.Resolver
+
+	lookups atomic.Int32
+	release chan struct{}
+	mutex   sync.Mutex
+	ips     []string
+}
+
+func (s *slowLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if s.lookups.Add(1) > 1 {
+		<-s.release
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ips, nil
+}
+
+func TestResolveRefreshesInBackground(t *testing.T) {
+	lookup := &slowLookup{release: make(chan struct{}), ips: []string{"10.0.0.1"}}
+	r := newResolver(lookup)
+	defer r.close()
+	policy := &Resolve{Refresh: 50 * time.Millisecond}
+	if got := resolved(r, "app.grps.test:80", policy); got != "10.0.0.1:80" {
+		t.Fatalf("resolved to %q, want %q", got, "10.0.0.1:80")
+	}
+
+	// the requests take the last addresses while the refresh is waiting
+	lookup.mutex.Lock()
+	lookup.ips = []string{"10.0.0.2"}
+	lookup.mutex.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if got := r.resolve(ctx, "app.grps.test:80", policy); ctx.Err() != nil || len(got) != 1 || got[0] != "10.0.0.1:80" {
+		t.Fatalf("resolved to %v during the refresh: %v", got, ctx.Err())
+	}
+	if n := lookup.lookups.Load(); n != 2 {
+		t.Fatalf("%d lookup(s), want the one in the background", n)
+	}
+
+	close(lookup.release)
+	deadline := time.Now().Add(time.Second)
+	for resolved(r, "app.grps.test:80", policy) != "10.0.0.2:80" {
+		if time.Now().After(deadline) {
+			t.Fatal("the refreshed address is not taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
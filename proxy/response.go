@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Redirect answers the request with a redirection, the location may contain
+// the placeholders {scheme}, {host}, {hostname}, {port}, {path}, {query} and {uri}.
+type Redirect struct {
+	Code     int
+	Location string
+}
+
+func (s *Redirect) serve(w http.ResponseWriter, r *http.Request) {
+	code := s.Code
+	if code == 0 {
+		code = http.StatusFound
+	}
+
+	http.Redirect(w, r, requestReplacer(r).Replace(s.Location), code)
+}
+
+// Static answers the request with a fixed response, the body is read from
+// the file on each request when the file is specified.
+type Static struct {
+	Code    int
+	Headers http.Header
+	Body    string
+	File    string
+}
+
+func (s *Static) serve(w http.ResponseWriter, r *http.Request) error {
+	body := []byte(s.Body)
+	if len(s.File) > 0 {
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return err
+		}
+		body = data
+	}
+
+	header := w.Header()
+	for name, values := range s.Headers {
+		header[name] = values
+	}
+	if len(body) > 0 {
+		if len(header.Get("Content-Type")) < 1 {
+			contentType := ""
+			if len(s.File) > 0 {
+				contentType = mime.TypeByExtension(filepath.Ext(s.File))
+			}
+			if len(contentType) < 1 {
+				contentType = http.DetectContentType(body)
+			}
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	code := s.Code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+
+	return nil
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+func requestReplacer(r *http.Request) *strings.Replacer {
+	port := ""
+	_, p, err := net.SplitHostPort(r.Host)
+	if err == nil {
+		port = p
+	}
+
+	return strings.NewReplacer(
+		"{scheme}", requestScheme(r),
+		"{host}", r.Host,
+		"{hostname}", hostName(r.Host),
+		"{port}", port,
+		"{path}", r.URL.EscapedPath(),
+		"{query}", r.URL.RawQuery,
+		"{uri}", r.URL.RequestURI(),
+	)
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RetryBackendNext = "next"
+	RetryBackendSame = "same"
+
+	RetryOnConnect = "connect"
+	RetryOn502     = "502"
+	RetryOn503     = "503"
+	RetryOn504     = "504"
+	RetryOnReset   = "reset"
+)
+
+const (
+	defaultRetryBudget = 20
+	retryBudgetWindow  = 10 * time.Second
+	retryBudgetMin     = 3
+)
+
+// Retry is the retry policy of a route, connect failures are retried for
+// both tcp and http routes, the other conditions apply to idempotent http
+// requests without body. A gRPC call is retried only when it reached no target,
+// as the target may have run it even though the call failed.
+type Retry struct {
+	Enable     bool
+	Attempts   int
+	Backend    string
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	RetryOn    []string
+	Budget     int
+}
+
+func (s *Retry) on(condition string) bool {
+	if !s.Enable {
+		return false
+	}
+	if len(s.RetryOn) < 1 {
+		return condition == RetryOnConnect || condition == RetryOnReset
+	}
+
+	count := len(s.RetryOn)
+	for i := 0; i < count; i++ {
+		if s.RetryOn[i] == condition {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Retry) attempts(targets int) int {
+	if s.Attempts > 0 {
+		return s.Attempts
+	}
+	if targets < 2 {
+		return 2
+	}
+
+	return targets
+}
+
+func (s *Retry) budget() int {
+	if s.Budget > 0 {
+		return s.Budget
+	}
+
+	return defaultRetryBudget
+}
+
+// wait sleeps the backoff before the retry, which doubles on every retry,
+// false is returned when the context is done in the meantime.
+func (s *Retry) wait(ctx context.Context, retry int) bool {
+	delay := s.Backoff
+	for i := 1; i < retry && delay > 0; i++ {
+		delay *= 2
+		if s.MaxBackoff > 0 && delay >= s.MaxBackoff {
+			break
+		}
+	}
+	if s.MaxBackoff > 0 && delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryable reports whether the http request is sent again for the response
+// or error, connected tells whether the error happened after connecting.
+func (s *Retry) retryable(resp *http.Response, err error, connected bool) bool {
+	if err == nil {
+		return s.on(strconv.Itoa(resp.StatusCode))
+	}
+	if !connected || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return s.on(RetryOn504)
+	}
+
+	return s.on(RetryOnReset)
+}
+
+// replayable reports whether the request may be sent more than once.
+func replayable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody {
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// unsentBody is the request body which is kept for the next target as long as
+// none of it has been read, when the connect failed.
+type unsentBody struct {
+	io.ReadCloser
+
+	read atomic.Bool
+}
+
+func (s *unsentBody) Read(p []byte) (int, error) {
+	s.read.Store(true)
+
+	return s.ReadCloser.Read(p)
+}
+
+func (s *unsentBody) Close() error {
+	if !s.read.Load() {
+		return nil
+	}
+
+	return s.ReadCloser.Close()
+}
+
+// started reports whether the body has been read, nil is the one of a request without body.
+func (s *unsentBody) started() bool {
+	if s == nil {
+		return false
+	}
+
+	return s.read.Load()
+}
+
+// retryBudget limits the retries of a route to a percentage of the requests
+// within a window, a few retries are always allowed.
+type retryBudget struct {
+	sync.Mutex
+
+	start    time.Time
+	requests int
+	retries  int
+}
+
+func (s *retryBudget) roll(now time.Time) {
+	if now.Sub(s.start) < retryBudgetWindow {
+		return
+	}
+
+	s.start = now
+	s.requests = 0
+	s.retries = 0
+}
+
+func (s *retryBudget) request() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.roll(time.Now())
+	s.requests++
+}
+
+func (s *retryBudget) allow(percent int) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	s.roll(time.Now())
+	limit := s.requests * percent / 100
+	if limit < retryBudgetMin {
+		limit = retryBudgetMin
+	}
+	if s.retries >= limit {
+		return false
+	}
+	s.retries++
+
+	return true
+}
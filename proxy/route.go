@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	ProtocolTcp = "tcp"
+	ProtocolUdp = "udp"
+
+	ModeTcp = "tcp"
+
+	BalanceFailover   = "failover"
+	BalanceRoundRobin = "roundRobin"
+)
+
+type Route struct {
+	Name         string
+	Protocol     string
+	Mode         string
+	IsTls        bool
+	Termination  *Termination
+	Http2        bool
+	Address      string
+	V6Only       bool
+	Socket       *Socket
+	Domain       string
+	Path         string
+	Target       string
+	Version      int
+	TargetHttp2  bool
+	SpareTargets []string
+	Balance      string
+	Resolve      Resolve
+	Health       Health
+	Retry        Retry
+	Redirect     *Redirect
+	Static       *Static
+	Cache        *Cache
+	Compress     *Compress
+	Auth         *Auth
+	Jwt          *Jwt
+	ClientCert   *ClientCert
+	Cors         *Cors
+	Mirror       *Mirror
+	Throttle     *Throttle
+	Maintenance  *Maintenance
+	ErrorPages   map[int]*ErrorPage
+	Headers      Headers
+	RequestId    RequestId
+	AccessLog    bool
+	Timeout      Timeout
+	Limits       Limits
+	WebSocket    WebSocket
+
+	// the throttles of the server and its clients apply to the whole listener
+	ServerThrottle *Throttle
+	ClientThrottle *Throttle
+
+	budget   *retryBudget
+	next     *atomic.Uint64
+	mirror   *mirror
+	throttle *throttle
+}
+
+func (s *Route) network() string {
+	if s.Protocol == ProtocolUdp {
+		return ProtocolUdp
+	}
+
+	return ProtocolTcp
+}
+
+func (s *Route) targets() []string {
+	targets := make([]string, 0, len(s.SpareTargets)+1)
+	targets = append(targets, s.Target)
+	targets = append(targets, s.SpareTargets...)
+
+	return targets
+}
+
+// balance returns the target which a new connection starts from.
+func (s *Route) balance() string {
+	if s.Balance != BalanceRoundRobin || s.next == nil {
+		return s.Target
+	}
+
+	targets := s.targets()
+	index := (s.next.Add(1) - 1) % uint64(len(targets))
+
+	return targets[index]
+}
+
+// targetsFrom returns the targets in order starting from the given one.
+func (s *Route) targetsFrom(first string) []string {
+	targets := s.targets()
+	count := len(targets)
+	for i := 1; i < count; i++ {
+		if targets[i] == first {
+			ordered := make([]string, 0, count)
+			ordered = append(ordered, targets[i:]...)
+			return append(ordered, targets[:i]...)
+		}
+	}
+
+	return targets
+}
+
+type routeGroup struct {
+	network string
+	address string
+	isTls   bool
+	plain   bool
+	v6Only  bool
+	socket  *Socket
+	routes  []*Route
+
+	termination *Termination
+}
+
+func groupRoutes(routes []Route) []*routeGroup {
+	groups := make([]*routeGroup, 0)
+	count := len(routes)
+	for i := 0; i < count; i++ {
+		route := routes[i]
+
+		var group *routeGroup = nil
+		for j := 0; j < len(groups); j++ {
+			if groups[j].address == route.Address && groups[j].network == route.network() {
+				group = groups[j]
+				break
+			}
+		}
+		if group == nil {
+			group = &routeGroup{
+				network: route.network(),
+				address: route.Address,
+				isTls:   route.IsTls && route.Mode != ModeTcp && route.Termination == nil,
+				plain:   route.Mode == ModeTcp,
+				v6Only:  route.V6Only,
+				socket:  route.Socket,
+				routes:  make([]*Route, 0),
+
+				termination: route.Termination,
+			}
+			groups = append(groups, group)
+		}
+		if group.isTls {
+			route.Path = ""
+		}
+		if group.network == ProtocolUdp || group.plain {
+			// there is neither domain nor path to route by, the first route serves all
+			route.Domain = ""
+			route.Path = ""
+		}
+
+		route.budget = &retryBudget{}
+		route.next = &atomic.Uint64{}
+		group.routes = append(group.routes, &route)
+	}
+
+	return groups
+}
+
+func allRoutes(groups []*routeGroup) []*Route {
+	routes := make([]*Route, 0)
+	count := len(groups)
+	for i := 0; i < count; i++ {
+		routes = append(routes, groups[i].routes...)
+	}
+
+	return routes
+}
+
+// same reports whether the route has the settings of the other one, whatever
+// the limits of the throttles which change at runtime.
+func (s *Route) same(other *Route) bool {
+	if !sameThrottle(s.Throttle, other.Throttle) || !sameThrottle(s.ServerThrottle, other.ServerThrottle) ||
+		!sameThrottle(s.ClientThrottle, other.ClientThrottle) {
+		return false
+	}
+
+	a, b := s.settings(), other.settings()
+
+	return reflect.DeepEqual(&a, &b)
+}
+
+// settings returns the copy of the route without its state and throttles.
+func (s *Route) settings() Route {
+	route := *s
+	route.Throttle = nil
+	route.ServerThrottle = nil
+	route.ClientThrottle = nil
+	route.budget = nil
+	route.next = nil
+	route.mirror = nil
+	route.throttle = nil
+
+	return route
+}
+
+// keepRoutes replaces the routes of the groups with the running ones of the same
+// settings, so that the state of them (connections, caches, retry budgets,
+// balancing and mirrors) is kept; the throttles of a kept route are taken
+// from the new one for their current limits.
+func keepRoutes(groups []*routeGroup, running []*Route) {
+	used := make([]bool, len(running))
+	for i := 0; i < len(groups); i++ {
+		routes := groups[i].routes
+		for j := 0; j < len(routes); j++ {
+			for k := 0; k < len(running); k++ {
+				if used[k] || !running[k].same(routes[j]) {
+					continue
+				}
+				used[k] = true
+				running[k].Throttle = routes[j].Throttle
+				running[k].ServerThrottle = routes[j].ServerThrottle
+				running[k].ClientThrottle = routes[j].ClientThrottle
+				routes[j] = running[k]
+				break
+			}
+		}
+	}
+}
+
+// same reports whether the listener of the group is able to serve the other
+// one, which is when they differ in nothing but the routes.
+func (s *routeGroup) same(other *routeGroup) bool {
+	if s.network != other.network || s.address != other.address ||
+		s.isTls != other.isTls || s.plain != other.plain || s.v6Only != other.v6Only {
+		return false
+	}
+	if !reflect.DeepEqual(s.socket, other.socket) || !reflect.DeepEqual(s.termination, other.termination) {
+		return false
+	}
+	if len(s.routes) < 1 || len(other.routes) < 1 {
+		return len(s.routes) == len(other.routes)
+	}
+
+	// the listener takes these settings from the first route
+	a, b := s.routes[0], other.routes[0]
+	if a.Http2 != b.Http2 || a.Timeout.ReadHeader != b.Timeout.ReadHeader ||
+		a.Timeout.KeepAlive != b.Timeout.KeepAlive || a.Limits.MaxIncomplete != b.Limits.MaxIncomplete {
+		return false
+	}
+	if maxHeaderBytes(s.routes) != maxHeaderBytes(other.routes) {
+		return false
+	}
+
+	return sameThrottle(a.ServerThrottle, b.ServerThrottle) && sameThrottle(a.ClientThrottle, b.ClientThrottle)
+}
+
+// sameThrottle reports whether the throttles are the same one, whatever the
+// limits of them which change at runtime.
+func sameThrottle(a, b *Throttle) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Id == b.Id
+}
+
+// listenNetwork returns the network to listen on, an ipv6 wildcard address
+// accepts ipv4 connections as well unless the group is ipv6 only.
+func (s *routeGroup) listenNetwork() string {
+	if s.v6Only {
+		return s.network + "6"
+	}
+
+	return s.network
+}
+
+// matchRoute returns the index of the route serving the domain and path,
+// routes with a domain take precedence over the default one (empty domain)
+// and a longer path prefix takes precedence over a shorter one.
+func matchRoute(routes []*Route, domain, path string) int {
+	index := -1
+	score := -1
+	count := len(routes)
+	for i := 0; i < count; i++ {
+		route := routes[i]
+		if len(route.Domain) > 0 && !strings.EqualFold(route.Domain, domain) {
+			continue
+		}
+		if !strings.HasPrefix(path, route.Path) {
+			continue
+		}
+
+		value := len(route.Path)
+		if len(route.Domain) > 0 {
+			value += 1 << 16
+		}
+		if value > score {
+			index = i
+			score = value
+		}
+	}
+
+	return index
+}
+
+// hostIP returns the ip address of the host:port address without the zone
+// of a scoped ipv6 address, or nil when the host is not an ip address.
+func hostIP(address string) net.IP {
+	host := hostName(address)
+	index := strings.IndexByte(host, '%')
+	if index >= 0 {
+		host = host[:index]
+	}
+
+	return net.ParseIP(host)
+}
+
+func hostName(host string) string {
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return strings.Trim(host, "[]")
+	}
+
+	return name
+}
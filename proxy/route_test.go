@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHostOfAddress(t *testing.T) {
+	items := []struct {
+		address string
+		name    string
+		ip      string
+	}{
+		{"127.0.0.1:80", "127.0.0.1", "127.0.0.1"},
+		{"[::]:80", "::", "::"},
+		{"[::1]:443", "::1", "::1"},
+		{"[fe80::1%eth0]:8080", "fe80::1%eth0", "fe80::1"},
+		{"[::1]", "::1", "::1"},
+		{"::1", "::1", "::1"},
+		{"fe80::1%eth0", "fe80::1%eth0", "fe80::1"},
+		{"example.com:80", "example.com", ""},
+		{"example.com", "example.com", ""},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		name := hostName(item.address)
+		if name != item.name {
+			t.Errorf("host name of %q is %q, want %q", item.address, name, item.name)
+		}
+		ip := hostIP(item.address)
+		if len(item.ip) < 1 {
+			if ip != nil {
+				t.Errorf("host ip of %q is %v, want nil", item.address, ip)
+			}
+		} else if !ip.Equal(net.ParseIP(item.ip)) {
+			t.Errorf("host ip of %q is %v, want %s", item.address, ip, item.ip)
+		}
+	}
+}
+
+func TestHostOfJoinedAddress(t *testing.T) {
+	hosts := []string{"::", "::1", "fe80::1%eth0", "10.0.0.1", "example.com"}
+	for i := 0; i < len(hosts); i++ {
+		address := net.JoinHostPort(hosts[i], "8080")
+		name := hostName(address)
+		if name != hosts[i] {
+			t.Errorf("host name of %q is %q, want %q", address, name, hosts[i])
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/csby/gwsf/gtype"
+)
+
+type Server struct {
+	gtype.Base
+
+	Routes           []Route
+	StatusChanged    func(status Status)
+	OnConnected      func(link Link)
+	OnDisconnected   func(link Link)
+	OnBackendChanged func(backend Backend)
+	Resolver         Resolver
+
+	mutex     sync.RWMutex
+	status    Status
+	startTime *gtype.DateTime
+	listeners []listener
+	health    *health
+	resolver  *resolver
+	webSocket *webSocketCounter
+	limits    *limitCounter
+	throttles *throttleSet
+}
+
+func (s *Server) Start() error {
+	err := s.start()
+	if err != nil {
+		return err
+	}
+	s.statusChanged(StatusRunning)
+
+	return nil
+}
+
+func (s *Server) Stop() error {
+	err := s.stop()
+	if err != nil {
+		return err
+	}
+	s.statusChanged(StatusStopped)
+
+	return nil
+}
+
+func (s *Server) Restart() error {
+	if s.stop() == nil {
+		s.statusChanged(StatusStopped)
+	}
+
+	return s.Start()
+}
+
+func (s *Server) Result() *Result {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := &Result{
+		Status:    s.status,
+		StartTime: s.startTime,
+		Backends:  make([]*Backend, 0),
+
+		Resolutions: make([]*Resolution, 0),
+		WebSocket:   &WebSocketStat{},
+		Limits:      &LimitStat{},
+	}
+	if s.health != nil {
+		result.Backends = s.health.list()
+	}
+	if s.resolver != nil {
+		result.Resolutions = s.resolver.list()
+	}
+	if s.webSocket != nil {
+		result.WebSocket = s.webSocket.stat()
+	}
+	if s.limits != nil {
+		result.Limits = s.limits.stat()
+	}
+
+	return result
+}
+
+// CacheStats returns the statistics of the response caches of the running routes.
+func (s *Server) CacheStats() []*CacheStat {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := make([]*CacheStat, 0)
+	count := len(s.listeners)
+	for i := 0; i < count; i++ {
+		hl, ok := s.listeners[i].(*httpListener)
+		if !ok {
+			continue
+		}
+		group, routes := hl.table()
+		for j := 0; j < len(routes); j++ {
+			cache := routes[j].cache
+			if cache != nil {
+				stats = append(stats, cache.stat(group.address))
+			}
+		}
+	}
+
+	return stats
+}
+
+// MirrorStats returns the statistics of the traffic mirrors of the running routes.
+func (s *Server) MirrorStats() []*MirrorStat {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := make([]*MirrorStat, 0)
+	count := len(s.listeners)
+	for i := 0; i < count; i++ {
+		group := s.listeners[i].current()
+		for j := 0; j < len(group.routes); j++ {
+			m := group.routes[j].mirror
+			if m != nil {
+				stats = append(stats, m.stat(group.address))
+			}
+		}
+	}
+
+	return stats
+}
+
+// Throttles returns the throttles of the running routes, which are kept
+// after the server stops until it starts again.
+func (s *Server) Throttles() []*ThrottleStat {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.throttles == nil {
+		return make([]*ThrottleStat, 0)
+	}
+
+	return s.throttles.list()
+}
+
+// SetThrottle changes the limits of the throttle of the scope at once, which
+// returns false when no route uses it.
+func (s *Server) SetThrottle(scope string, throttle Throttle) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.throttles == nil {
+		return false
+	}
+
+	return s.throttles.set(scope, &throttle)
+}
+
+// PurgeCache removes the cached responses matching the filter, and returns the count of them.
+func (s *Server) PurgeCache(filter *CacheFilter) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	purged := 0
+	count := len(s.listeners)
+	for i := 0; i < count; i++ {
+		hl, ok := s.listeners[i].(*httpListener)
+		if !ok {
+			continue
+		}
+		_, routes := hl.table()
+		for j := 0; j < len(routes); j++ {
+			cache := routes[j].cache
+			if cache == nil {
+				continue
+			}
+			if filter != nil && len(filter.Id) > 0 && filter.Id != cache.cache.Id {
+				continue
+			}
+			purged += cache.purge(filter)
+		}
+	}
+
+	return purged
+}
+
+func (s *Server) start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.status == StatusRunning {
+		return fmt.Errorf("proxy service is running")
+	}
+
+	groups := groupRoutes(s.Routes)
+	count := len(groups)
+	if count < 1 {
+		return fmt.Errorf("no route")
+	}
+
+	if s.health == nil {
+		s.health = newHealth(s.onBackendChanged)
+	} else {
+		s.health.reset()
+	}
+	s.resolver = newResolver(s.Resolver)
+	s.resolver.retain(allRoutes(groups))
+	if s.webSocket == nil {
+		s.webSocket = &webSocketCounter{}
+	}
+	if s.limits == nil {
+		s.limits = &limitCounter{}
+	}
+	if s.throttles == nil {
+		s.throttles = newThrottleSet()
+	}
+	s.throttles.begin()
+	defer s.throttles.end()
+	listeners := make([]listener, 0)
+	for i := 0; i < count; i++ {
+		ln, err := s.listen(groups[i])
+		if err != nil {
+			closeListeners(listeners)
+			s.resolver.close()
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	for i := 0; i < len(listeners); i++ {
+		go listeners[i].serve()
+	}
+	s.listeners = listeners
+
+	now := gtype.DateTime(time.Now())
+	s.startTime = &now
+	s.status = StatusRunning
+
+	return nil
+}
+
+// Reload applies the routes to the running service, the listeners differing in
+// nothing but the routes keep serving with the new ones while the others are
+// closed or created, so that the connections on them are not dropped.
+func (s *Server) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.status != StatusRunning {
+		return fmt.Errorf("proxy service is not running")
+	}
+
+	groups := groupRoutes(s.Routes)
+	count := len(groups)
+	if count < 1 {
+		return fmt.Errorf("no route")
+	}
+
+	running := make([]*Route, 0)
+	for i := 0; i < len(s.listeners); i++ {
+		running = append(running, s.listeners[i].current().routes...)
+	}
+	keepRoutes(groups, running)
+	s.resolver.retain(allRoutes(groups))
+	s.throttles.begin()
+	defer s.throttles.end()
+	unused := make([]listener, len(s.listeners))
+	copy(unused, s.listeners)
+	kept := make([]listener, count)
+	for i := 0; i < count; i++ {
+		for j := 0; j < len(unused); j++ {
+			if unused[j] != nil && unused[j].current().same(groups[i]) {
+				kept[i] = unused[j]
+				unused[j] = nil
+				break
+			}
+		}
+	}
+	// the addresses of the unused listeners may be listened on again
+	for j := 0; j < len(unused); j++ {
+		if unused[j] != nil {
+			unused[j].close()
+		}
+	}
+
+	var err error = nil
+	listeners := make([]listener, 0, count)
+	for i := 0; i < count; i++ {
+		group := groups[i]
+		if kept[i] != nil {
+			s.prepare(group)
+			s.listenerThrottles(group)
+			kept[i].update(group)
+			listeners = append(listeners, kept[i])
+			continue
+		}
+
+		ln, e := s.listen(group)
+		if e != nil {
+			s.LogError("proxy listen on '", group.address, "' fail: ", e)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		go ln.serve()
+		listeners = append(listeners, ln)
+	}
+	s.listeners = listeners
+
+	return err
+}
+
+// prepare creates the mirrors of the routes of the group and attaches their
+// throttles, the routes kept from the running ones have them already.
+func (s *Server) prepare(group *routeGroup) {
+	if group.network == ProtocolUdp {
+		return
+	}
+
+	for j := 0; j < len(group.routes); j++ {
+		route := group.routes[j]
+		if route.Mirror != nil && route.mirror == nil {
+			route.mirror = newMirror(s, route)
+		}
+		// a kept route is serving, its throttle is the same one
+		throttle := s.throttles.use(ThrottleTarget, route.Throttle)
+		if route.throttle != throttle {
+			route.throttle = throttle
+		}
+	}
+}
+
+// listenerThrottles returns the throttles of the server and its clients, which
+// apply to the whole listener of the group.
+func (s *Server) listenerThrottles(group *routeGroup) (*throttle, *throttle) {
+	if group.network == ProtocolUdp || len(group.routes) < 1 {
+		return nil, nil
+	}
+
+	server := s.throttles.use(ThrottleServer, group.routes[0].ServerThrottle)
+	client := s.throttles.use(ThrottleClient, group.routes[0].ClientThrottle)
+
+	return server, client
+}
+
+// listen creates the listener of the group, which is not serving yet.
+func (s *Server) listen(group *routeGroup) (listener, error) {
+	s.prepare(group)
+	if group.network == ProtocolUdp {
+		conn, err := net.ListenPacket(group.listenNetwork(), group.address)
+		if err != nil {
+			return nil, err
+		}
+		return newUdpListener(s, conn, group), nil
+	}
+
+	var config *tls.Config = nil
+	if group.termination != nil {
+		var err error
+		config, err = group.termination.config()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := listenStream(group)
+	if err != nil {
+		return nil, err
+	}
+	server, client := s.listenerThrottles(group)
+	if server != nil || client != nil {
+		ln = &throttleListener{Listener: ln, server: server, client: client}
+	}
+
+	if group.isTls || group.plain {
+		return newTcpListener(s, ln, group), nil
+	}
+
+	return newHttpListener(s, ln, group, config), nil
+}
+
+func (s *Server) stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.status != StatusRunning {
+		return fmt.Errorf("proxy service is not running")
+	}
+
+	closeListeners(s.listeners)
+	s.resolver.close()
+	s.listeners = nil
+	s.startTime = nil
+	s.status = StatusStopped
+
+	return nil
+}
+
+func (s *Server) statusChanged(status Status) {
+	if s.StatusChanged == nil {
+		return
+	}
+
+	s.StatusChanged(status)
+}
+
+func (s *Server) connected(link Link) {
+	if s.OnConnected == nil {
+		return
+	}
+
+	s.OnConnected(link)
+}
+
+func (s *Server) disconnected(link Link) {
+	if s.OnDisconnected == nil {
+		return
+	}
+
+	s.OnDisconnected(link)
+}
+
+func (s *Server) onBackendChanged(backend Backend) {
+	if backend.Ejected {
+		s.LogInfo("proxy backend '", backend.Address, "' ejected: ", backend.Reason)
+
+		// drop pooled connections so that requests stop reaching the backend
+		s.mutex.RLock()
+		count := len(s.listeners)
+		for i := 0; i < count; i++ {
+			hl, ok := s.listeners[i].(*httpListener)
+			if ok {
+				hl.closeIdleConnections()
+			}
+		}
+		s.mutex.RUnlock()
+	} else {
+		s.LogInfo("proxy backend '", backend.Address, "' restored")
+	}
+
+	if s.OnBackendChanged == nil {
+		return
+	}
+
+	s.OnBackendChanged(backend)
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsListeners(t *testing.T) {
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "a")
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "b")
+	}))
+	defer b.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Address: addr,
+		Target:  a.Listener.Addr().String(),
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	get := func(url string) (string, bool) {
+		t.Helper()
+		reused := false
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = info.Reused
+			},
+		}
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+
+		return string(data), reused
+	}
+	if got, _ := get("http://" + addr + "/"); got != "a" {
+		t.Fatalf("response %q, want %q", got, "a")
+	}
+
+	other := freeAddr(t)
+	server.Routes = []Route{
+		{
+			Address: addr,
+			Target:  b.Listener.Addr().String(),
+		},
+		{
+			Address: other,
+			Target:  a.Listener.Addr().String(),
+		},
+	}
+	err = server.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client connection survives on the kept listener
+	got, reused := get("http://" + addr + "/")
+	if got != "b" || !reused {
+		t.Fatalf("response %q reused %v, want %q on the same connection", got, reused, "b")
+	}
+	if got, _ := get("http://" + other + "/"); got != "a" {
+		t.Fatalf("response of the added listener %q, want %q", got, "a")
+	}
+
+	// a listener setting changed recreates the listener
+	server.Routes = []Route{{
+		Address: addr,
+		Target:  a.Listener.Addr().String(),
+		Http2:   true,
+	}}
+	err = server.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, reused = get("http://" + addr + "/")
+	if got != "a" || reused {
+		t.Fatalf("response %q reused %v, want %q on a new connection", got, reused, "a")
+	}
+	if _, err := http.Get("http://" + other + "/"); err == nil {
+		t.Fatal("the removed listener still accepts")
+	}
+}
+
+func TestReloadKeepsRouteState(t *testing.T) {
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "a")
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "b")
+	}))
+	defer b.Close()
+
+	addr := freeAddr(t)
+	balanced := Route{
+		Address:      addr,
+		Path:         "/",
+		Target:       a.Listener.Addr().String(),
+		SpareTargets: []string{b.Listener.Addr().String()},
+		Balance:      BalanceRoundRobin,
+		Throttle:     &Throttle{Id: "t1", Download: 1 << 20},
+	}
+	server := &Server{Routes: []Route{balanced}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+	reload := func(routes ...Route) {
+		t.Helper()
+		server.Routes = routes
+		err := server.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := get("/"); got != "a" {
+		t.Fatalf("response %q, want %q", got, "a")
+	}
+
+	// the route not changed keeps balancing from where it was, whatever the
+	// limits of its throttle and the other routes
+	limited := balanced
+	limited.Throttle = &Throttle{Id: "t1", Download: 2 << 20}
+	reload(limited, Route{Address: addr, Path: "/other/", Target: a.Listener.Addr().String()})
+	if got := get("/"); got != "b" {
+		t.Fatalf("response %q after the reload, want %q", got, "b")
+	}
+	stats := server.Throttles()
+	if len(stats) != 1 || stats[0].Download != 2<<20 {
+		t.Fatalf("throttles %v, want the limits of the reload", stats)
+	}
+
+	// the changed route starts over
+	changed := limited
+	changed.Timeout.ResponseHeader = time.Minute
+	reload(changed)
+	if got := get("/"); got != "a" {
+		t.Fatalf("response %q of the changed route, want %q", got, "a")
+	}
+}
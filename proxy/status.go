@@ -0,0 +1,31 @@
+package proxy
+
+import "github.com/csby/gwsf/gtype"
+
+type Status int
+
+const (
+	StatusStopped Status = 0
+	StatusRunning Status = 1
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusStopped:
+		return "stopped"
+	case StatusRunning:
+		return "running"
+	default:
+		return "unknown"
+	}
+}
+
+type Result struct {
+	Status    Status          `json:"status" note:"状态: 0-已停止; 1-运行中"`
+	StartTime *gtype.DateTime `json:"startTime" note:"启动时间"`
+	Backends  []*Backend      `json:"backends" note:"已隔离的后端"`
+
+	Resolutions []*Resolution  `json:"resolutions" note:"目标域名的解析结果"`
+	WebSocket   *WebSocketStat `json:"webSocket" note:"WebSocket统计，自程序启动起累计"`
+	Limits      *LimitStat     `json:"limits" note:"请求限制的拒绝统计，自程序启动起累计"`
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// Termination is the certificate of a TLS server which terminates the TLS
+// connections and routes the requests by host and path like a http server.
+// The client certificates are verified with the certificate authorities of the
+// client ca file and checked against the revocation lists of the crl file;
+// they are requested only unless required, so that each route decides.
+type Termination struct {
+	CertFile          string
+	KeyFile           string
+	ClientCaFile      string
+	CrlFile           string
+	RequireClientCert bool
+}
+
+// Check loads the certificates and revocation lists as the listener does.
+func (s *Termination) Check() error {
+	_, err := s.config()
+
+	return err
+}
+
+// config returns the TLS configuration of the listener, the http server adds
+// h2 to the application protocols when HTTP/2 is enabled.
+func (s *Termination) config() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate '%s' fail: %v", s.CertFile, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(s.ClientCaFile) < 1 {
+		return config, nil
+	}
+
+	cas, err := loadClientCas(s.ClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	for i := 0; i < len(cas); i++ {
+		config.ClientCAs.AddCert(cas[i])
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if s.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(s.CrlFile) > 0 {
+		verifier, err := newClientVerifier(s.CrlFile, cas)
+		if err != nil {
+			return nil, fmt.Errorf("load crl '%s' fail: %v", s.CrlFile, err)
+		}
+		config.VerifyConnection = verifier.verify
+	}
+
+	return config, nil
+}
@@ -0,0 +1,504 @@
+package proxy
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ThrottleServer = "server"
+	ThrottleClient = "client"
+	ThrottleTarget = "target"
+
+	maxThrottleChunk = 16 << 10
+	minThrottleChunk = 512
+)
+
+// Throttle caps the throughput in bytes per second, zero means no limit, and
+// allows a burst of bytes after an idle time, which is one second of the rate
+// when zero. The throttle of a server applies to all its client connections
+// together, the one of the clients to the connections of each client ip
+// address, and the one of a target to its connections to the backends.
+type Throttle struct {
+	Id       string
+	Upload   int64
+	Download int64
+	Burst    int64
+}
+
+type ThrottleStat struct {
+	Scope         string                `json:"scope" note:"范围: server-服务器; client-服务器的每个客户端IP; target-目标"`
+	Id            string                `json:"id" note:"服务器或目标标识ID"`
+	Upload        int64                 `json:"upload" note:"上传速率上限，单位字节/秒，0表示不限制"`
+	Download      int64                 `json:"download" note:"下载速率上限，单位字节/秒，0表示不限制"`
+	Burst         int64                 `json:"burst" note:"突发流量，单位字节，0表示1秒的速率"`
+	Active        int64                 `json:"active" note:"当前连接数"`
+	UploadBytes   uint64                `json:"uploadBytes" note:"已上传的字节数"`
+	DownloadBytes uint64                `json:"downloadBytes" note:"已下载的字节数"`
+	UploadDelay   int64                 `json:"uploadDelay" note:"上传被限速延迟的累计时间，单位毫秒"`
+	DownloadDelay int64                 `json:"downloadDelay" note:"下载被限速延迟的累计时间，单位毫秒"`
+	Clients       []*ThrottleClientStat `json:"clients,omitempty" note:"当前的客户端，仅范围为client时有效"`
+}
+
+type ThrottleClientStat struct {
+	IP            string `json:"ip" note:"客户端IP地址"`
+	Active        int64  `json:"active" note:"当前连接数"`
+	UploadBytes   uint64 `json:"uploadBytes" note:"已上传的字节数"`
+	DownloadBytes uint64 `json:"downloadBytes" note:"已下载的字节数"`
+}
+
+// bucket is a token bucket which goes into debt, so the caller waits until the
+// bytes it has taken are paid back and concurrent callers are served in order.
+type bucket struct {
+	mutex  sync.Mutex
+	rate   int64
+	burst  int64
+	tokens float64
+	last   time.Time
+
+	limited atomic.Bool
+	bytes   atomic.Uint64
+	delay   atomic.Int64
+	total   *bucket // counting the bytes of all clients
+}
+
+func newBucket(rate, burst int64, total *bucket) *bucket {
+	instance := &bucket{total: total}
+	instance.set(rate, burst)
+
+	return instance
+}
+
+func (s *bucket) set(rate, burst int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rate <= 0 || s.last.IsZero() {
+		s.last = time.Now()
+		s.tokens = float64(capacity(rate, burst))
+	}
+	s.rate = rate
+	s.burst = burst
+	if s.tokens > float64(capacity(rate, burst)) {
+		s.tokens = float64(capacity(rate, burst))
+	}
+	s.limited.Store(rate > 0)
+}
+
+func capacity(rate, burst int64) int64 {
+	if burst > 0 {
+		return burst
+	}
+
+	return rate
+}
+
+// chunk returns the bytes to transfer at once, which keeps the flow smooth.
+func (s *bucket) chunk() int {
+	s.mutex.Lock()
+	size := capacity(s.rate, s.burst)
+	s.mutex.Unlock()
+
+	if size < minThrottleChunk {
+		return minThrottleChunk
+	}
+	if size > maxThrottleChunk {
+		return maxThrottleChunk
+	}
+
+	return int(size)
+}
+
+// take returns the time to wait before the bytes may be transferred.
+func (s *bucket) take(n int) time.Duration {
+	s.bytes.Add(uint64(n))
+	if s.total != nil {
+		s.total.bytes.Add(uint64(n))
+	}
+	if !s.limited.Load() {
+		return 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * float64(s.rate)
+	limit := float64(capacity(s.rate, s.burst))
+	if s.tokens > limit {
+		s.tokens = limit
+	}
+	s.last = now
+	s.tokens -= float64(n)
+	if s.tokens >= 0 {
+		return 0
+	}
+
+	delay := time.Duration(-s.tokens / float64(s.rate) * float64(time.Second))
+	s.delay.Add(int64(delay))
+	if s.total != nil {
+		s.total.delay.Add(int64(delay))
+	}
+
+	return delay
+}
+
+type throttleClient struct {
+	upload   *bucket
+	download *bucket
+	active   int64
+}
+
+// throttle holds the buckets of a server or a target, or the ones of each
+// client ip address which exist while the client is connected.
+type throttle struct {
+	scope    string
+	upload   *bucket
+	download *bucket
+	active   atomic.Int64
+
+	mutex   sync.Mutex
+	config  Throttle
+	clients map[string]*throttleClient
+}
+
+func newThrottle(scope string, config *Throttle) *throttle {
+	instance := &throttle{
+		scope:   scope,
+		config:  *config,
+		clients: make(map[string]*throttleClient),
+	}
+	if scope == ThrottleClient {
+		// the buckets of the scope count the bytes of the clients only
+		instance.upload = newBucket(0, 0, nil)
+		instance.download = newBucket(0, 0, nil)
+	} else {
+		instance.upload = newBucket(config.Upload, config.Burst, nil)
+		instance.download = newBucket(config.Download, config.Burst, nil)
+	}
+
+	return instance
+}
+
+func (s *throttle) set(config *Throttle) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.config = *config
+	if s.scope != ThrottleClient {
+		s.upload.set(config.Upload, config.Burst)
+		s.download.set(config.Download, config.Burst)
+		return
+	}
+	for _, client := range s.clients {
+		client.upload.set(config.Upload, config.Burst)
+		client.download.set(config.Download, config.Burst)
+	}
+}
+
+// attach returns the buckets of a connection from the ip address, and the
+// function releasing them when the connection is closed.
+func (s *throttle) attach(ip string) (*bucket, *bucket, func()) {
+	s.active.Add(1)
+	if s.scope != ThrottleClient {
+		return s.upload, s.download, func() {
+			s.active.Add(-1)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	client, ok := s.clients[ip]
+	if !ok {
+		client = &throttleClient{
+			upload:   newBucket(s.config.Upload, s.config.Burst, s.upload),
+			download: newBucket(s.config.Download, s.config.Burst, s.download),
+		}
+		s.clients[ip] = client
+	}
+	client.active++
+
+	return client.upload, client.download, func() {
+		s.active.Add(-1)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		client.active--
+		if client.active < 1 {
+			delete(s.clients, ip)
+		}
+	}
+}
+
+func (s *throttle) stat() *ThrottleStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &ThrottleStat{
+		Scope:         s.scope,
+		Id:            s.config.Id,
+		Upload:        s.config.Upload,
+		Download:      s.config.Download,
+		Burst:         s.config.Burst,
+		Active:        s.active.Load(),
+		UploadBytes:   s.upload.bytes.Load(),
+		DownloadBytes: s.download.bytes.Load(),
+		UploadDelay:   time.Duration(s.upload.delay.Load()).Milliseconds(),
+		DownloadDelay: time.Duration(s.download.delay.Load()).Milliseconds(),
+	}
+	if s.scope == ThrottleClient {
+		stat.Clients = make([]*ThrottleClientStat, 0, len(s.clients))
+		for ip, client := range s.clients {
+			stat.Clients = append(stat.Clients, &ThrottleClientStat{
+				IP:            ip,
+				Active:        client.active,
+				UploadBytes:   client.upload.bytes.Load(),
+				DownloadBytes: client.download.bytes.Load(),
+			})
+		}
+		sort.Slice(stat.Clients, func(i, j int) bool {
+			return stat.Clients[i].IP < stat.Clients[j].IP
+		})
+	}
+
+	return stat
+}
+
+// throttleSet keeps the throttles by scope and id across restarts, so the
+// limits changed at runtime and the counters survive until the next start
+// leaves them unused.
+type throttleSet struct {
+	mutex sync.Mutex
+	items map[string]*throttle
+	used  map[string]bool
+}
+
+func newThrottleSet() *throttleSet {
+	return &throttleSet{
+		items: make(map[string]*throttle),
+		used:  make(map[string]bool),
+	}
+}
+
+func (s *throttleSet) begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.used = make(map[string]bool)
+}
+
+// use returns the throttle of the scope, the limits of an existing one are
+// replaced with the config.
+func (s *throttleSet) use(scope string, config *Throttle) *throttle {
+	if config == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := scope + "/" + config.Id
+	s.used[key] = true
+	item, ok := s.items[key]
+	if ok {
+		item.set(config)
+		return item
+	}
+	item = newThrottle(scope, config)
+	s.items[key] = item
+
+	return item
+}
+
+// end removes the throttles not used since begin.
+func (s *throttleSet) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.items {
+		if !s.used[key] {
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *throttleSet) set(scope string, config *Throttle) bool {
+	s.mutex.Lock()
+	item, ok := s.items[scope+"/"+config.Id]
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	item.set(config)
+
+	return true
+}
+
+func (s *throttleSet) list() []*ThrottleStat {
+	s.mutex.Lock()
+	items := make([]*throttle, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	s.mutex.Unlock()
+
+	stats := make([]*ThrottleStat, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		stats = append(stats, items[i].stat())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Scope != stats[j].Scope {
+			return stats[i].Scope > stats[j].Scope
+		}
+		return stats[i].Id < stats[j].Id
+	})
+
+	return stats
+}
+
+// throttledConn delays the data read and written by the buckets, the data
+// read is uploaded by the client of an accepted connection while it is
+// downloaded from the backend of a dialed one.
+type throttledConn struct {
+	net.Conn
+
+	reads    []*bucket
+	writes   []*bucket
+	releases []func()
+	done     chan struct{}
+	once     sync.Once
+}
+
+// throttleConn wraps the connection with the throttles which are not nil, the
+// dialed connection to a backend is throttled in reverse.
+func throttleConn(conn net.Conn, dialed bool, throttles ...*throttle) net.Conn {
+	instance := &throttledConn{
+		Conn: conn,
+		done: make(chan struct{}),
+	}
+	ip := ""
+	if conn.RemoteAddr() != nil {
+		ip = hostName(conn.RemoteAddr().String())
+	}
+	for i := 0; i < len(throttles); i++ {
+		if throttles[i] == nil {
+			continue
+		}
+		upload, download, release := throttles[i].attach(ip)
+		if dialed {
+			instance.reads = append(instance.reads, download)
+			instance.writes = append(instance.writes, upload)
+		} else {
+			instance.reads = append(instance.reads, upload)
+			instance.writes = append(instance.writes, download)
+		}
+		instance.releases = append(instance.releases, release)
+	}
+	if len(instance.releases) < 1 {
+		return conn
+	}
+
+	return instance
+}
+
+func (s *throttledConn) Read(p []byte) (int, error) {
+	if len(p) > 0 {
+		p = p[:chunkOf(s.reads, len(p))]
+	}
+	n, err := s.Conn.Read(p)
+	if n > 0 {
+		s.wait(s.reads, n)
+	}
+
+	return n, err
+}
+
+func (s *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := chunkOf(s.writes, len(p))
+		if !s.wait(s.writes, size) {
+			return written, net.ErrClosed
+		}
+		n, err := s.Conn.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+
+	return written, nil
+}
+
+func (s *throttledConn) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		for i := 0; i < len(s.releases); i++ {
+			s.releases[i]()
+		}
+	})
+
+	return s.Conn.Close()
+}
+
+// wait takes the bytes from all the buckets and waits for the longest of
+// them, which returns false when the connection is closed in the meantime.
+func (s *throttledConn) wait(buckets []*bucket, n int) bool {
+	delay := time.Duration(0)
+	for i := 0; i < len(buckets); i++ {
+		d := buckets[i].take(n)
+		if d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func chunkOf(buckets []*bucket, size int) int {
+	for i := 0; i < len(buckets); i++ {
+		if !buckets[i].limited.Load() {
+			continue
+		}
+		chunk := buckets[i].chunk()
+		if chunk < size {
+			size = chunk
+		}
+	}
+
+	return size
+}
+
+// throttleListener throttles the accepted connections by the throttles of the
+// server and its clients.
+type throttleListener struct {
+	net.Listener
+
+	server *throttle
+	client *throttle
+}
+
+func (s *throttleListener) Accept() (net.Conn, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return throttleConn(conn, false, s.server, s.client), nil
+}
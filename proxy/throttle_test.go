@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBucketRateAndBurst(t *testing.T) {
+	items := []struct {
+		name  string
+		rate  int64
+		burst int64
+		takes []int
+		delay time.Duration // of the last take
+	}{
+		{"within the burst", 100 << 10, 20 << 10, []int{20 << 10}, 0},
+		{"over the burst", 100 << 10, 20 << 10, []int{20 << 10, 10 << 10}, 100 * time.Millisecond},
+		{"debt of the waiting ones", 100 << 10, 20 << 10, []int{20 << 10, 10 << 10, 10 << 10}, 200 * time.Millisecond},
+		{"one second without burst", 10 << 10, 0, []int{10 << 10, 5 << 10}, 500 * time.Millisecond},
+		{"no limit", 0, 0, []int{1 << 20, 1 << 20}, 0},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		b := newBucket(item.rate, item.burst, nil)
+		delay := time.Duration(0)
+		for j := 0; j < len(item.takes); j++ {
+			delay = b.take(item.takes[j])
+		}
+		if delay < item.delay-10*time.Millisecond || delay > item.delay+10*time.Millisecond {
+			t.Errorf("%s: delay %v, want %v", item.name, delay, item.delay)
+		}
+	}
+}
+
+func TestThrottleBuckets(t *testing.T) {
+	// the connections of all the clients share the buckets of a server
+	server := newThrottle(ThrottleServer, &Throttle{Id: "s1", Download: 100 << 10})
+	upload, download, release := server.attach("10.0.0.1")
+	otherUpload, otherDownload, otherRelease := server.attach("10.0.0.2")
+	if upload != otherUpload || download != otherDownload {
+		t.Fatal("the clients of the server have buckets of their own")
+	}
+	release()
+	otherRelease()
+
+	// each client ip address has its own ones, counted by the scope too
+	client := newThrottle(ThrottleClient, &Throttle{Id: "s1", Download: 100 << 10, Burst: 10 << 10})
+	_, first, releaseFirst := client.attach("10.0.0.1")
+	_, again, releaseAgain := client.attach("10.0.0.1")
+	_, second, releaseSecond := client.attach("10.0.0.2")
+	if first != again || first == second {
+		t.Fatal("the buckets are not the ones of each client ip address")
+	}
+	if first.take(10<<10) != 0 || second.take(10<<10) != 0 {
+		t.Fatal("a client is limited by the bytes of the other one")
+	}
+	if first.take(10<<10) == 0 {
+		t.Fatal("the client is not limited over its burst")
+	}
+	stat := client.stat()
+	if stat.Active != 3 || stat.DownloadBytes != 30<<10 || len(stat.Clients) != 2 || stat.Clients[0].DownloadBytes != 20<<10 {
+		t.Fatalf("stat %+v, clients %+v", stat, stat.Clients)
+	}
+
+	// the buckets of a client are released with its last connection
+	releaseFirst()
+	releaseAgain()
+	releaseSecond()
+	if stat := client.stat(); stat.Active != 0 || len(stat.Clients) != 0 {
+		t.Fatalf("stat %+v after the connections are closed", stat)
+	}
+}
+
+// streamTarget returns a tcp target which sends the bytes to each connection.
+func streamTarget(t *testing.T, size int) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write(make([]byte, size))
+			}()
+		}
+	}()
+
+	return ln
+}
+
+// download returns the time taken to read the whole stream from the address,
+// the connection is made from the local ip address.
+func download(t *testing.T, addr, ip string, size int) time.Duration {
+	t.Helper()
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer conn.Close()
+	n, _ := io.Copy(io.Discard, conn)
+	if n != int64(size) {
+		t.Errorf("%d bytes downloaded, want %d", n, size)
+	}
+
+	return time.Since(start)
+}
+
+func TestThrottleConnections(t *testing.T) {
+	size := 60 << 10
+	target := streamTarget(t, size)
+	defer target.Close()
+
+	items := []struct {
+		name    string
+		server  *Throttle
+		client  *Throttle
+		clients []string
+		min     time.Duration
+		max     time.Duration
+	}{
+		// 120KB at 200KB/s after a burst of 20KB
+		{"shared by the clients", &Throttle{Id: "s1", Download: 200 << 10, Burst: 20 << 10}, nil,
+			[]string{"127.0.0.1", "127.0.0.2"}, 400 * time.Millisecond, 900 * time.Millisecond},
+		// 60KB at 200KB/s after a burst of 20KB, each
+		{"each client", nil, &Throttle{Id: "s1", Download: 200 << 10, Burst: 20 << 10},
+			[]string{"127.0.0.1", "127.0.0.2"}, 150 * time.Millisecond, 350 * time.Millisecond},
+	}
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		addr := freeAddr(t)
+		server := &Server{Routes: []Route{{
+			Mode:           ModeTcp,
+			Address:        addr,
+			Target:         target.Addr().String(),
+			ServerThrottle: item.server,
+			ClientThrottle: item.client,
+		}}}
+		err := server.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wait sync.WaitGroup
+		start := time.Now()
+		for j := 0; j < len(item.clients); j++ {
+			wait.Add(1)
+			go func(ip string) {
+				defer wait.Done()
+				download(t, addr, ip, size)
+			}(item.clients[j])
+		}
+		wait.Wait()
+		elapsed := time.Since(start)
+		server.Stop()
+		if elapsed < item.min || elapsed > item.max {
+			t.Fatalf("%s: downloaded in %v, want %v to %v", item.name, elapsed, item.min, item.max)
+		}
+	}
+}
+
+func TestThrottleSetLive(t *testing.T) {
+	size := 300 << 10
+	target := streamTarget(t, size)
+	defer target.Close()
+
+	addr := freeAddr(t)
+	server := &Server{Routes: []Route{{
+		Mode:           ModeTcp,
+		Address:        addr,
+		Target:         target.Addr().String(),
+		ServerThrottle: &Throttle{Id: "s1", Download: 50 << 10, Burst: 10 << 10},
+	}}}
+	err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// the download of six seconds is sped up by the new limit at once
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		if !server.SetThrottle(ThrottleServer, Throttle{Id: "s1", Download: 10 << 20}) {
+			t.Error("no throttle of the server")
+		}
+	}()
+	if elapsed := download(t, addr, "127.0.0.1", size); elapsed > 2*time.Second {
+		t.Fatalf("downloaded in %v after the limit is raised", elapsed)
+	}
+	stats := server.Throttles()
+	if len(stats) != 1 || stats[0].Download != 10<<20 || stats[0].DownloadBytes != uint64(size) {
+		t.Fatalf("throttle stats %+v, want the one of the new limit", stats)
+	}
+}
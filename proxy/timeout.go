@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Timeout holds the timeouts of a route, zero means the default for the
+// connect timeout and no limit for the others; the read header and keep
+// alive timeouts of http routes apply to the whole listener.
+type Timeout struct {
+	Connect        time.Duration
+	Idle           time.Duration
+	Lifetime       time.Duration
+	ReadHeader     time.Duration
+	KeepAlive      time.Duration
+	ResponseHeader time.Duration
+}
+
+func (s *Timeout) connect() time.Duration {
+	if s.Connect > 0 {
+		return s.Connect
+	}
+
+	return dialTimeout
+}
+
+// activity records the last time data was transferred in either direction.
+type activity struct {
+	last atomic.Int64
+}
+
+func (s *activity) touch() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+func (s *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, s.last.Load()))
+}
+
+// copyIdle copies from src to dst until either side fails, or no data has been
+// transferred in both directions within the idle timeout.
+func copyIdle(dst, src net.Conn, idle time.Duration, act *activity) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			act.touch()
+			if idle > 0 {
+				dst.SetWriteDeadline(time.Now().Add(idle))
+			}
+			_, e := dst.Write(buf[:n])
+			if e != nil {
+				return e
+			}
+		}
+		if err != nil {
+			var ne net.Error
+			if idle > 0 && errors.As(err, &ne) && ne.Timeout() && act.idle() < idle {
+				continue
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtensionServerName  = 0x0000
+)
+
+// readClientHello reads the first TLS record from the reader and returns the
+// server name (SNI) of the client hello along with the bytes read, which must
+// be replayed to the target.
+func readClientHello(r io.Reader) (string, []byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
+	}
+	if header[0] != tlsRecordHandshake {
+		return "", header, fmt.Errorf("not a tls handshake record")
+	}
+
+	length := int(header[3])<<8 | int(header[4])
+	data := make([]byte, 5+length)
+	copy(data, header)
+	_, err = io.ReadFull(r, data[5:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return parseServerName(data[5:]), data, nil
+}
+
+func parseServerName(data []byte) string {
+	p := &tlsParser{data: data}
+	if p.uint8() != tlsHandshakeClientHello {
+		return ""
+	}
+	p.skip(3)  // length
+	p.skip(34) // version and random
+	p.skip(p.uint8())
+	p.skip(p.uint16())
+	p.skip(p.uint8())
+
+	extensions := &tlsParser{data: p.bytes(p.uint16())}
+	for extensions.ok() && len(extensions.data) > 0 {
+		kind := extensions.uint16()
+		extension := &tlsParser{data: extensions.bytes(extensions.uint16())}
+		if kind != tlsExtensionServerName {
+			continue
+		}
+
+		names := &tlsParser{data: extension.bytes(extension.uint16())}
+		for names.ok() && len(names.data) > 0 {
+			nameType := names.uint8()
+			name := names.bytes(names.uint16())
+			if nameType == 0 && names.ok() {
+				return string(name)
+			}
+		}
+	}
+
+	return ""
+}
+
+type tlsParser struct {
+	data []byte
+	err  bool
+}
+
+func (s *tlsParser) ok() bool {
+	return !s.err
+}
+
+func (s *tlsParser) bytes(n int) []byte {
+	if s.err || n < 0 || n > len(s.data) {
+		s.err = true
+		return nil
+	}
+
+	v := s.data[:n]
+	s.data = s.data[n:]
+
+	return v
+}
+
+func (s *tlsParser) skip(n int) {
+	s.bytes(n)
+}
+
+func (s *tlsParser) uint8() int {
+	v := s.bytes(1)
+	if v == nil {
+		return -1
+	}
+
+	return int(v[0])
+}
+
+func (s *tlsParser) uint16() int {
+	v := s.bytes(2)
+	if v == nil {
+		return 0
+	}
+
+	return int(v[0])<<8 | int(v[1])
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// UnixPrefix marks an address as the path of a unix domain socket.
+const UnixPrefix = "unix:"
+
+// Socket holds the file settings of a unix domain socket listener, zero mode
+// keeps the default and the id -1 keeps the owner or group unchanged.
+type Socket struct {
+	Mode os.FileMode
+	Uid  int
+	Gid  int
+}
+
+func (s *Socket) apply(path string) error {
+	if s.Mode != 0 {
+		err := os.Chmod(path, s.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Uid != -1 || s.Gid != -1 {
+		return os.Lchown(path, s.Uid, s.Gid)
+	}
+
+	return nil
+}
+
+// splitAddress returns the network and address to listen on or dial, the
+// address of a unix domain socket is its path.
+func splitAddress(network, address string) (string, string) {
+	if !strings.HasPrefix(address, UnixPrefix) {
+		return network, address
+	}
+
+	return "unix", strings.TrimPrefix(address, UnixPrefix)
+}
+
+// listenStream listens on the address of the group, a stale socket file left
+// by a previous run is removed before listening on a unix domain socket.
+func listenStream(group *routeGroup) (net.Listener, error) {
+	network, address := splitAddress(group.listenNetwork(), group.address)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	removeStaleSocket(address)
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if group.socket != nil {
+		err = group.socket.apply(address)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
+
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		// still in use, listening fails later on
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	UpgradeWebSocket = "websocket"
+)
+
+// WebSocket holds the settings of the upgraded connections of a route, the
+// idle timeout applies instead of the ones of http requests, zero means no limit.
+type WebSocket struct {
+	Deny bool
+	Idle time.Duration
+}
+
+type WebSocketStat struct {
+	Upgrades     uint64 `json:"upgrades" note:"已升级的连接数"`
+	Denied       uint64 `json:"denied" note:"已拒绝的升级请求数"`
+	Active       int64  `json:"active" note:"当前连接数"`
+	ClientFrames uint64 `json:"clientFrames" note:"客户端发送的帧数"`
+	ClientBytes  uint64 `json:"clientBytes" note:"客户端发送的字节数"`
+	TargetFrames uint64 `json:"targetFrames" note:"目标发送的帧数"`
+	TargetBytes  uint64 `json:"targetBytes" note:"目标发送的字节数"`
+}
+
+// isWebSocket reports whether the request asks for upgrading to websocket.
+func isWebSocket(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), UpgradeWebSocket) {
+		return false
+	}
+
+	values := r.Header.Values("Connection")
+	for i := 0; i < len(values); i++ {
+		tokens := strings.Split(values[i], ",")
+		for j := 0; j < len(tokens); j++ {
+			if strings.EqualFold(strings.TrimSpace(tokens[j]), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type webSocketCounter struct {
+	upgrades     atomic.Uint64
+	denied       atomic.Uint64
+	active       atomic.Int64
+	clientFrames atomic.Uint64
+	clientBytes  atomic.Uint64
+	targetFrames atomic.Uint64
+	targetBytes  atomic.Uint64
+}
+
+func (s *webSocketCounter) stat() *WebSocketStat {
+	return &WebSocketStat{
+		Upgrades:     s.upgrades.Load(),
+		Denied:       s.denied.Load(),
+		Active:       s.active.Load(),
+		ClientFrames: s.clientFrames.Load(),
+		ClientBytes:  s.clientBytes.Load(),
+		TargetFrames: s.targetFrames.Load(),
+		TargetBytes:  s.targetBytes.Load(),
+	}
+}
+
+// webSocketConn wraps the upgraded connection to the target, the data read
+// is sent by the target and the data written is sent by the client.
+type webSocketConn struct {
+	io.ReadWriteCloser
+
+	counter *webSocketCounter
+	client  frameCounter
+	target  frameCounter
+	act     activity
+	idle    time.Duration
+	timer   *time.Timer
+	once    sync.Once
+}
+
+func newWebSocketConn(conn io.ReadWriteCloser, counter *webSocketCounter, idle time.Duration) *webSocketConn {
+	instance := &webSocketConn{
+		ReadWriteCloser: conn,
+		counter:         counter,
+		idle:            idle,
+	}
+	instance.act.touch()
+	counter.upgrades.Add(1)
+	counter.active.Add(1)
+	if idle > 0 {
+		instance.timer = time.AfterFunc(idle, instance.watch)
+	}
+
+	return instance
+}
+
+func (s *webSocketConn) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	if n > 0 {
+		s.act.touch()
+		s.counter.targetBytes.Add(uint64(n))
+		s.counter.targetFrames.Add(s.target.count(p[:n]))
+	}
+
+	return n, err
+}
+
+func (s *webSocketConn) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	if n > 0 {
+		s.act.touch()
+		s.counter.clientBytes.Add(uint64(n))
+		s.counter.clientFrames.Add(s.client.count(p[:n]))
+	}
+
+	return n, err
+}
+
+func (s *webSocketConn) Close() error {
+	var err error = nil
+	s.once.Do(func() {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.counter.active.Add(-1)
+		err = s.ReadWriteCloser.Close()
+	})
+
+	return err
+}
+
+// watch closes the connection when no data has been transferred in both
+// directions within the idle timeout, which ends the copying of both sides.
+func (s *webSocketConn) watch() {
+	idle := s.act.idle()
+	if idle >= s.idle {
+		s.Close()
+		return
+	}
+
+	s.timer.Reset(s.idle - idle)
+}
+
+// frameCounter counts the frames of a websocket stream, the data may be split
+// at any position including the frame headers.
+type frameCounter struct {
+	header  []byte
+	payload uint64
+}
+
+func (s *frameCounter) count(data []byte) uint64 {
+	frames := uint64(0)
+	for len(data) > 0 {
+		if s.payload > 0 {
+			n := uint64(len(data))
+			if n > s.payload {
+				n = s.payload
+			}
+			s.payload -= n
+			data = data[n:]
+			continue
+		}
+
+		s.header = append(s.header, data[0])
+		data = data[1:]
+		size, ok := s.parse()
+		if ok {
+			frames++
+			s.header = s.header[:0]
+			s.payload = size
+		}
+	}
+
+	return frames
+}
+
+// parse returns the payload length once the frame header is complete.
+func (s *frameCounter) parse() (uint64, bool) {
+	if len(s.header) < 2 {
+		return 0, false
+	}
+
+	size := uint64(s.header[1] & 0x7f)
+	length := 2
+	switch size {
+	case 126:
+		length += 2
+	case 127:
+		length += 8
+	}
+	if s.header[1]&0x80 != 0 {
+		length += 4
+	}
+	if len(s.header) < length {
+		return 0, false
+	}
+
+	switch size {
+	case 126:
+		size = uint64(binary.BigEndian.Uint16(s.header[2:4]))
+	case 127:
+		size = binary.BigEndian.Uint64(s.header[2:10])
+	}
+
+	return size, true
+}
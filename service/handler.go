@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/csby/grps/controller"
+	"github.com/csby/grps/proxy"
 	"github.com/csby/gwsf/gopt"
 	"github.com/csby/gwsf/gtype"
 	"net/http"
@@ -11,6 +12,12 @@ import (
 func NewHandler(log gtype.Log) gtype.Handler {
 	instance := &Handler{}
 	instance.SetLog(log)
+	if cfg.ApiCors == nil {
+		// the default one, any origin is allowed
+		instance.cors = &proxy.Cors{Origins: []string{"*"}, Headers: []string{"content-type", "token"}}
+	} else {
+		instance.cors = cfg.ApiCors.Proxy()
+	}
 
 	return instance
 }
@@ -19,6 +26,7 @@ type Handler struct {
 	gtype.Base
 
 	proxyController *controller.Proxy
+	cors            *proxy.Cors
 }
 
 func (s *Handler) InitRouting(router gtype.Router) {
@@ -28,9 +36,18 @@ func (s *Handler) BeforeRouting(ctx gtype.Context) {
 	method := ctx.Method()
 
 	// enable across access
+	if s.cors != nil {
+		r := ctx.Request()
+		if proxy.IsPreflight(r) {
+			if !s.cors.Preflight(ctx.Response(), r) {
+				ctx.Response().WriteHeader(http.StatusForbidden)
+			}
+			ctx.SetHandled(true)
+			return
+		}
+		s.cors.Apply(ctx.Response().Header(), r.Header.Get("Origin"))
+	}
 	if method == "OPTIONS" {
-		ctx.Response().Header().Add("Access-Control-Allow-Origin", "*")
-		ctx.Response().Header().Set("Access-Control-Allow-Headers", "content-type,token")
 		ctx.SetHandled(true)
 		return
 	}
@@ -55,6 +72,9 @@ func (s *Handler) ExtendOptSetup(opt gtype.Option) {
 }
 
 func (s *Handler) ExtendOptApi(router gtype.Router, path *gtype.Path, preHandle gtype.HttpHandle, wsc gtype.SocketChannelCollection) {
+	if s.proxyController != nil {
+		s.proxyController.Close()
+	}
 	s.proxyController = controller.NewProxy(s.GetLog(), cfg, wsc)
 
 	// 服务
@@ -75,6 +95,22 @@ func (s *Handler) ExtendOptApi(router gtype.Router, path *gtype.Path, preHandle
 	router.POST(path.Uri("/proxy/conn/list"), preHandle,
 		s.proxyController.GetProxyLinks, s.proxyController.GetProxyLinksDoc)
 
+	// 缓存
+	router.POST(path.Uri("/proxy/cache/stat"), preHandle,
+		s.proxyController.GetProxyCacheStats, s.proxyController.GetProxyCacheStatsDoc)
+	router.POST(path.Uri("/proxy/cache/purge"), preHandle,
+		s.proxyController.PurgeProxyCache, s.proxyController.PurgeProxyCacheDoc)
+
+	// 镜像
+	router.POST(path.Uri("/proxy/mirror/stat"), preHandle,
+		s.proxyController.GetProxyMirrorStats, s.proxyController.GetProxyMirrorStatsDoc)
+
+	// 带宽
+	router.POST(path.Uri("/proxy/throttle/list"), preHandle,
+		s.proxyController.GetProxyThrottles, s.proxyController.GetProxyThrottlesDoc)
+	router.POST(path.Uri("/proxy/throttle/mod"), preHandle,
+		s.proxyController.ModifyProxyThrottle, s.proxyController.ModifyProxyThrottleDoc)
+
 	// 端口
 	router.POST(path.Uri("/proxy/server/list"), preHandle,
 		s.proxyController.GetProxyServers, s.proxyController.GetProxyServersDoc)